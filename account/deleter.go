@@ -0,0 +1,55 @@
+// Package account deletes accounts once their deletion grace period has passed
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/export"
+)
+
+// Deleter deletes the due accounts, it is run periodically by the scheduler. Accounts are claimed
+// through the database, so every instance can run it
+type Deleter struct {
+	DBService           *db.DatabaseService
+	IconUploadDirectory string
+	Exporter            *export.Exporter
+}
+
+// DeleteDue deletes the accounts whose grace period has passed one after another and returns how many
+// were deleted. An account that can't be deleted is logged and skipped until its retry. The files of an account are removed once its rows are deleted, a file left behind by a
+// failure can't be reached anymore
+func (d *Deleter) DeleteDue(ctx context.Context) (int, error) {
+	deleted := 0
+	for ctx.Err() == nil {
+		account, err := d.DBService.DeleteDueAccount(ctx)
+		if err != nil && account != nil {
+			// the account is tried again later, the others are deleted in the meantime
+			slog.ErrorContext(ctx, "error while deleting account", "user_id", account.UserId, "error", err)
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		if account == nil {
+			break
+		}
+		deleted++
+		files := []string{filepath.Join(d.IconUploadDirectory, fmt.Sprintf("icon-%s.png", account.UserId))}
+		for _, exportId := range account.ExportIds {
+			files = append(files, d.Exporter.Path(exportId))
+		}
+		for _, file := range files {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.WarnContext(ctx, "error while deleting file of deleted account", "file", file, "error", err)
+			}
+		}
+		slog.InfoContext(ctx, "account deleted", "user_id", account.UserId)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/ical"
+	"github.com/google/uuid"
+)
+
+// calendarRefreshInterval is how often calendar clients are asked to poll the feed
+const calendarRefreshInterval = "PT1H"
+
+type CalendarHandler struct {
+	DBService *db.DatabaseService
+	// PublicURL is the base of the feed URLs, when empty it is taken from the request
+	PublicURL string
+}
+
+func (c *CalendarHandler) GetFeed(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	feed, err := c.DBService.GetCalendarFeed(r.Context(), userId)
+	if errors.Is(err, db.ErrCalendarFeedNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, c.feedResponse(r, feed))
+}
+
+// RotateFeed creates the feed or replaces its secret, the previous URL stops working
+func (c *CalendarHandler) RotateFeed(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	feed, err := c.DBService.RotateCalendarFeed(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, c.feedResponse(r, feed))
+}
+
+func (c *CalendarHandler) DeleteFeed(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	err := c.DBService.DeleteCalendarFeed(r.Context(), userId)
+	if errors.Is(err, db.ErrCalendarFeedNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+// ServeFeed serves the .ics feed, the secret in the path is the only authentication.
+// Tasks are published as events unless type=todo, completed tasks only with completed=true
+func (c *CalendarHandler) ServeFeed(w http.ResponseWriter, r *http.Request) {
+	redactPath(r.Context())
+	token, ok := strings.CutSuffix(r.PathValue("file"), ".ics")
+	if !ok || token == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	entryType := r.URL.Query().Get("type")
+	if entryType == "" {
+		entryType = "event"
+	}
+	if entryType != "event" && entryType != "todo" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("type must be event or todo"))
+		return
+	}
+	includeCompleted := r.URL.Query().Get("completed") == "true"
+
+	tasks, err := c.DBService.GetCalendarFeedTasks(r.Context(), token, includeCompleted)
+	if errors.Is(err, db.ErrCalendarFeedNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var body bytes.Buffer
+	if err := writeCalendar(&body, tasks, entryType == "todo"); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("error while constructing calendar"))
+		return
+	}
+	// calendar clients poll the feed, the hash of the body lets them skip unchanged feeds
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if notModified(w, r, etag) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="taskjournal.ics"`)
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
+
+func (c *CalendarHandler) feedResponse(r *http.Request, feed *db.CalendarFeedDB) db.CalendarFeed {
+	return db.CalendarFeed{
+		Url:       publicBaseURL(r, c.PublicURL) + strings.TrimSuffix(r.URL.Path, "/") + "/" + feed.Token + ".ics",
+		CreatedAt: feed.CreatedAt,
+	}
+}
+
+// publicBaseURL returns the configured public URL, or the scheme and host the request was sent to
+func publicBaseURL(r *http.Request, configured string) string {
+	if configured != "" {
+		return strings.TrimSuffix(configured, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func writeCalendar(w *bytes.Buffer, tasks []db.TaskDB, todos bool) error {
+	cal := ical.NewWriter(w)
+	cal.Begin("VCALENDAR")
+	cal.Property("VERSION", "2.0")
+	cal.Property("PRODID", "-//TaskJournal//TaskJournal//EN")
+	cal.Property("CALSCALE", "GREGORIAN")
+	cal.Property("METHOD", "PUBLISH")
+	cal.Text("NAME", "TaskJournal")
+	cal.Text("X-WR-CALNAME", "TaskJournal")
+	cal.Property("REFRESH-INTERVAL;VALUE=DURATION", calendarRefreshInterval)
+	cal.Property("X-PUBLISHED-TTL", calendarRefreshInterval)
+	for _, task := range tasks {
+		component := "VEVENT"
+		if todos {
+			component = "VTODO"
+		}
+		cal.Begin(component)
+		cal.Text("UID", task.Id.String())
+		cal.Time("DTSTAMP", task.UpdatedAt)
+		cal.Time("CREATED", task.Created_at)
+		cal.Time("LAST-MODIFIED", task.UpdatedAt)
+		cal.Property("SEQUENCE", strconv.FormatInt(task.Version, 10))
+		cal.Text("SUMMARY", task.TaskName)
+		if task.TaskDesc != "" {
+			cal.Text("DESCRIPTION", task.TaskDesc)
+		}
+		cal.Text("CATEGORIES", task.TaskIcon)
+		if task.Starred {
+			cal.Property("PRIORITY", "1")
+		}
+		completed := task.Exec_status != "ACTIVE"
+		if todos {
+			cal.Time("DUE", *task.Deadline)
+			if completed {
+				cal.Property("STATUS", "COMPLETED")
+				cal.Property("PERCENT-COMPLETE", "100")
+				cal.Time("COMPLETED", task.UpdatedAt)
+			} else {
+				cal.Property("STATUS", "NEEDS-ACTION")
+			}
+		} else {
+			// an event without DTEND ends when it starts, which is what a deadline is
+			cal.Time("DTSTART", *task.Deadline)
+			cal.Property("TRANSP", "TRANSPARENT")
+		}
+		cal.End(component)
+	}
+	cal.End("VCALENDAR")
+	return cal.Err()
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/google/uuid"
+)
+
+const defaultCommentPageSize = 20
+
+type CommentHandler struct {
+	DBService *db.DatabaseService
+}
+
+// commentPage reads the size of a page of comments and the id of the comment it starts before from the
+// query parameters limitName and beforeName
+func commentPage(w http.ResponseWriter, r *http.Request, limitName string, beforeName string) (int, *uuid.UUID, bool) {
+	return page(w, r, limitName, beforeName, defaultCommentPageSize, db.MaxCommentPageSize)
+}
+
+// page reads the size of a keyset page, between 1 and maxLimit, and the id of the item it starts before
+func page(w http.ResponseWriter, r *http.Request, limitName string, beforeName string, defaultLimit int, maxLimit int) (int, *uuid.UUID, bool) {
+	limit := defaultLimit
+	if value := r.URL.Query().Get(limitName); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(limitName + " must be between 1 and " + strconv.Itoa(maxLimit)))
+			return 0, nil, false
+		}
+		limit = parsed
+	}
+	value := r.URL.Query().Get(beforeName)
+	if value == "" {
+		return limit, nil, true
+	}
+	before, err := uuid.Parse(value)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(beforeName + " must be a uuid"))
+		return 0, nil, false
+	}
+	return limit, &before, true
+}
+
+// writeCommentError writes the errors of comments with their status
+func writeCommentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrCommentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	WriteDBError(w, err, http.StatusBadRequest, err.Error())
+}
+
+// GetComments returns a page of the comments of a task from the newest
+func (c *CommentHandler) GetComments(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	taskId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	limit, before, ok := commentPage(w, r, "limit", "before")
+	if !ok {
+		return
+	}
+	page, err := c.DBService.GetTaskComments(r.Context(), taskId, userId, limit, before)
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (c *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	taskId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	var comment db.TaskCommentPost
+	err := json.NewDecoder(r.Body).Decode(&comment)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+		return
+	}
+	created, err := c.DBService.CreateTaskComment(r.Context(), taskId, comment, userId)
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// UpdateComment changes the body of a comment, only its author can
+func (c *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	commentId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	var comment db.TaskCommentPost
+	err := json.NewDecoder(r.Body).Decode(&comment)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+		return
+	}
+	updated, err := c.DBService.UpdateTaskComment(r.Context(), commentId, comment, userId)
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DeleteComment deletes a comment, only its author can
+func (c *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	commentId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	err := c.DBService.DeleteTaskComment(r.Context(), commentId, userId)
+	if err != nil {
+		writeCommentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
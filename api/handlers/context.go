@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/google/uuid"
+)
+
+type contextKey int
+
+const (
+	userIdKey contextKey = iota
+	requestInfoKey
+	legacyKey
+)
+
+// StatusClientClosedRequest is the non-standard status (introduced by nginx) used when the client
+// disconnected before the response was written, it is only ever seen in logs
+const StatusClientClosedRequest = 499
+
+func WithUserId(ctx context.Context, userId uuid.UUID) context.Context {
+	if info := requestInfoFromContext(ctx); info != nil {
+		info.userId = &userId
+	}
+	return context.WithValue(ctx, userIdKey, userId)
+}
+
+// UserIdFromContext returns the id of the user authenticated by AuthMiddleware
+func UserIdFromContext(ctx context.Context) (uuid.UUID, bool) {
+	userId, ok := ctx.Value(userIdKey).(uuid.UUID)
+	return userId, ok
+}
+
+// LegacyMiddleware marks requests to the unversioned legacy routes, see IsLegacyRequest
+func LegacyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), legacyKey, true)))
+	})
+}
+
+// IsLegacyRequest reports whether the request was made to a legacy route, which keep the behaviour
+// old clients rely on (e.g. updates without If-Match)
+func IsLegacyRequest(ctx context.Context) bool {
+	legacy, _ := ctx.Value(legacyKey).(bool)
+	return legacy
+}
+
+// WriteDBError writes message with the given status, unless err was caused by the request context
+// being canceled (client disconnected) or by a database timeout, which are reported as 499 and 503.
+// A change the user isn't allowed to make (e.g. a viewer editing a task of a shared list) is reported as 403,
+// a change that kept colliding with concurrent changes as 409
+func WriteDBError(w http.ResponseWriter, err error, status int, message string) {
+	switch {
+	case errors.Is(err, db.ErrForbidden):
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+	case errors.Is(err, db.ErrConflict):
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+	case errors.Is(err, context.Canceled):
+		w.WriteHeader(StatusClientClosedRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("database operation timed out"))
+	default:
+		w.WriteHeader(status)
+		w.Write([]byte(message))
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The ETag of a task, history entry or user is its quoted row version, which a database trigger
+// increments on every update
+
+func formatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// notModified answers a conditional GET with 304 when one of the If-None-Match tags matches etag,
+// weak tags are compared without their W/ prefix
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the version an update is conditional on, nil when it is unconditional.
+// An update without If-Match is answered with 428 when required, except on the legacy routes
+// whose clients predate ETags. "*" makes the update unconditional and a tag that can't match
+// any version (weak or malformed) is answered with 412
+func ifMatchVersion(w http.ResponseWriter, r *http.Request, required bool) (*int64, bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		if required && !IsLegacyRequest(r.Context()) {
+			w.WriteHeader(http.StatusPreconditionRequired)
+			w.Write([]byte("If-Match header with the ETag of the resource is required"))
+			return nil, false
+		}
+		return nil, true
+	}
+	if ifMatch == "*" {
+		return nil, true
+	}
+	unquoted, found := strings.CutPrefix(ifMatch, `"`)
+	unquoted, closed := strings.CutSuffix(unquoted, `"`)
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if !found || !closed || err != nil {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte("If-Match must be a single strong ETag"))
+		return nil, false
+	}
+	return &version, true
+}
+
+// writePreconditionFailed answers a stale update with 412 and the current representation,
+// so the client can merge its changes without another request
+func writePreconditionFailed(w http.ResponseWriter, current any, version int64) {
+	w.Header().Set("ETag", formatETag(version))
+	writeJSON(w, http.StatusPreconditionFailed, current)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+)
+
+type EventsHandler struct {
+	Broker *events.Broker
+	// HeartbeatInterval is how often a comment is sent on an idle stream, so proxies don't close it
+	HeartbeatInterval time.Duration
+}
+
+// Stream sends the changes of the user's tasks and history as Server-Sent Events until the client
+// disconnects. A client reconnecting with Last-Event-ID first gets the events it missed, or a resync
+// event when they are no longer available
+func (e *EventsHandler) Stream(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	controller := http.NewResponseController(w)
+	// the stream is open for much longer than the server timeouts allow for a response
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	// the session the stream belongs to, the stream ends when it is invalidated by logging out
+	token, err := GetToken(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	subscription, missed, complete := e.Broker.Subscribe(userId, r.Header.Get("Last-Event-ID"))
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// disables response buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		writeEvent(w, events.Event{Type: events.Resync})
+	}
+	for _, event := range missed {
+		if event.Type != events.SessionInvalidated {
+			writeEvent(w, event)
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(e.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscription.Events:
+			if !ok {
+				// dropped by the broker, the client reconnects with Last-Event-ID
+				return
+			}
+			if event.Type == events.SessionInvalidated {
+				if event.EntityId == *token {
+					return
+				}
+				continue
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) {
+	if event.Type == events.Resync {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", events.Resync)
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: {\"id\":\"%s\"}\n\n", event.Id, event.Type, event.EntityId)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/export"
+	"github.com/google/uuid"
+)
+
+type ExportHandler struct {
+	DBService *db.DatabaseService
+	Exporter  *export.Exporter
+	// PublicURL is the base of the download links, when empty it is taken from the request
+	PublicURL string
+}
+
+// CreateExport queues an export of the account, it is generated in the background and its status
+// is polled with GetExport (or awaited with the export.ready event)
+func (e *ExportHandler) CreateExport(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	accountExport, err := e.DBService.CreateExport(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+accountExport.Id.String())
+	writeJSON(w, http.StatusAccepted, e.exportResponse(r, r.URL.Path, accountExport))
+}
+
+func (e *ExportHandler) GetExport(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	exportId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("error proccessing the uuid"))
+		return
+	}
+	accountExport, err := e.DBService.GetExport(r.Context(), exportId, userId)
+	if errors.Is(err, db.ErrExportNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, e.exportResponse(r, path.Dir(r.URL.Path), accountExport))
+}
+
+// Download serves the ZIP of a ready export, the token in the path is the only authentication
+// so the link works in any browser or download manager until it expires
+func (e *ExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	redactPath(r.Context())
+	accountExport, err := e.DBService.GetExportDownload(r.Context(), r.PathValue("token"))
+	if errors.Is(err, db.ErrExportNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if errors.Is(err, db.ErrExportExpired) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	file, err := os.Open(e.Exporter.Path(accountExport.Id))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("export file not found"))
+		return
+	}
+	defer file.Close()
+
+	filename := "taskjournal-export-" + accountExport.CreatedAt.UTC().Format("2006-01-02") + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	// a large export takes longer than the server write timeout on a slow connection
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	http.ServeContent(w, r, filename, *accountExport.CompletedAt, file)
+}
+
+func (e *ExportHandler) exportResponse(r *http.Request, basePath string, accountExport *db.ExportDB) db.AccountExport {
+	response := db.AccountExport{
+		Id:          accountExport.Id,
+		Status:      accountExport.Status,
+		CreatedAt:   accountExport.CreatedAt,
+		CompletedAt: accountExport.CompletedAt,
+		ExpiresAt:   accountExport.ExpiresAt,
+		Size:        accountExport.Size,
+	}
+	if accountExport.Status == db.ExportReady && accountExport.ExpiresAt != nil && !accountExport.ExpiresAt.After(time.Now()) {
+		response.Status = db.ExportExpired
+	} else if accountExport.Status == db.ExportReady && accountExport.Token != nil {
+		response.Url = publicBaseURL(r, e.PublicURL) + basePath + "/download/" + *accountExport.Token
+	}
+	if accountExport.Error != nil {
+		response.Error = *accountExport.Error
+	}
+	return response
+}
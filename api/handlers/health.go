@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	DBService           *db.DatabaseService
+	IconUploadDirectory string
+	// Draining is set when the server is shutting down, readiness then fails so load balancers stop sending traffic
+	Draining *atomic.Bool
+}
+
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Liveness only tells that the process is able to serve requests, it doesn't check dependencies
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthResponse{Status: "ok", Checks: map[string]CheckResult{}})
+}
+
+// Readiness checks every dependency needed to serve traffic
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]CheckResult{
+		"database":   checkResult(h.DBService.Ping(ctx)),
+		"migrations": checkResult(h.checkMigrations(ctx)),
+		"uploads":    checkResult(h.checkUploadDirectory()),
+	}
+	if h.Draining.Load() {
+		checks["shutdown"] = CheckResult{Status: "fail", Error: "server is shutting down"}
+	} else {
+		checks["shutdown"] = CheckResult{Status: "ok"}
+	}
+
+	response := HealthResponse{Status: "ok", Checks: checks}
+	for _, check := range checks {
+		if check.Status != "ok" {
+			response.Status = "fail"
+		}
+	}
+	writeHealth(w, response)
+}
+
+func (h *HealthHandler) checkMigrations(ctx context.Context) error {
+	pending, err := h.DBService.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+func (h *HealthHandler) checkUploadDirectory() error {
+	if err := os.MkdirAll(h.IconUploadDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(h.IconUploadDirectory, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+func checkResult(err error) CheckResult {
+	if err != nil {
+		return CheckResult{Status: "fail", Error: err.Error()}
+	}
+	return CheckResult{Status: "ok"}
+}
+
+func writeHealth(w http.ResponseWriter, response HealthResponse) {
+	responseJson, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status == "ok" {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(responseJson)
+}
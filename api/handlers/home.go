@@ -6,10 +6,6 @@ import (
 
 type HomeHandler struct{}
 
-func (h *HomeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	preflight := EnableCORS(w, r)
-	if preflight {
-		return
-	}
+func (h *HomeHandler) Home(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("This is home endpoint"))
 }
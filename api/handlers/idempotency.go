@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored with an idempotent response, the others
+// (e.g. X-Request-ID) belong to the retry
+var replayedHeaders = []string{"Content-Type", "ETag"}
+
+// IdempotencyMiddleware makes mutating requests sent with an Idempotency-Key safe to retry. The first
+// successful response is stored and replayed for retries with the same key and request, a retry
+// with a different request (method, path, query or body) is answered with 409. Failed requests aren't
+// stored, so they can be retried with the same key. It runs after AuthMiddleware, keys are per user.
+// The body is read before the handler applies its own limit, so it is capped at maxBodySize, the
+// largest body any route accepts
+func IdempotencyMiddleware(next http.Handler, dbService *db.DatabaseService, maxBodySize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		userId, authenticated := UserIdFromContext(r.Context())
+		if key == "" || !authenticated || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid Idempotency-Key"))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				w.Write([]byte("request body is too large"))
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("error reading request body"))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		stored, err := dbService.BeginIdempotentRequest(r.Context(), userId, key, fingerprint)
+		switch {
+		case errors.Is(err, db.ErrIdempotencyKeyInProgress):
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		case errors.Is(err, db.ErrIdempotencyKeyMismatch):
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		case err != nil:
+			WriteDBError(w, err, http.StatusInternalServerError, "unexpected error")
+			return
+		}
+		if stored != nil {
+			for name, value := range stored.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rec := &bodyRecorder{responseRecorder: responseRecorder{ResponseWriter: w}}
+		next.ServeHTTP(rec, r)
+
+		// the outcome is recorded even when the client is already gone, that is when it will retry
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= 400 {
+			err = dbService.ReleaseIdempotencyKey(ctx, userId, key)
+		} else {
+			response := db.IdempotentResponse{Status: rec.status, Headers: map[string]string{}, Body: rec.body.Bytes()}
+			if response.Status == 0 {
+				response.Status = http.StatusOK
+			}
+			for _, name := range replayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					response.Headers[name] = value
+				}
+			}
+			err = dbService.CompleteIdempotentRequest(ctx, userId, key, response)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "error while recording idempotent response", "error", err)
+		}
+	})
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// bodyRecorder also keeps a copy of the response body
+type bodyRecorder struct {
+	responseRecorder
+	body bytes.Buffer
+}
+
+func (rec *bodyRecorder) Write(b []byte) (int, error) {
+	n, err := rec.responseRecorder.Write(b)
+	rec.body.Write(b[:n])
+	return n, err
+}
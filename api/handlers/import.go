@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/importer"
+	"github.com/google/uuid"
+)
+
+type ImportHandler struct {
+	DBService *db.DatabaseService
+	MaxSize   int64
+}
+
+// Import creates tasks from an uploaded iCalendar, todo.txt or CSV file (multipart field file).
+// With dryRun=true nothing is created and the response previews what the import would do
+func (i *ImportHandler) Import(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	sizeError := fmt.Sprintf("file can't be larger than %dkb", i.MaxSize/1000)
+	r.Body = http.MaxBytesReader(w, r.Body, i.MaxSize)
+	err := r.ParseMultipartForm(i.MaxSize)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(sizeError))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("body must be multipart/form-data"))
+		return
+	}
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid file"))
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = importer.FormatFromFilename(fileHeader.Filename)
+	}
+	options := importer.Options{Icon: r.FormValue("icon")}
+	if options.Icon == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("icon for tasks without a category is required"))
+		return
+	}
+	if columns := r.FormValue("columns"); columns != "" {
+		if err := json.Unmarshal([]byte(columns), &options.Columns); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("columns must be a JSON object mapping task fields to column names"))
+			return
+		}
+	}
+	if delimiter := r.FormValue("delimiter"); delimiter != "" {
+		if utf8.RuneCountInString(delimiter) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("delimiter must be a single character"))
+			return
+		}
+		options.Delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	}
+	dryRun := r.FormValue("dryRun") == "true"
+
+	tasks, invalidRows, err := importer.Parse(format, file, options)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	results, err := i.DBService.ImportTasks(r.Context(), userId, tasks, dryRun)
+	if err != nil {
+		message := err.Error()
+		if len(results) > 0 {
+			message = fmt.Sprintf("import stopped after %d rows, importing the file again skips the imported tasks", len(results))
+		}
+		WriteDBError(w, err, http.StatusInternalServerError, message)
+		return
+	}
+
+	response := db.ImportResponse{DryRun: dryRun, Rows: append(results, invalidRows...)}
+	sort.SliceStable(response.Rows, func(a, b int) bool {
+		return response.Rows[a].Row < response.Rows[b].Row
+	})
+	for _, row := range response.Rows {
+		switch row.Status {
+		case db.ImportCreated:
+			response.Created++
+		case db.ImportDuplicate:
+			response.Duplicates++
+		case db.ImportInvalid:
+			response.Invalid++
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
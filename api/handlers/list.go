@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/google/uuid"
+)
+
+type ListHandler struct {
+	DBService *db.DatabaseService
+}
+
+// listFilter reads the list query parameter of GET /tasks and GET /tasks_history: the id of a list,
+// personal for the tasks without a list, everything the user can read when it is missing
+func listFilter(w http.ResponseWriter, r *http.Request) (db.ListFilter, bool) {
+	list := r.URL.Query().Get("list")
+	switch list {
+	case "":
+		return db.ListFilter{}, true
+	case "personal":
+		return db.ListFilter{Personal: true}, true
+	}
+	listId, err := uuid.Parse(list)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("list must be the id of a list or personal"))
+		return db.ListFilter{}, false
+	}
+	return db.ListFilter{ListId: &listId}, true
+}
+
+// writeListError writes the errors of lists, members and invitations with their status
+func writeListError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrListNotFound), errors.Is(err, db.ErrInvitationNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+	case errors.Is(err, db.ErrAlreadyMember):
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+	case errors.Is(err, db.ErrInvalidRole), errors.Is(err, db.ErrInvalidInvitee), errors.Is(err, db.ErrOwnerCantLeave):
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+	default:
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+	}
+}
+
+// pathId parses the path wildcard name as a uuid, writing 400 when it isn't one
+func pathId(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue(name))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("error proccessing the uuid"))
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// decodeListName reads the body of creating or renaming a list
+func decodeListName(w http.ResponseWriter, r *http.Request) (db.TaskListPost, bool) {
+	var list db.TaskListPost
+	err := json.NewDecoder(r.Body).Decode(&list)
+	list.ListName = strings.TrimSpace(list.ListName)
+	if err != nil || list.ListName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("listName is required"))
+		return list, false
+	}
+	return list, true
+}
+
+func (l *ListHandler) GetLists(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	lists, err := l.DBService.GetLists(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if lists == nil {
+		lists = []db.TaskListDB{}
+	}
+	writeJSON(w, http.StatusOK, lists)
+}
+
+func (l *ListHandler) CreateList(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	list, ok := decodeListName(w, r)
+	if !ok {
+		return
+	}
+	created, err := l.DBService.CreateList(r.Context(), list, userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (l *ListHandler) GetList(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	list, err := l.DBService.GetList(r.Context(), listId, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (l *ListHandler) RenameList(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	list, ok := decodeListName(w, r)
+	if !ok {
+		return
+	}
+	renamed, err := l.DBService.RenameList(r.Context(), listId, list, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, renamed)
+}
+
+// DeleteList deletes the list with all of its tasks, only the owner can
+func (l *ListHandler) DeleteList(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	err := l.DBService.DeleteList(r.Context(), listId, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+func (l *ListHandler) GetMembers(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	members, err := l.DBService.GetListMembers(r.Context(), listId, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// UpdateMember changes the role of a member, only the owner can
+func (l *ListHandler) UpdateMember(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	memberId, ok := pathId(w, r, "userId")
+	if !ok {
+		return
+	}
+	var member db.TaskListMemberPut
+	err := json.NewDecoder(r.Body).Decode(&member)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+		return
+	}
+	err = l.DBService.UpdateListMember(r.Context(), listId, memberId, member.Role, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+// RemoveMember removes a member from the list, members remove themselves to leave it
+func (l *ListHandler) RemoveMember(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	memberId, ok := pathId(w, r, "userId")
+	if !ok {
+		return
+	}
+	err := l.DBService.RemoveListMember(r.Context(), listId, memberId, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+// Invite invites a user to the list by username or email, only the owner can
+func (l *ListHandler) Invite(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	var invitation db.TaskListInvitationPost
+	err := json.NewDecoder(r.Body).Decode(&invitation)
+	if err != nil || strings.TrimSpace(invitation.User) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("user is required"))
+		return
+	}
+	created, err := l.DBService.CreateInvitation(r.Context(), listId, invitation, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (l *ListHandler) GetListInvitations(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	listId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	invitations, err := l.DBService.GetListInvitations(r.Context(), listId, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	if invitations == nil {
+		invitations = []db.TaskListInvitation{}
+	}
+	writeJSON(w, http.StatusOK, invitations)
+}
+
+// GetInvitations returns the invitations the user received
+func (l *ListHandler) GetInvitations(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	invitations, err := l.DBService.GetInvitations(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if invitations == nil {
+		invitations = []db.TaskListInvitation{}
+	}
+	writeJSON(w, http.StatusOK, invitations)
+}
+
+// AcceptInvitation joins the list of the invitation and returns it
+func (l *ListHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	invitationId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	list, err := l.DBService.AcceptInvitation(r.Context(), invitationId, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// DeleteInvitation declines an invitation the user received, or cancels one to a list the user owns
+func (l *ListHandler) DeleteInvitation(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	invitationId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	err := l.DBService.DeleteInvitation(r.Context(), invitationId, userId)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/logging"
+	"github.com/google/uuid"
+)
+
+const requestIdHeader = "X-Request-ID"
+const maxRequestIdLength = 128
+
+// requestInfo is shared between RequestLogMiddleware and the handlers further down the chain,
+// so details only known there (e.g. the authenticated user) end up in the access log
+type requestInfo struct {
+	userId *uuid.UUID
+	route  string
+	// redactPath logs the route instead of the path, for paths that carry a secret
+	redactPath bool
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// redactPath keeps the path of the request out of the access log
+func redactPath(ctx context.Context) {
+	if info := requestInfoFromContext(ctx); info != nil {
+		info.redactPath = true
+	}
+}
+
+// RecordRouteMiddleware wraps the mux and stores the pattern of the matched route in the request info.
+// The mux only sets the pattern on the request it receives, which middleware further out doesn't see
+// when a request was cloned in between
+func RecordRouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			info.route = r.Pattern
+		}
+	})
+}
+
+// responseRecorder captures the status code and the number of bytes written
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (flushing, deadlines)
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// RequestLogMiddleware assigns every request an id (or propagates the one sent by the client in X-Request-ID),
+// stores it in the request context and writes one structured log line per request
+func RequestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := r.Header.Get(requestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = uuid.NewString()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+
+		info := &requestInfo{}
+		ctx := logging.WithRequestId(r.Context(), requestId)
+		ctx = context.WithValue(ctx, requestInfoKey, info)
+		r = r.WithContext(ctx)
+		rec := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		path := r.URL.Path
+		if info.redactPath {
+			path = info.route
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", info.route),
+			slog.String("path", path),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", rec.bytes),
+		}
+		if info.userId != nil {
+			attrs = append(attrs, slog.String("user_id", info.userId.String()))
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+// validRequestId accepts client supplied ids only if they are short and printable ASCII,
+// so they can't be used to inject anything into the logs
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range requestId {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
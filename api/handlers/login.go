@@ -3,7 +3,6 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"regexp"
 
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
 )
@@ -12,25 +11,6 @@ type LoginHandler struct {
 	DBService *db.DatabaseService
 }
 
-var (
-	login = regexp.MustCompile(`^/login/*$`)
-)
-
-func (loginHandler *LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	preflight := EnableCORS(w, r)
-	if preflight {
-		return
-	}
-
-	switch {
-	case r.Method == http.MethodPost && login.MatchString(r.URL.Path):
-		loginHandler.Login(w, r)
-		return
-	default:
-		return
-	}
-}
-
 func (loginHandler *LoginHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var credentials db.Credentials
 	err := json.NewDecoder(r.Body).Decode(&credentials)
@@ -40,10 +20,10 @@ func (loginHandler *LoginHandler) Login(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	authRow, err := loginHandler.DBService.CreateToken(credentials)
+	authRow, err := loginHandler.DBService.CreateToken(r.Context(), credentials)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte(err.Error()))
+		loginAttempts.Inc("failure")
+		WriteDBError(w, err, http.StatusUnauthorized, err.Error())
 		return
 	}
 
@@ -56,5 +36,9 @@ func (loginHandler *LoginHandler) Login(w http.ResponseWriter, r *http.Request)
 		SameSite: http.SameSiteLaxMode,
 		Secure:   true}
 	http.SetCookie(w, &cookie)
+	if authRow.DeletionCancelled {
+		w.Header().Set("Account-Deletion-Cancelled", "true")
+	}
+	loginAttempts.Inc("success")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/metrics"
+)
+
+var (
+	httpRequests        = metrics.NewCounterVec("taskjournal_http_requests_total", "Number of HTTP requests by route and status.", "method", "route", "status")
+	httpRequestDuration = metrics.NewHistogramVec("taskjournal_http_request_duration_seconds", "HTTP request latency by route and status.", metrics.DefaultBuckets, "method", "route", "status")
+	loginAttempts       = metrics.NewCounterVec("taskjournal_login_attempts_total", "Number of login attempts by result.", "result")
+)
+
+// MetricsMiddleware counts requests and observes their latency, labeled with the route pattern
+// instead of the path so task ids don't create a new series per task
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := ""
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			route = info.route
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(rec.status)
+		httpRequests.Inc(r.Method, route, status)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route, status)
+	})
+}
+
+// MetricsAuthMiddleware requires the bearer token when token is not empty
+func MetricsAuthMiddleware(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			provided, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,15 +3,24 @@ package handlers
 import (
 	"errors"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
 	"github.com/google/uuid"
 )
 
-func EnableCORS(w http.ResponseWriter, r *http.Request) bool {
-	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:4200")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// EnableCORS sets the CORS headers when the request origin is one of the allowed origins
+// and reports whether the request was a preflight request that has already been answered
+func EnableCORS(w http.ResponseWriter, r *http.Request, allowedOrigins []string) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin != "" && slices.Contains(allowedOrigins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Request-ID, If-Match, If-None-Match, Idempotency-Key")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Deprecation, Sunset, Link, ETag, Idempotent-Replayed, Account-Deletion-Cancelled")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 	if r.Method == http.MethodOptions {
@@ -39,7 +48,7 @@ func GetUser(r *http.Request, dbService db.DatabaseService) (*uuid.UUID, error)
 	if err != nil {
 		return nil, err
 	}
-	userId, err := dbService.GetLoggedInUser(*token)
+	userId, err := dbService.GetLoggedInUser(r.Context(), *token)
 	if err != nil {
 		return nil, err
 	}
@@ -48,26 +57,19 @@ func GetUser(r *http.Request, dbService db.DatabaseService) (*uuid.UUID, error)
 
 func AuthMiddleware(next http.Handler, dbService db.DatabaseService) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		preflight := EnableCORS(w, r)
-		if preflight {
-			return
-		}
-		token, err := GetUser(r, dbService)
+		userId, err := GetUser(r, dbService)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(err.Error()))
+			WriteDBError(w, err, http.StatusUnauthorized, err.Error())
 			return
 		}
 
-		r.Header.Set("X-Auth-Token", token.String())
-
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(WithUserId(r.Context(), *userId)))
 	})
 }
 
-func CORSMiddleware(next http.Handler) http.Handler {
+func CORSMiddleware(next http.Handler, allowedOrigins []string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		preflight := EnableCORS(w, r)
+		preflight := EnableCORS(w, r, allowedOrigins)
 		if preflight {
 			return
 		}
@@ -75,3 +77,31 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// AuthenticatedHandlerFunc is a handler that needs the id of the user authenticated by AuthMiddleware
+type AuthenticatedHandlerFunc func(w http.ResponseWriter, r *http.Request, userId uuid.UUID)
+
+func (f AuthenticatedHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userId, ok := UserIdFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f(w, r, userId)
+}
+
+// TrimTrailingSlashMiddleware removes trailing slashes from the path before routing,
+// so /tasks/ is served by the /tasks route without registering both
+func TrimTrailingSlashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 && strings.HasSuffix(r.URL.Path, "/") {
+			r = r.Clone(r.Context())
+			r.URL.Path = strings.TrimRight(r.URL.Path, "/")
+			if r.URL.Path == "" {
+				r.URL.Path = "/"
+			}
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
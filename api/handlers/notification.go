@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/google/uuid"
+)
+
+const defaultNotificationPageSize = 20
+
+type NotificationHandler struct {
+	DBService *db.DatabaseService
+}
+
+// GetNotifications returns a page of the inbox, unread notifications first and then from the newest
+func (n *NotificationHandler) GetNotifications(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	limit, before, ok := page(w, r, "limit", "before", defaultNotificationPageSize, db.MaxNotificationPageSize)
+	if !ok {
+		return
+	}
+	notifications, err := n.DBService.GetNotifications(r.Context(), userId, limit, before)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, notifications)
+}
+
+func (n *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	notificationId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	err := n.DBService.MarkNotificationRead(r.Context(), notificationId, userId)
+	if err != nil {
+		writeNotificationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+func (n *NotificationHandler) MarkAllRead(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	_, err := n.DBService.MarkAllNotificationsRead(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+func (n *NotificationHandler) DeleteNotification(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	notificationId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	err := n.DBService.DeleteNotification(r.Context(), notificationId, userId)
+	if err != nil {
+		writeNotificationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+func (n *NotificationHandler) GetPreferences(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	preferences, err := n.DBService.GetNotificationPreferences(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, preferences)
+}
+
+// UpdatePreferences changes the channels of the types of notifications in the body, the other types are kept
+func (n *NotificationHandler) UpdatePreferences(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	var preferences db.NotificationPreferences
+	err := json.NewDecoder(r.Body).Decode(&preferences)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+		return
+	}
+	updated, err := n.DBService.UpdateNotificationPreferences(r.Context(), userId, preferences)
+	if err != nil {
+		writeNotificationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// writeNotificationError writes the errors of notifications with their status
+func writeNotificationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, db.ErrNotificationNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	WriteDBError(w, err, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+var errUnsupportedMediaType = errors.New("content type must be application/merge-patch+json")
+
+// decodeMergePatch decodes a JSON Merge Patch (RFC 7396) body, application/json is accepted
+// as well for clients that can't set the content type
+func decodeMergePatch(r *http.Request, patch any) error {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != mergePatchContentType && mediaType != "application/json") {
+			return errUnsupportedMediaType
+		}
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(patch)
+}
+
+// writePatchDecodeError responds to a body that decodeMergePatch rejected
+func writePatchDecodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnsupportedMediaType) {
+		w.Header().Set("Accept-Patch", mergePatchContentType)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte("bad request"))
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	responseJson, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("error while constructing json"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseJson)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/push"
+	"github.com/google/uuid"
+)
+
+// maxDeviceLength limits the User-Agent stored as the device of a push subscription
+const maxDeviceLength = 255
+
+type PushHandler struct {
+	DBService *db.DatabaseService
+	// VAPIDKey is nil when push isn't configured
+	VAPIDKey *push.VAPIDKey
+}
+
+// GetVAPIDKey returns the public key browsers subscribe with (the applicationServerKey)
+func (p *PushHandler) GetVAPIDKey(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	if p.VAPIDKey == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(db.ErrPushUnavailable.Error()))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"publicKey": p.VAPIDKey.PublicKey})
+}
+
+func (p *PushHandler) GetSubscriptions(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	subscriptions, err := p.DBService.GetPushSubscriptions(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, subscriptions)
+}
+
+// Subscribe saves the PushSubscription of the browser, the User-Agent is kept to tell devices apart
+func (p *PushHandler) Subscribe(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	var subscription db.PushSubscriptionPost
+	err := json.NewDecoder(r.Body).Decode(&subscription)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+		return
+	}
+	device := r.UserAgent()
+	if len(device) > maxDeviceLength {
+		device = device[:maxDeviceLength]
+	}
+	created, err := p.DBService.CreatePushSubscription(r.Context(), userId, subscription, device)
+	if errors.Is(err, db.ErrPushSubscriptionTaken) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (p *PushHandler) Unsubscribe(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	subscriptionId, ok := pathId(w, r, "id")
+	if !ok {
+		return
+	}
+	err := p.DBService.DeletePushSubscription(r.Context(), subscriptionId, userId)
+	if errors.Is(err, db.ErrPushSubscriptionNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
@@ -3,7 +3,6 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"regexp"
 
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
 )
@@ -12,25 +11,6 @@ type SignupHandler struct {
 	DBService *db.DatabaseService
 }
 
-var (
-	signup = regexp.MustCompile(`^/signup/*$`)
-)
-
-func (signupHandler *SignupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	preflight := EnableCORS(w, r)
-	if preflight {
-		return
-	}
-
-	switch {
-	case r.Method == http.MethodPost && signup.MatchString(r.URL.Path):
-		signupHandler.CreateUser(w, r)
-		return
-	default:
-		return
-	}
-}
-
 func (signupHandler *SignupHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var user db.UserPost
 	err := json.NewDecoder(r.Body).Decode(&user)
@@ -39,10 +19,9 @@ func (signupHandler *SignupHandler) CreateUser(w http.ResponseWriter, r *http.Re
 		w.Write([]byte("bad request"))
 		return
 	}
-	userId, err := signupHandler.DBService.CreateUser(user)
+	userId, err := signupHandler.DBService.CreateUser(r.Context(), user)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
 		return
 	}
 	userIdJson, err := json.Marshal(db.Id{Id: *userId})
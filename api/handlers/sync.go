@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/google/uuid"
+)
+
+type SyncHandler struct {
+	DBService *db.DatabaseService
+}
+
+// Sync returns the changes since the token in the since query parameter, without it everything is returned
+func (s *SyncHandler) Sync(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	var since *db.SyncToken
+	if sinceString := r.URL.Query().Get("since"); sinceString != "" {
+		token, err := db.ParseSyncToken(sinceString)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		since = token
+	}
+	changes, err := s.DBService.Sync(r.Context(), userId, since)
+	if errors.Is(err, db.ErrSyncTokenExpired) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// ApplyBatch replays offline edits, the response has one result per mutation in the same order
+func (s *SyncHandler) ApplyBatch(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	var batch db.SyncBatch
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&batch)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad request"))
+		return
+	}
+	if len(batch.Mutations) > db.MaxSyncMutations {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("a batch can't have more than %d mutations", db.MaxSyncMutations)))
+		return
+	}
+	results, err := s.DBService.ApplySyncBatch(r.Context(), userId, batch.Mutations)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, db.SyncBatchResponse{Results: results})
+}
@@ -2,73 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"path"
-	"regexp"
 
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
 	"github.com/google/uuid"
 )
 
-var (
-	TaskID       = regexp.MustCompile(`^/task/([a-fA-F0-9\-]{36})$`)
-	TaskUpdateID = regexp.MustCompile(`^/task/update/([a-fA-F0-9\-]{36})$`)
-	Tasks        = regexp.MustCompile(`^/tasks/*$`)
-)
-
 type TaskHandler struct {
 	DBService *db.DatabaseService
 }
 
-func (t *TaskHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	preflight := EnableCORS(w, r)
-	if preflight {
-		return
-	}
-
-	tokenString := r.Header.Get("X-Auth-Token")
-	if tokenString == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token, err := uuid.Parse(tokenString)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	switch {
-	case r.Method == http.MethodGet && TaskID.MatchString(r.URL.Path):
-		t.GetTask(w, r, token)
-		return
-	case r.Method == http.MethodPut && TaskID.MatchString(r.URL.Path):
-		t.CompleteTask(w, r, token)
-		return
-	case r.Method == http.MethodPut && TaskUpdateID.MatchString(r.URL.Path):
-		t.UpdateTask(w, r, token)
-		return
-	case r.Method == http.MethodDelete && TaskID.MatchString(r.URL.Path):
-		t.DeleteTask(w, r, token)
-		return
-	case r.Method == http.MethodPost && Tasks.MatchString(r.URL.Path):
-		t.CreateTask(w, r, token)
-		return
-	case r.Method == http.MethodGet && Tasks.MatchString(r.URL.Path):
-		t.GetTasks(w, r, token)
-		return
-	default:
-		return
-	}
-}
-
 func (t *TaskHandler) GetTasks(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
 	searchName := r.URL.Query().Get("searchName")
 	searchIcons := r.URL.Query()["searchIcons"]
 	searchOrderBy := r.URL.Query().Get("searchOrderBy")
-	tasks, err := t.DBService.GetTasks(userId, &searchName, searchIcons, &searchOrderBy)
+	list, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	assignment, ok := assignmentFilter(w, r)
+	if !ok {
+		return
+	}
+	tasks, err := t.DBService.GetTasks(r.Context(), userId, list, assignment, &searchName, searchIcons, &searchOrderBy)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
 		return
 	}
 	tasksJson, err := json.Marshal(tasks)
@@ -82,17 +41,51 @@ func (t *TaskHandler) GetTasks(w http.ResponseWriter, r *http.Request, userId uu
 	w.Write(tasksJson)
 }
 
+// assignmentFilter reads the assignedTo (me for the tasks assigned to the user, none for the unassigned ones)
+// and createdBy (me) query parameters of GET /tasks
+func assignmentFilter(w http.ResponseWriter, r *http.Request) (db.AssignmentFilter, bool) {
+	var filter db.AssignmentFilter
+	switch r.URL.Query().Get("assignedTo") {
+	case "":
+	case "me":
+		filter.AssignedToMe = true
+	case "none":
+		filter.Unassigned = true
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("assignedTo must be me or none"))
+		return filter, false
+	}
+	switch r.URL.Query().Get("createdBy") {
+	case "":
+	case "me":
+		filter.CreatedByMe = true
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("createdBy must be me"))
+		return filter, false
+	}
+	return filter, true
+}
+
 func (t *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	taskId, err := uuid.Parse(path.Base(r.URL.Path))
+	taskId, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("error proccessing the uuid"))
 		return
 	}
-	task, err := t.DBService.GetTask(taskId, userId)
+	task, err := t.DBService.GetTask(r.Context(), taskId, userId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("task with given id doesn't exist"))
+		WriteDBError(w, err, http.StatusBadRequest, "task with given id doesn't exist")
+		return
+	}
+	// comments don't change the version of the task, so a task with comments is never answered with 304
+	if r.URL.Query().Has("comments") {
+		t.writeTaskWithComments(w, r, task, userId)
+		return
+	}
+	if notModified(w, r, formatETag(task.Version)) {
 		return
 	}
 	taskJson, err := json.Marshal(task)
@@ -102,10 +95,27 @@ func (t *TaskHandler) GetTask(w http.ResponseWriter, r *http.Request, userId uui
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(task.Version))
 	w.WriteHeader(http.StatusOK)
 	w.Write(taskJson)
 }
 
+// writeTaskWithComments writes the task with the newest page of its comments, the comments query parameter
+// is the size of the page and commentsBefore the id of the comment it starts before
+func (t *TaskHandler) writeTaskWithComments(w http.ResponseWriter, r *http.Request, task *db.TaskDB, userId uuid.UUID) {
+	limit, before, ok := commentPage(w, r, "comments", "commentsBefore")
+	if !ok {
+		return
+	}
+	comments, err := t.DBService.GetTaskComments(r.Context(), task.Id, userId, limit, before)
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("ETag", formatETag(task.Version))
+	writeJSON(w, http.StatusOK, db.TaskWithComments{TaskDB: *task, Comments: *comments})
+}
+
 func (t *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
 	var task db.TaskPost
 	err := json.NewDecoder(r.Body).Decode(&task)
@@ -115,12 +125,16 @@ func (t *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request, userId
 		return
 	}
 	task.CreatedBy = userId
-	taskId, err := t.DBService.CreateTask(task)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	taskId, err := t.DBService.CreateTask(r.Context(), task)
+	if errors.Is(err, db.ErrListNotFound) || errors.Is(err, db.ErrInvalidAssignee) {
+		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
 	taskIdJson, err := json.Marshal(db.Id{Id: *taskId})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -133,16 +147,15 @@ func (t *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request, userId
 }
 
 func (t *TaskHandler) CompleteTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	taskId, err := uuid.Parse(path.Base(r.URL.Path))
+	taskId, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("error proccessing the uuid"))
 		return
 	}
-	_, err = t.DBService.CompleteTask(taskId, userId)
+	_, err = t.DBService.CompleteTask(r.Context(), taskId, userId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
 		return
 	}
 	responseJson, err := json.Marshal(db.Success{Success: true})
@@ -157,12 +170,16 @@ func (t *TaskHandler) CompleteTask(w http.ResponseWriter, r *http.Request, userI
 }
 
 func (t *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	taskId, err := uuid.Parse(path.Base(r.URL.Path))
+	taskId, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("error proccessing the uuid"))
 		return
 	}
+	version, ok := ifMatchVersion(w, r, true)
+	if !ok {
+		return
+	}
 	var task db.TaskPut
 	err = json.NewDecoder(r.Body).Decode(&task)
 	if err != nil {
@@ -170,12 +187,16 @@ func (t *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request, userId
 		w.Write([]byte("bad request"))
 		return
 	}
-	err = t.DBService.UpdateTask(taskId, task, userId)
+	newVersion, err := t.DBService.UpdateTask(r.Context(), taskId, task, userId, version)
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		t.writeCurrentTask(w, r, taskId, userId)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
 		return
 	}
+	w.Header().Set("ETag", formatETag(newVersion))
 	responseJson, err := json.Marshal(db.Success{Success: true})
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -188,16 +209,15 @@ func (t *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request, userId
 }
 
 func (t *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	taskId, err := uuid.Parse(path.Base(r.URL.Path))
+	taskId, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("error proccessing the uuid"))
 		return
 	}
-	err = t.DBService.DeleteTask(taskId, userId)
+	err = t.DBService.DeleteTask(r.Context(), taskId, userId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
 		return
 	}
 	responseJson, err := json.Marshal(db.Success{Success: true})
@@ -210,3 +230,84 @@ func (t *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request, userId
 	w.WriteHeader(http.StatusOK)
 	w.Write(responseJson)
 }
+
+// PatchTask updates only the fields present in the JSON Merge Patch body, a null deadline clears it
+func (t *TaskHandler) PatchTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	taskId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("error proccessing the uuid"))
+		return
+	}
+	version, ok := ifMatchVersion(w, r, true)
+	if !ok {
+		return
+	}
+	var patch db.TaskPatch
+	err = decodeMergePatch(r, &patch)
+	if err != nil {
+		writePatchDecodeError(w, err)
+		return
+	}
+	err = patch.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	task, err := t.DBService.PatchTask(r.Context(), taskId, patch, userId, version)
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		t.writeCurrentTask(w, r, taskId, userId)
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("ETag", formatETag(task.Version))
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (t *TaskHandler) StarTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	t.setStarred(w, r, userId, true)
+}
+
+func (t *TaskHandler) UnstarTask(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	t.setStarred(w, r, userId, false)
+}
+
+func (t *TaskHandler) setStarred(w http.ResponseWriter, r *http.Request, userId uuid.UUID, starred bool) {
+	taskId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("error proccessing the uuid"))
+		return
+	}
+	// starring doesn't conflict with other edits, so If-Match is optional
+	version, ok := ifMatchVersion(w, r, false)
+	if !ok {
+		return
+	}
+	patch := db.TaskPatch{Starred: db.PatchField[bool]{Set: true, Value: starred}}
+	task, err := t.DBService.PatchTask(r.Context(), taskId, patch, userId, version)
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		t.writeCurrentTask(w, r, taskId, userId)
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("ETag", formatETag(task.Version))
+	writeJSON(w, http.StatusOK, db.Success{Success: true})
+}
+
+// writeCurrentTask answers an update made with a stale If-Match
+func (t *TaskHandler) writeCurrentTask(w http.ResponseWriter, r *http.Request, taskId uuid.UUID, userId uuid.UUID) {
+	task, err := t.DBService.GetTask(r.Context(), taskId, userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	writePreconditionFailed(w, task, task.Version)
+}
@@ -2,70 +2,31 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"path"
-	"regexp"
 	"strconv"
 
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
 	"github.com/google/uuid"
 )
 
-var (
-	TaskHistoryID = regexp.MustCompile(`^/task_history/([a-fA-F0-9\-]{36})$`)
-	TasksHistory  = regexp.MustCompile(`^/tasks_history/*$`)
-)
-
 type TaskHistoryHandler struct {
 	DBService *db.DatabaseService
 }
 
-func (th *TaskHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	preflight := EnableCORS(w, r)
-	if preflight {
-		return
-	}
-
-	tokenString := r.Header.Get("X-Auth-Token")
-	if tokenString == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token, err := uuid.Parse(tokenString)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	switch {
-	case r.Method == http.MethodGet && TaskHistoryID.MatchString(r.URL.Path):
-		th.GetTaskHistory(w, r, token)
-		return
-	case r.Method == http.MethodGet && TasksHistory.MatchString(r.URL.Path):
-		th.GetTasksHistory(w, r, token)
-		return
-	case r.Method == http.MethodPut && TaskHistoryID.MatchString(r.URL.Path):
-		th.UpdateTaskHistory(w, r, token)
-		return
-	case r.Method == http.MethodDelete && TaskHistoryID.MatchString(r.URL.Path):
-		th.DeleteTaskAndHistory(w, r, token)
-		return
-	default:
-		return
-	}
-}
-
 func (th *TaskHistoryHandler) GetTaskHistory(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	taskHistoryId, err := uuid.Parse(path.Base(r.URL.Path))
+	taskHistoryId, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("error proccessing the uuid"))
 		return
 	}
-	taskHistory, err := th.DBService.GetTaskHistory(taskHistoryId, userId)
+	taskHistory, err := th.DBService.GetTaskHistory(r.Context(), taskHistoryId, userId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("task history with given id doesn't exist"))
+		WriteDBError(w, err, http.StatusBadRequest, "task history with given id doesn't exist")
+		return
+	}
+	if notModified(w, r, formatETag(taskHistory.Version)) {
 		return
 	}
 	taskHistoryJson, err := json.Marshal(taskHistory)
@@ -75,6 +36,7 @@ func (th *TaskHistoryHandler) GetTaskHistory(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(taskHistory.Version))
 	w.WriteHeader(http.StatusOK)
 	w.Write(taskHistoryJson)
 }
@@ -87,10 +49,13 @@ func (th *TaskHistoryHandler) GetTasksHistory(w http.ResponseWriter, r *http.Req
 	if searchRatingString == "1" || searchRatingString == "2" || searchRatingString == "3" {
 		searchRating, _ = strconv.Atoi(searchRatingString)
 	}
-	tasksHistory, err := th.DBService.GetTasksHistory(userId, &searchName, searchIcons, searchRating)
+	list, ok := listFilter(w, r)
+	if !ok {
+		return
+	}
+	tasksHistory, err := th.DBService.GetTasksHistory(r.Context(), userId, list, &searchName, searchIcons, searchRating)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
 		return
 	}
 	tasksHistoryJson, err := json.Marshal(tasksHistory)
@@ -105,12 +70,16 @@ func (th *TaskHistoryHandler) GetTasksHistory(w http.ResponseWriter, r *http.Req
 }
 
 func (th *TaskHistoryHandler) UpdateTaskHistory(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	taskHistoryId, err := uuid.Parse(path.Base(r.URL.Path))
+	taskHistoryId, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("error proccessing the uuid"))
 		return
 	}
+	version, ok := ifMatchVersion(w, r, true)
+	if !ok {
+		return
+	}
 	var taskHistory db.TaskHistoryPut
 	err = json.NewDecoder(r.Body).Decode(&taskHistory)
 	if err != nil {
@@ -118,12 +87,16 @@ func (th *TaskHistoryHandler) UpdateTaskHistory(w http.ResponseWriter, r *http.R
 		w.Write([]byte("bad request"))
 		return
 	}
-	err = th.DBService.UpdateTaskHistory(taskHistoryId, taskHistory, userId)
+	newVersion, err := th.DBService.UpdateTaskHistory(r.Context(), taskHistoryId, taskHistory, userId, version)
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		th.writeCurrentTaskHistory(w, r, taskHistoryId, userId)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
 		return
 	}
+	w.Header().Set("ETag", formatETag(newVersion))
 	responseJson, err := json.Marshal(db.Success{Success: true})
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -136,16 +109,15 @@ func (th *TaskHistoryHandler) UpdateTaskHistory(w http.ResponseWriter, r *http.R
 }
 
 func (th *TaskHistoryHandler) DeleteTaskAndHistory(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	taskHistoryId, err := uuid.Parse(path.Base(r.URL.Path))
+	taskHistoryId, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("error proccessing the uuid"))
 		return
 	}
-	err = th.DBService.DeleteTaskAndHistory(taskHistoryId, userId)
+	err = th.DBService.DeleteTaskAndHistory(r.Context(), taskHistoryId, userId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
 		return
 	}
 	responseJson, err := json.Marshal(db.Success{Success: true})
@@ -158,3 +130,50 @@ func (th *TaskHistoryHandler) DeleteTaskAndHistory(w http.ResponseWriter, r *htt
 	w.WriteHeader(http.StatusOK)
 	w.Write(responseJson)
 }
+
+// PatchTaskHistory updates only the fields present in the JSON Merge Patch body
+func (th *TaskHistoryHandler) PatchTaskHistory(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	taskHistoryId, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("error proccessing the uuid"))
+		return
+	}
+	version, ok := ifMatchVersion(w, r, true)
+	if !ok {
+		return
+	}
+	var patch db.TaskHistoryPatch
+	err = decodeMergePatch(r, &patch)
+	if err != nil {
+		writePatchDecodeError(w, err)
+		return
+	}
+	err = patch.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	taskHistory, err := th.DBService.PatchTaskHistory(r.Context(), taskHistoryId, patch, userId, version)
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		th.writeCurrentTaskHistory(w, r, taskHistoryId, userId)
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("ETag", formatETag(taskHistory.Version))
+	writeJSON(w, http.StatusOK, taskHistory)
+}
+
+// writeCurrentTaskHistory answers an update made with a stale If-Match
+func (th *TaskHistoryHandler) writeCurrentTaskHistory(w http.ResponseWriter, r *http.Request, taskHistoryId uuid.UUID, userId uuid.UUID) {
+	taskHistory, err := th.DBService.GetTaskHistory(r.Context(), taskHistoryId, userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	writePreconditionFailed(w, taskHistory, taskHistory.Version)
+}
@@ -2,71 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
 	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 )
 
-var (
-	Users    = regexp.MustCompile(`^/user/*$`)
-	UserIcon = regexp.MustCompile(`^/user/icon/*$`)
-)
-
-const iconUploadDirectory = "uploads/profile_icons"
-const maxIconSize = 500000
-
 type UserHandler struct {
-	DBService *db.DatabaseService
+	DBService           *db.DatabaseService
+	IconUploadDirectory string
+	MaxIconSize         int64
 }
 
-func (u *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	preflight := EnableCORS(w, r)
-	if preflight {
-		return
-	}
-
-	tokenString := r.Header.Get("X-Auth-Token")
-	if tokenString == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	token, err := uuid.Parse(tokenString)
+func (u *UserHandler) GetUser(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	user, err := u.DBService.GetUserInfo(r.Context(), userId)
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-
-	switch {
-	case r.Method == http.MethodGet && Users.MatchString(r.URL.Path):
-		u.GetUser(w, r, token)
-		return
-	case r.Method == http.MethodPut && Users.MatchString(r.URL.Path):
-		u.UpdateUser(w, r, token)
-		return
-	case r.Method == http.MethodGet && UserIcon.MatchString(r.URL.Path):
-		u.GetIcon(w, r, token)
-		return
-	case r.Method == http.MethodPost && UserIcon.MatchString(r.URL.Path):
-		u.UploadIcon(w, r, token)
-		return
-	default:
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
 		return
 	}
-}
-
-func (u *UserHandler) GetUser(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	user, err := u.DBService.GetUserInfo(userId)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+	if notModified(w, r, formatETag(user.Version)) {
 		return
 	}
 	userJson, err := json.Marshal(user)
@@ -76,11 +37,16 @@ func (u *UserHandler) GetUser(w http.ResponseWriter, r *http.Request, userId uui
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(user.Version))
 	w.WriteHeader(http.StatusOK)
 	w.Write(userJson)
 }
 
 func (u *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	version, ok := ifMatchVersion(w, r, true)
+	if !ok {
+		return
+	}
 	var user db.UserPut
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
@@ -88,12 +54,16 @@ func (u *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request, userId
 		w.Write([]byte("bad request"))
 		return
 	}
-	err = u.DBService.UpdateUser(user, userId)
+	newVersion, err := u.DBService.UpdateUser(r.Context(), user, userId, version)
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		u.writeCurrentUser(w, r, userId)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
 		return
 	}
+	w.Header().Set("ETag", formatETag(newVersion))
 	responseJson, err := json.Marshal(db.Success{Success: true})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -105,11 +75,84 @@ func (u *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request, userId
 	w.Write(responseJson)
 }
 
+// PatchUser updates only the fields present in the JSON Merge Patch body
+func (u *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	version, ok := ifMatchVersion(w, r, true)
+	if !ok {
+		return
+	}
+	var patch db.UserPatch
+	err := decodeMergePatch(r, &patch)
+	if err != nil {
+		writePatchDecodeError(w, err)
+		return
+	}
+	err = patch.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	user, err := u.DBService.PatchUser(r.Context(), patch, userId, version)
+	if errors.Is(err, db.ErrPreconditionFailed) {
+		u.writeCurrentUser(w, r, userId)
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("ETag", formatETag(user.Version))
+	writeJSON(w, http.StatusOK, user)
+}
+
+// writeCurrentUser answers an update made with a stale If-Match
+func (u *UserHandler) writeCurrentUser(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	user, err := u.DBService.GetUserInfo(r.Context(), userId)
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writePreconditionFailed(w, user, user.Version)
+}
+
+// DeleteUser schedules the deletion of the account after the password was entered again. Every session
+// is revoked, logging in before the grace period ends cancels the deletion
+func (u *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
+	var deletion db.AccountDeletion
+	err := json.NewDecoder(r.Body).Decode(&deletion)
+	if err != nil || deletion.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("password is required"))
+		return
+	}
+	deleteAfter, err := u.DBService.ScheduleAccountDeletion(r.Context(), userId, deletion.Password)
+	if errors.Is(err, db.ErrInvalidPassword) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		WriteDBError(w, err, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "sessiontoken",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true})
+	writeJSON(w, http.StatusAccepted, db.AccountDeletionScheduled{DeleteAfter: *deleteAfter})
+}
+
 func (u *UserHandler) UploadIcon(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
-	err := r.ParseMultipartForm(maxIconSize)
+	iconSizeError := fmt.Sprintf("Icon can't be larger than %dkb", u.MaxIconSize/1000)
+	err := r.ParseMultipartForm(u.MaxIconSize)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Icon can't be larger than 500kb"))
+		w.Write([]byte(iconSizeError))
 		return
 	}
 
@@ -129,13 +172,13 @@ func (u *UserHandler) UploadIcon(w http.ResponseWriter, r *http.Request, userId
 	}
 
 	iconSize := handler.Size
-	if iconSize > maxIconSize {
+	if iconSize > u.MaxIconSize {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Icon can't be larger than 500kb"))
+		w.Write([]byte(iconSizeError))
 		return
 	}
 
-	if err := os.MkdirAll(iconUploadDirectory, os.ModePerm); err != nil {
+	if err := os.MkdirAll(u.IconUploadDirectory, os.ModePerm); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to create upload directory"))
 		return
@@ -152,7 +195,7 @@ func (u *UserHandler) UploadIcon(w http.ResponseWriter, r *http.Request, userId
 
 	userIdString := userId.String()
 	filename := fmt.Sprintf("icon-%s.png", userIdString)
-	filePath := filepath.Join(iconUploadDirectory, filename)
+	filePath := filepath.Join(u.IconUploadDirectory, filename)
 
 	outFile, err := os.Create(filePath)
 	if err != nil {
@@ -184,7 +227,7 @@ func (u *UserHandler) UploadIcon(w http.ResponseWriter, r *http.Request, userId
 func (u *UserHandler) GetIcon(w http.ResponseWriter, r *http.Request, userId uuid.UUID) {
 	userIdString := userId.String()
 	filename := fmt.Sprintf("icon-%s.png", userIdString)
-	filePath := filepath.Join(iconUploadDirectory, filename)
+	filePath := filepath.Join(u.IconUploadDirectory, filename)
 
 	if _, err := os.Stat(filePath); err != nil {
 		w.WriteHeader(http.StatusNotFound)
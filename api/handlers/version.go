@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/metrics"
+)
+
+var deprecatedRequests = metrics.NewCounterVec("taskjournal_deprecated_requests_total", "Number of requests to deprecated routes by route.", "route")
+
+// Deprecation describes a deprecated set of routes
+type Deprecation struct {
+	DeprecatedAt time.Time
+	Sunset       time.Time
+	// SuccessorPrefix is prepended to the request path to link to the route that replaces it
+	SuccessorPrefix string
+}
+
+// DeprecationMiddleware sets the Deprecation (RFC 9745) and Sunset (RFC 8594) headers when their
+// dates are known and counts the request, so we can tell when old clients are gone
+func DeprecationMiddleware(next http.Handler, deprecation Deprecation) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !deprecation.DeprecatedAt.IsZero() {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecation.DeprecatedAt.Unix()))
+		}
+		if !deprecation.Sunset.IsZero() {
+			w.Header().Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if deprecation.SuccessorPrefix != "" {
+			w.Header().Add("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", deprecation.SuccessorPrefix, r.URL.Path))
+		}
+		deprecatedRequests.Inc(r.Pattern)
+		next.ServeHTTP(w, r)
+	})
+}
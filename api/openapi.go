@@ -0,0 +1,88 @@
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The specification is maintained in openapi.yaml next to the route table in router.go,
+// every registered route must be documented there (checked by TestSpecCoversRoutes)
+
+//go:embed openapi.yaml
+var openAPIYaml []byte
+
+// openAPIJson is the specification converted to JSON once at startup
+var openAPIJson = mustConvertSpec(openAPIYaml)
+
+func mustConvertSpec(specYaml []byte) []byte {
+	var spec map[string]any
+	if err := yaml.Unmarshal(specYaml, &spec); err != nil {
+		panic("invalid openapi.yaml: " + err.Error())
+	}
+	specJson, err := json.Marshal(spec)
+	if err != nil {
+		panic("invalid openapi.yaml: " + err.Error())
+	}
+	return specJson
+}
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIJson)
+}
+
+// redocVersion is the Redoc release the docs page is rendered with
+const redocVersion = "v2.1.5"
+
+//go:generate curl -sSfL -o docs/redoc.standalone.js https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js
+
+//go:embed docs
+var docsAssets embed.FS
+
+// redocBundle is the embedded Redoc bundle, nil when it wasn't downloaded before building (see docs/README.md)
+var redocBundle, _ = docsAssets.ReadFile("docs/redoc.standalone.js")
+
+const docsPageTemplate = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Task Journal API</title>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body>
+	<redoc spec-url="openapi.json"></redoc>
+	<script src="{{redoc}}"></script>
+</body>
+</html>
+`
+
+var docsPage = strings.Replace(docsPageTemplate, "{{redoc}}", redocSource(), 1)
+
+func redocSource() string {
+	if redocBundle != nil {
+		return "docs/redoc.standalone.js"
+	}
+	return "https://cdn.redoc.ly/redoc/" + redocVersion + "/bundles/redoc.standalone.js"
+}
+
+func serveDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(docsPage))
+}
+
+func serveRedoc(w http.ResponseWriter, r *http.Request) {
+	if redocBundle == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(redocBundle)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/config"
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/JovanZdravkovic/TaskJournalBackend/export"
+)
+
+func TestSpecCoversRoutes(t *testing.T) {
+	cfg := config.Default()
+	cfg.MetricsToken = "token"
+	router := NewRouter(cfg.ListenAddress, Timeouts{})
+	router.ConfigureRoutes(db.NewDatabaseService(nil, db.Options{BcryptConcurrency: 1}), events.NewBroker(0), &export.Exporter{}, nil, &cfg)
+
+	var spec struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIJson, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	documented := map[string]bool{}
+	for _, route := range router.Routes() {
+		if route.Legacy {
+			continue
+		}
+		path := strings.TrimSuffix(route.Path, "{$}")
+		if path == "" {
+			path = "/"
+		}
+		operation := strings.ToLower(route.Method)
+		documented[route.Method+" "+path] = true
+		if _, ok := spec.Paths[path][operation]; !ok {
+			t.Errorf("route %s %s is missing from openapi.yaml", route.Method, path)
+		}
+	}
+
+	for path, operations := range spec.Paths {
+		for operation := range operations {
+			if operation == "parameters" {
+				continue
+			}
+			if !documented[strings.ToUpper(operation)+" "+path] {
+				t.Errorf("openapi.yaml documents %s %s which is not registered", strings.ToUpper(operation), path)
+			}
+		}
+	}
+}
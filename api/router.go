@@ -1,54 +1,295 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	"github.com/JovanZdravkovic/TaskJournalBackend/api/handlers"
+	"github.com/JovanZdravkovic/TaskJournalBackend/config"
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/JovanZdravkovic/TaskJournalBackend/export"
+	"github.com/JovanZdravkovic/TaskJournalBackend/metrics"
+	"github.com/JovanZdravkovic/TaskJournalBackend/push"
 )
 
 type Router struct {
-	address string
-	mux     *http.ServeMux
+	mux      *http.ServeMux
+	server   *http.Server
+	routes   []Route
+	draining atomic.Bool
 }
 
-func NewRouter(address string) *Router {
+// Route is an entry of the route table, Path uses the ServeMux wildcard syntax (e.g. /v1/task/{id})
+type Route struct {
+	Method        string
+	Path          string
+	Authenticated bool
+	Deprecated    bool
+	// Legacy routes are the unversioned aliases kept for clients released before versioning
+	Legacy bool
+}
+
+// Timeouts of the underlying http.Server, zero means no timeout
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+func NewRouter(address string, timeouts Timeouts) *Router {
 	return &Router{
-		address: address,
-		mux:     http.NewServeMux(),
+		mux: http.NewServeMux(),
+		server: &http.Server{
+			Addr:              address,
+			ReadTimeout:       timeouts.Read,
+			ReadHeaderTimeout: timeouts.ReadHeader,
+			WriteTimeout:      timeouts.Write,
+			IdleTimeout:       timeouts.Idle,
+		},
 	}
 }
 
-func (r *Router) ConfigureRoutes(dbService *db.DatabaseService) {
+// routeGroup registers routes that share the same path prefix and middleware chain
+type routeGroup struct {
+	router     *Router
+	prefix     string
+	route      Route
+	middleware []func(http.Handler) http.Handler
+}
+
+func (r *Router) group(prefix string, route Route, middleware ...func(http.Handler) http.Handler) *routeGroup {
+	return &routeGroup{router: r, prefix: prefix, route: route, middleware: middleware}
+}
+
+func (g *routeGroup) handle(method string, path string, handler http.Handler) {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+	route := g.route
+	route.Method = method
+	route.Path = g.prefix + path
+	g.router.mux.Handle(route.Method+" "+route.Path, handler)
+	g.router.routes = append(g.router.routes, route)
+}
+
+// mountVersion registers the routes of an API version under prefix, the routes of a deprecated
+// version get the deprecation headers. A new version (e.g. /v2) is mounted next to the previous ones
+// with its own register function, reusing the handlers of routes that didn't change
+func (r *Router) mountVersion(prefix string, deprecation *handlers.Deprecation, legacy bool, authMiddleware func(http.Handler) http.Handler, register func(public *routeGroup, authenticated *routeGroup)) {
+	var middleware []func(http.Handler) http.Handler
+	if deprecation != nil {
+		middleware = append(middleware, func(next http.Handler) http.Handler {
+			return handlers.DeprecationMiddleware(next, *deprecation)
+		})
+	}
+	if legacy {
+		middleware = append(middleware, handlers.LegacyMiddleware)
+	}
+	route := Route{Deprecated: deprecation != nil, Legacy: legacy}
+	authenticatedRoute := route
+	authenticatedRoute.Authenticated = true
+	public := r.group(prefix, route, middleware...)
+	authenticated := r.group(prefix, authenticatedRoute, slices.Concat(middleware, []func(http.Handler) http.Handler{authMiddleware})...)
+	register(public, authenticated)
+}
+
+// ConfigureRoutes registers every route, vapidKey is nil when push isn't configured
+func (r *Router) ConfigureRoutes(dbService *db.DatabaseService, broker *events.Broker, exporter *export.Exporter, vapidKey *push.VAPIDKey, cfg *config.Config) {
 	homeHandler := handlers.HomeHandler{}
 	authHandler := handlers.AuthHandler{DBService: dbService}
 	taskHandler := handlers.TaskHandler{DBService: dbService}
 	taskHistoryHandler := handlers.TaskHistoryHandler{DBService: dbService}
-	userHandler := handlers.UserHandler{DBService: dbService}
+	listHandler := handlers.ListHandler{DBService: dbService}
+	commentHandler := handlers.CommentHandler{DBService: dbService}
+	notificationHandler := handlers.NotificationHandler{DBService: dbService}
+	pushHandler := handlers.PushHandler{DBService: dbService, VAPIDKey: vapidKey}
+	userHandler := handlers.UserHandler{
+		DBService:           dbService,
+		IconUploadDirectory: cfg.IconUploadDirectory,
+		MaxIconSize:         cfg.MaxIconSize,
+	}
 	loginHandler := handlers.LoginHandler{DBService: dbService}
 	logoutHandler := handlers.LogoutHandler{DBService: dbService}
 	signupHandler := handlers.SignupHandler{DBService: dbService}
-	r.mux.Handle("/", &homeHandler)
-	r.mux.Handle("/task", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHandler, *dbService)))
-	r.mux.Handle("/task/", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHandler, *dbService)))
-	r.mux.Handle("/tasks", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHandler, *dbService)))
-	r.mux.Handle("/tasks/", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHandler, *dbService)))
-	r.mux.Handle("/task_history", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHistoryHandler, *dbService)))
-	r.mux.Handle("/task_history/", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHistoryHandler, *dbService)))
-	r.mux.Handle("/tasks_history", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHistoryHandler, *dbService)))
-	r.mux.Handle("/tasks_history/", handlers.CORSMiddleware(handlers.AuthMiddleware(&taskHistoryHandler, *dbService)))
-	r.mux.Handle("/user", handlers.CORSMiddleware(handlers.AuthMiddleware(&userHandler, *dbService)))
-	r.mux.Handle("/user/", handlers.CORSMiddleware(handlers.AuthMiddleware(&userHandler, *dbService)))
-	r.mux.Handle("/auth", handlers.CORSMiddleware(handlers.AuthMiddleware(&authHandler, *dbService)))
-	r.mux.Handle("/auth/", handlers.CORSMiddleware(handlers.AuthMiddleware(&authHandler, *dbService)))
-	r.mux.Handle("/login", handlers.CORSMiddleware(&loginHandler))
-	r.mux.Handle("/login/", handlers.CORSMiddleware(&loginHandler))
-	r.mux.Handle("/logout", handlers.CORSMiddleware(&logoutHandler))
-	r.mux.Handle("/logout/", handlers.CORSMiddleware(&logoutHandler))
-	r.mux.Handle("/signup", handlers.CORSMiddleware(&signupHandler))
-	r.mux.Handle("/signup/", handlers.CORSMiddleware(&signupHandler))
-}
-
-func (r *Router) ListenAndServe() {
-	http.ListenAndServe(r.address, r.mux)
+	syncHandler := handlers.SyncHandler{DBService: dbService}
+	importHandler := handlers.ImportHandler{
+		DBService: dbService,
+		MaxSize:   cfg.MaxImportSize,
+	}
+	exportHandler := handlers.ExportHandler{
+		DBService: dbService,
+		Exporter:  exporter,
+		PublicURL: cfg.PublicURL,
+	}
+	calendarHandler := handlers.CalendarHandler{
+		DBService: dbService,
+		PublicURL: cfg.PublicURL,
+	}
+	eventsHandler := handlers.EventsHandler{
+		Broker:            broker,
+		HeartbeatInterval: cfg.EventsHeartbeatInterval,
+	}
+	healthHandler := handlers.HealthHandler{
+		DBService:           dbService,
+		IconUploadDirectory: cfg.IconUploadDirectory,
+		Draining:            &r.draining,
+	}
+
+	system := r.group("", Route{})
+	system.handle(http.MethodGet, "/{$}", http.HandlerFunc(homeHandler.Home))
+	system.handle(http.MethodGet, "/healthz", http.HandlerFunc(healthHandler.Liveness))
+	system.handle(http.MethodGet, "/readyz", http.HandlerFunc(healthHandler.Readiness))
+	system.handle(http.MethodGet, "/openapi.json", http.HandlerFunc(serveOpenAPI))
+	system.handle(http.MethodGet, "/docs", http.HandlerFunc(serveDocs))
+	system.handle(http.MethodGet, "/docs/redoc.standalone.js", http.HandlerFunc(serveRedoc))
+	if cfg.MetricsAddress == "" && cfg.MetricsToken != "" {
+		system.handle(http.MethodGet, "/metrics", handlers.MetricsAuthMiddleware(metrics.Default.Handler(), cfg.MetricsToken))
+	}
+
+	// uploads are multipart, the form around the file gets some room on top of the file limits
+	maxBodySize := max(cfg.MaxImportSize, cfg.MaxIconSize) + 64<<10
+	authMiddleware := func(next http.Handler) http.Handler {
+		return handlers.AuthMiddleware(handlers.IdempotencyMiddleware(next, dbService, maxBodySize), *dbService)
+	}
+
+	v1 := func(public *routeGroup, authenticated *routeGroup) {
+		public.handle(http.MethodPost, "/login", http.HandlerFunc(loginHandler.Login))
+		public.handle(http.MethodPost, "/logout", http.HandlerFunc(logoutHandler.Logout))
+		public.handle(http.MethodPost, "/signup", http.HandlerFunc(signupHandler.CreateUser))
+		public.handle(http.MethodGet, "/calendar/{file}", http.HandlerFunc(calendarHandler.ServeFeed))
+		public.handle(http.MethodGet, "/exports/download/{token}", http.HandlerFunc(exportHandler.Download))
+
+		authenticated.handle(http.MethodGet, "/auth", handlers.AuthenticatedHandlerFunc(authHandler.Authenticate))
+
+		authenticated.handle(http.MethodGet, "/tasks", handlers.AuthenticatedHandlerFunc(taskHandler.GetTasks))
+		authenticated.handle(http.MethodPost, "/tasks", handlers.AuthenticatedHandlerFunc(taskHandler.CreateTask))
+		authenticated.handle(http.MethodPost, "/tasks/import", handlers.AuthenticatedHandlerFunc(importHandler.Import))
+		authenticated.handle(http.MethodGet, "/task/{id}", handlers.AuthenticatedHandlerFunc(taskHandler.GetTask))
+		authenticated.handle(http.MethodPut, "/task/{id}", handlers.AuthenticatedHandlerFunc(taskHandler.CompleteTask))
+		authenticated.handle(http.MethodDelete, "/task/{id}", handlers.AuthenticatedHandlerFunc(taskHandler.DeleteTask))
+		authenticated.handle(http.MethodPatch, "/task/{id}", handlers.AuthenticatedHandlerFunc(taskHandler.PatchTask))
+		authenticated.handle(http.MethodPut, "/task/update/{id}", handlers.AuthenticatedHandlerFunc(taskHandler.UpdateTask))
+		authenticated.handle(http.MethodPut, "/task/star/{id}", handlers.AuthenticatedHandlerFunc(taskHandler.StarTask))
+		authenticated.handle(http.MethodDelete, "/task/star/{id}", handlers.AuthenticatedHandlerFunc(taskHandler.UnstarTask))
+		authenticated.handle(http.MethodGet, "/task/comments/{id}", handlers.AuthenticatedHandlerFunc(commentHandler.GetComments))
+		authenticated.handle(http.MethodPost, "/task/comments/{id}", handlers.AuthenticatedHandlerFunc(commentHandler.CreateComment))
+		authenticated.handle(http.MethodPut, "/comment/{id}", handlers.AuthenticatedHandlerFunc(commentHandler.UpdateComment))
+		authenticated.handle(http.MethodDelete, "/comment/{id}", handlers.AuthenticatedHandlerFunc(commentHandler.DeleteComment))
+
+		authenticated.handle(http.MethodGet, "/tasks_history", handlers.AuthenticatedHandlerFunc(taskHistoryHandler.GetTasksHistory))
+		authenticated.handle(http.MethodGet, "/task_history/{id}", handlers.AuthenticatedHandlerFunc(taskHistoryHandler.GetTaskHistory))
+		authenticated.handle(http.MethodPut, "/task_history/{id}", handlers.AuthenticatedHandlerFunc(taskHistoryHandler.UpdateTaskHistory))
+		authenticated.handle(http.MethodPatch, "/task_history/{id}", handlers.AuthenticatedHandlerFunc(taskHistoryHandler.PatchTaskHistory))
+		authenticated.handle(http.MethodDelete, "/task_history/{id}", handlers.AuthenticatedHandlerFunc(taskHistoryHandler.DeleteTaskAndHistory))
+
+		authenticated.handle(http.MethodGet, "/lists", handlers.AuthenticatedHandlerFunc(listHandler.GetLists))
+		authenticated.handle(http.MethodPost, "/lists", handlers.AuthenticatedHandlerFunc(listHandler.CreateList))
+		authenticated.handle(http.MethodGet, "/list/{id}", handlers.AuthenticatedHandlerFunc(listHandler.GetList))
+		authenticated.handle(http.MethodPut, "/list/{id}", handlers.AuthenticatedHandlerFunc(listHandler.RenameList))
+		authenticated.handle(http.MethodDelete, "/list/{id}", handlers.AuthenticatedHandlerFunc(listHandler.DeleteList))
+		authenticated.handle(http.MethodGet, "/list/{id}/members", handlers.AuthenticatedHandlerFunc(listHandler.GetMembers))
+		authenticated.handle(http.MethodPut, "/list/{id}/members/{userId}", handlers.AuthenticatedHandlerFunc(listHandler.UpdateMember))
+		authenticated.handle(http.MethodDelete, "/list/{id}/members/{userId}", handlers.AuthenticatedHandlerFunc(listHandler.RemoveMember))
+		authenticated.handle(http.MethodGet, "/list/{id}/invitations", handlers.AuthenticatedHandlerFunc(listHandler.GetListInvitations))
+		authenticated.handle(http.MethodPost, "/list/{id}/invitations", handlers.AuthenticatedHandlerFunc(listHandler.Invite))
+		authenticated.handle(http.MethodGet, "/invitations", handlers.AuthenticatedHandlerFunc(listHandler.GetInvitations))
+		authenticated.handle(http.MethodPost, "/invitation/{id}/accept", handlers.AuthenticatedHandlerFunc(listHandler.AcceptInvitation))
+		authenticated.handle(http.MethodDelete, "/invitation/{id}", handlers.AuthenticatedHandlerFunc(listHandler.DeleteInvitation))
+
+		authenticated.handle(http.MethodGet, "/notifications", handlers.AuthenticatedHandlerFunc(notificationHandler.GetNotifications))
+		authenticated.handle(http.MethodPost, "/notifications/read", handlers.AuthenticatedHandlerFunc(notificationHandler.MarkAllRead))
+		authenticated.handle(http.MethodGet, "/notifications/preferences", handlers.AuthenticatedHandlerFunc(notificationHandler.GetPreferences))
+		authenticated.handle(http.MethodPut, "/notifications/preferences", handlers.AuthenticatedHandlerFunc(notificationHandler.UpdatePreferences))
+		authenticated.handle(http.MethodPost, "/notification/{id}/read", handlers.AuthenticatedHandlerFunc(notificationHandler.MarkRead))
+		authenticated.handle(http.MethodDelete, "/notification/{id}", handlers.AuthenticatedHandlerFunc(notificationHandler.DeleteNotification))
+		authenticated.handle(http.MethodGet, "/push/vapid-key", handlers.AuthenticatedHandlerFunc(pushHandler.GetVAPIDKey))
+		authenticated.handle(http.MethodGet, "/push/subscriptions", handlers.AuthenticatedHandlerFunc(pushHandler.GetSubscriptions))
+		authenticated.handle(http.MethodPost, "/push/subscriptions", handlers.AuthenticatedHandlerFunc(pushHandler.Subscribe))
+		authenticated.handle(http.MethodDelete, "/push/subscription/{id}", handlers.AuthenticatedHandlerFunc(pushHandler.Unsubscribe))
+
+		authenticated.handle(http.MethodGet, "/sync", handlers.AuthenticatedHandlerFunc(syncHandler.Sync))
+		authenticated.handle(http.MethodPost, "/sync", handlers.AuthenticatedHandlerFunc(syncHandler.ApplyBatch))
+		authenticated.handle(http.MethodGet, "/events", handlers.AuthenticatedHandlerFunc(eventsHandler.Stream))
+
+		authenticated.handle(http.MethodGet, "/calendar", handlers.AuthenticatedHandlerFunc(calendarHandler.GetFeed))
+		authenticated.handle(http.MethodPost, "/calendar", handlers.AuthenticatedHandlerFunc(calendarHandler.RotateFeed))
+		authenticated.handle(http.MethodDelete, "/calendar", handlers.AuthenticatedHandlerFunc(calendarHandler.DeleteFeed))
+
+		authenticated.handle(http.MethodGet, "/user", handlers.AuthenticatedHandlerFunc(userHandler.GetUser))
+		authenticated.handle(http.MethodPut, "/user", handlers.AuthenticatedHandlerFunc(userHandler.UpdateUser))
+		authenticated.handle(http.MethodPatch, "/user", handlers.AuthenticatedHandlerFunc(userHandler.PatchUser))
+		authenticated.handle(http.MethodDelete, "/user", handlers.AuthenticatedHandlerFunc(userHandler.DeleteUser))
+		authenticated.handle(http.MethodGet, "/user/icon", handlers.AuthenticatedHandlerFunc(userHandler.GetIcon))
+		authenticated.handle(http.MethodPost, "/user/icon", handlers.AuthenticatedHandlerFunc(userHandler.UploadIcon))
+
+		authenticated.handle(http.MethodPost, "/exports", handlers.AuthenticatedHandlerFunc(exportHandler.CreateExport))
+		authenticated.handle(http.MethodGet, "/exports/{id}", handlers.AuthenticatedHandlerFunc(exportHandler.GetExport))
+	}
+
+	r.mountVersion("/v1", nil, false, authMiddleware, v1)
+	// Unversioned paths of clients released before versioning, served by the v1 handlers
+	r.mountVersion("", &handlers.Deprecation{
+		DeprecatedAt:    cfg.LegacyAPIDeprecatedAt,
+		Sunset:          cfg.LegacyAPISunset,
+		SuccessorPrefix: "/v1",
+	}, true, authMiddleware, v1)
+
+	// Middleware shared by every route, CORS comes before routing so preflight requests
+	// are answered without a route for the OPTIONS method
+	var handler http.Handler = handlers.RecordRouteMiddleware(r.mux)
+	handler = handlers.TrimTrailingSlashMiddleware(handler)
+	handler = handlers.CORSMiddleware(handler, cfg.CORSOrigins)
+	handler = handlers.MetricsMiddleware(handler)
+	handler = handlers.RequestLogMiddleware(handler)
+	r.server.Handler = handler
+}
+
+// Routes returns the route table sorted by path and method
+func (r *Router) Routes() []Route {
+	routes := append([]Route(nil), r.routes...)
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// NewMetricsServer returns the server for the separate metrics listener
+func NewMetricsServer(cfg *config.Config) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", handlers.MetricsAuthMiddleware(metrics.Default.Handler(), cfg.MetricsToken))
+	return &http.Server{
+		Addr:              cfg.MetricsAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+	}
+}
+
+// ListenAndServe blocks until the server fails or is shut down, a shutdown is not reported as an error
+func (r *Router) ListenAndServe() error {
+	err := r.server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Drain makes the readiness check fail, so load balancers stop routing new requests to this instance
+func (r *Router) Drain() {
+	r.draining.Store(true)
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests until ctx is done
+func (r *Router) Shutdown(ctx context.Context) error {
+	return r.server.Shutdown(ctx)
 }
@@ -0,0 +1,482 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/logging"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the server that used to be hard-coded.
+// Values are resolved in the following order, later sources overriding earlier ones:
+// defaults, optional YAML config file (path from CONFIG_FILE), environment variables
+// Fields tagged with secret are redacted when the effective config is printed
+type Config struct {
+	ListenAddress       string        `yaml:"listenAddress" env:"LISTEN_ADDRESS"`
+	DatabaseURL         string        `yaml:"databaseUrl" env:"DATABASE_URL" secret:"true"`
+	CORSOrigins         []string      `yaml:"corsOrigins" env:"CORS_ORIGINS"`
+	TokenLifetime       time.Duration `yaml:"tokenLifetime" env:"TOKEN_LIFETIME"`
+	IconUploadDirectory string        `yaml:"iconUploadDirectory" env:"ICON_UPLOAD_DIRECTORY"`
+	MaxIconSize         int64         `yaml:"maxIconSize" env:"MAX_ICON_SIZE"`
+	BcryptCost          int           `yaml:"bcryptCost" env:"BCRYPT_COST"`
+	MaxImportSize       int64         `yaml:"maxImportSize" env:"MAX_IMPORT_SIZE"`
+
+	ReadTimeout            time.Duration `yaml:"readTimeout" env:"READ_TIMEOUT"`
+	ReadHeaderTimeout      time.Duration `yaml:"readHeaderTimeout" env:"READ_HEADER_TIMEOUT"`
+	WriteTimeout           time.Duration `yaml:"writeTimeout" env:"WRITE_TIMEOUT"`
+	IdleTimeout            time.Duration `yaml:"idleTimeout" env:"IDLE_TIMEOUT"`
+	ShutdownTimeout        time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT"`
+	SessionCleanupInterval time.Duration `yaml:"sessionCleanupInterval" env:"SESSION_CLEANUP_INTERVAL"`
+	QueryTimeout           time.Duration `yaml:"queryTimeout" env:"QUERY_TIMEOUT"`
+	LogLevel               string        `yaml:"logLevel" env:"LOG_LEVEL"`
+
+	BcryptConcurrency int    `yaml:"bcryptConcurrency" env:"BCRYPT_CONCURRENCY"`
+	MetricsAddress    string `yaml:"metricsAddress" env:"METRICS_ADDRESS"`
+	MetricsToken      string `yaml:"metricsToken" env:"METRICS_TOKEN" secret:"true"`
+
+	MigrateOnStartup   bool          `yaml:"migrateOnStartup" env:"MIGRATE_ON_STARTUP"`
+	ShutdownDrainDelay time.Duration `yaml:"shutdownDrainDelay" env:"SHUTDOWN_DRAIN_DELAY"`
+
+	LegacyAPIDeprecatedAt time.Time `yaml:"legacyApiDeprecatedAt" env:"LEGACY_API_DEPRECATED_AT"`
+	LegacyAPISunset       time.Time `yaml:"legacyApiSunset" env:"LEGACY_API_SUNSET"`
+
+	SyncTombstoneRetention       time.Duration `yaml:"syncTombstoneRetention" env:"SYNC_TOMBSTONE_RETENTION"`
+	SyncTombstoneCleanupInterval time.Duration `yaml:"syncTombstoneCleanupInterval" env:"SYNC_TOMBSTONE_CLEANUP_INTERVAL"`
+	IdempotencyKeyLifetime       time.Duration `yaml:"idempotencyKeyLifetime" env:"IDEMPOTENCY_KEY_LIFETIME"`
+	IdempotencyCleanupInterval   time.Duration `yaml:"idempotencyCleanupInterval" env:"IDEMPOTENCY_CLEANUP_INTERVAL"`
+
+	EventsHeartbeatInterval time.Duration `yaml:"eventsHeartbeatInterval" env:"EVENTS_HEARTBEAT_INTERVAL"`
+	EventsReplayBuffer      int           `yaml:"eventsReplayBuffer" env:"EVENTS_REPLAY_BUFFER"`
+	EventsBus               string        `yaml:"eventsBus" env:"EVENTS_BUS"`
+
+	ExportDirectory    string        `yaml:"exportDirectory" env:"EXPORT_DIRECTORY"`
+	ExportLifetime     time.Duration `yaml:"exportLifetime" env:"EXPORT_LIFETIME"`
+	ExportPollInterval time.Duration `yaml:"exportPollInterval" env:"EXPORT_POLL_INTERVAL"`
+
+	AccountDeletionGracePeriod time.Duration `yaml:"accountDeletionGracePeriod" env:"ACCOUNT_DELETION_GRACE_PERIOD"`
+
+	// SMTPAddress enables email notifications, empty means users can only choose in-app notifications
+	SMTPAddress       string        `yaml:"smtpAddress" env:"SMTP_ADDRESS"`
+	SMTPUsername      string        `yaml:"smtpUsername" env:"SMTP_USERNAME"`
+	SMTPPassword      string        `yaml:"smtpPassword" env:"SMTP_PASSWORD" secret:"true"`
+	SMTPFrom          string        `yaml:"smtpFrom" env:"SMTP_FROM"`
+	EmailPollInterval time.Duration `yaml:"emailPollInterval" env:"EMAIL_POLL_INTERVAL"`
+
+	// VAPIDSubject enables Web Push, it is the mailto: or https: contact sent to push services.
+	// Without VAPIDPrivateKey a key is generated once and stored in the database
+	VAPIDSubject     string        `yaml:"vapidSubject" env:"VAPID_SUBJECT"`
+	VAPIDPrivateKey  string        `yaml:"vapidPrivateKey" env:"VAPID_PRIVATE_KEY" secret:"true"`
+	PushTTL          time.Duration `yaml:"pushTtl" env:"PUSH_TTL"`
+	PushPollInterval time.Duration `yaml:"pushPollInterval" env:"PUSH_POLL_INTERVAL"`
+	PushAllowHTTP    bool          `yaml:"pushAllowHttp" env:"PUSH_ALLOW_HTTP"`
+
+	ReminderLeadTime time.Duration `yaml:"reminderLeadTime" env:"REMINDER_LEAD_TIME"`
+	ReminderInterval time.Duration `yaml:"reminderInterval" env:"REMINDER_INTERVAL"`
+
+	// PublicURL is the address clients reach the server at, used for links handed to other
+	// applications (e.g. calendar feeds). Empty means it is taken from the request
+	PublicURL string `yaml:"publicUrl" env:"PUBLIC_URL"`
+}
+
+const ConfigFileEnv = "CONFIG_FILE"
+
+func Default() Config {
+	return Config{
+		ListenAddress:       ":8080",
+		CORSOrigins:         []string{"http://localhost:4200"},
+		TokenLifetime:       7 * 24 * time.Hour,
+		IconUploadDirectory: "uploads/profile_icons",
+		MaxIconSize:         500000,
+		BcryptCost:          14,
+		MaxImportSize:       5000000,
+
+		ReadTimeout:            15 * time.Second,
+		ReadHeaderTimeout:      5 * time.Second,
+		WriteTimeout:           30 * time.Second,
+		IdleTimeout:            2 * time.Minute,
+		ShutdownTimeout:        20 * time.Second,
+		SessionCleanupInterval: time.Hour,
+		QueryTimeout:           10 * time.Second,
+		LogLevel:               "info",
+
+		BcryptConcurrency: runtime.NumCPU(),
+
+		MigrateOnStartup:   true,
+		ShutdownDrainDelay: 5 * time.Second,
+
+		SyncTombstoneRetention:       30 * 24 * time.Hour,
+		SyncTombstoneCleanupInterval: time.Hour,
+		IdempotencyKeyLifetime:       24 * time.Hour,
+		IdempotencyCleanupInterval:   time.Hour,
+
+		EventsHeartbeatInterval: 15 * time.Second,
+		EventsReplayBuffer:      1000,
+		EventsBus:               "postgres",
+
+		ExportDirectory:    "uploads/exports",
+		ExportLifetime:     24 * time.Hour,
+		ExportPollInterval: 5 * time.Second,
+
+		AccountDeletionGracePeriod: 30 * 24 * time.Hour,
+
+		EmailPollInterval: 10 * time.Second,
+
+		PushTTL:          24 * time.Hour,
+		PushPollInterval: 5 * time.Second,
+
+		ReminderLeadTime: time.Hour,
+		ReminderInterval: time.Minute,
+	}
+}
+
+// Load builds the effective config from defaults, the config file and the environment and validates it
+func Load() (*Config, error) {
+	cfg := Default()
+	if path := os.Getenv(ConfigFileEnv); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	var fileConfig fileConfig
+	if err := yaml.Unmarshal(content, &fileConfig); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return cfg.apply(fileConfig.values)
+}
+
+// fileConfig collects raw string values from the config file so that they are parsed
+// exactly like environment variables (durations as "168h", lists as YAML sequences or comma separated)
+type fileConfig struct {
+	values map[string]string
+}
+
+func (fc *fileConfig) UnmarshalYAML(node *yaml.Node) error {
+	var raw map[string]yaml.Node
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, field := range reflect.VisibleFields(reflect.TypeOf(Config{})) {
+		known[field.Tag.Get("yaml")] = true
+	}
+	fc.values = map[string]string{}
+	for key, value := range raw {
+		if !known[key] {
+			return fmt.Errorf("unknown config key %q", key)
+		}
+		if value.Kind == yaml.SequenceNode {
+			var items []string
+			if err := value.Decode(&items); err != nil {
+				return fmt.Errorf("config key %q: %w", key, err)
+			}
+			fc.values[key] = strings.Join(items, ",")
+			continue
+		}
+		fc.values[key] = value.Value
+	}
+	return nil
+}
+
+func (cfg *Config) apply(values map[string]string) error {
+	configValue := reflect.ValueOf(cfg).Elem()
+	for _, field := range reflect.VisibleFields(configValue.Type()) {
+		raw, ok := values[field.Tag.Get("yaml")]
+		if !ok {
+			continue
+		}
+		if err := setField(configValue.FieldByIndex(field.Index), raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", field.Tag.Get("yaml"), err)
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) loadEnv(lookup func(string) (string, bool)) error {
+	configValue := reflect.ValueOf(cfg).Elem()
+	for _, field := range reflect.VisibleFields(configValue.Type()) {
+		name := field.Tag.Get("env")
+		raw, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(configValue.FieldByIndex(field.Index), raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case time.Duration:
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+	case time.Time:
+		date, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			date, err = time.Parse(time.RFC3339, raw)
+		}
+		if err != nil {
+			return errors.New("expected a date (2006-01-02) or a RFC 3339 timestamp")
+		}
+		field.Set(reflect.ValueOf(date))
+	case int, int64:
+		number, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(number)
+	case bool:
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(value)
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate checks the config for values the server can't start with
+func (cfg *Config) Validate() error {
+	var errs []error
+	if cfg.ListenAddress == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	if cfg.DatabaseURL == "" {
+		errs = append(errs, errors.New("database url is required"))
+	}
+	if len(cfg.CORSOrigins) == 0 {
+		errs = append(errs, errors.New("at least one cors origin is required"))
+	}
+	for _, origin := range cfg.CORSOrigins {
+		originUrl, err := url.Parse(origin)
+		if err != nil || originUrl.Scheme == "" || originUrl.Host == "" || (originUrl.Path != "" && originUrl.Path != "/") {
+			errs = append(errs, fmt.Errorf("invalid cors origin %q", origin))
+		}
+	}
+	if cfg.TokenLifetime <= 0 {
+		errs = append(errs, errors.New("token lifetime must be positive"))
+	}
+	if cfg.IconUploadDirectory == "" {
+		errs = append(errs, errors.New("icon upload directory is required"))
+	}
+	if cfg.MaxIconSize <= 0 {
+		errs = append(errs, errors.New("max icon size must be positive"))
+	}
+	if cfg.MaxImportSize <= 0 {
+		errs = append(errs, errors.New("max import size must be positive"))
+	}
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	for name, timeout := range map[string]time.Duration{
+		"read timeout":        cfg.ReadTimeout,
+		"read header timeout": cfg.ReadHeaderTimeout,
+		"write timeout":       cfg.WriteTimeout,
+		"idle timeout":        cfg.IdleTimeout,
+	} {
+		if timeout < 0 {
+			errs = append(errs, fmt.Errorf("%s can't be negative", name))
+		}
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown timeout must be positive"))
+	}
+	if cfg.SessionCleanupInterval <= 0 {
+		errs = append(errs, errors.New("session cleanup interval must be positive"))
+	}
+	if cfg.QueryTimeout <= 0 {
+		errs = append(errs, errors.New("query timeout must be positive"))
+	}
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.BcryptConcurrency <= 0 {
+		errs = append(errs, errors.New("bcrypt concurrency must be positive"))
+	}
+	if cfg.ShutdownDrainDelay < 0 {
+		errs = append(errs, errors.New("shutdown drain delay can't be negative"))
+	}
+	if !cfg.LegacyAPISunset.IsZero() && cfg.LegacyAPISunset.Before(cfg.LegacyAPIDeprecatedAt) {
+		errs = append(errs, errors.New("legacy api sunset must be after its deprecation date"))
+	}
+	if cfg.SyncTombstoneRetention <= 0 {
+		errs = append(errs, errors.New("sync tombstone retention must be positive"))
+	}
+	if cfg.SyncTombstoneCleanupInterval <= 0 {
+		errs = append(errs, errors.New("sync tombstone cleanup interval must be positive"))
+	}
+	if cfg.IdempotencyKeyLifetime <= 0 {
+		errs = append(errs, errors.New("idempotency key lifetime must be positive"))
+	}
+	if cfg.IdempotencyCleanupInterval <= 0 {
+		errs = append(errs, errors.New("idempotency cleanup interval must be positive"))
+	}
+	if cfg.EventsHeartbeatInterval <= 0 {
+		errs = append(errs, errors.New("events heartbeat interval must be positive"))
+	}
+	if cfg.EventsReplayBuffer < 0 {
+		errs = append(errs, errors.New("events replay buffer can't be negative"))
+	}
+	if cfg.EventsBus != "local" && cfg.EventsBus != "postgres" {
+		errs = append(errs, fmt.Errorf("invalid events bus %q, must be local or postgres", cfg.EventsBus))
+	}
+	if cfg.ExportDirectory == "" {
+		errs = append(errs, errors.New("export directory is required"))
+	}
+	if cfg.ExportLifetime <= 0 {
+		errs = append(errs, errors.New("export lifetime must be positive"))
+	}
+	if cfg.ExportPollInterval <= 0 {
+		errs = append(errs, errors.New("export poll interval must be positive"))
+	}
+	if cfg.AccountDeletionGracePeriod < 0 {
+		errs = append(errs, errors.New("account deletion grace period can't be negative"))
+	}
+	if cfg.EmailEnabled() {
+		if _, _, err := net.SplitHostPort(cfg.SMTPAddress); err != nil {
+			errs = append(errs, fmt.Errorf("invalid smtp address %q, must be host:port", cfg.SMTPAddress))
+		}
+		if _, err := mail.ParseAddress(cfg.SMTPFrom); err != nil {
+			errs = append(errs, errors.New("smtp from must be an email address when smtp is configured"))
+		}
+		if cfg.EmailPollInterval <= 0 {
+			errs = append(errs, errors.New("email poll interval must be positive"))
+		}
+	}
+	if cfg.PushEnabled() {
+		if !strings.HasPrefix(cfg.VAPIDSubject, "mailto:") && !strings.HasPrefix(cfg.VAPIDSubject, "https:") {
+			errs = append(errs, fmt.Errorf("invalid vapid subject %q, must be a mailto: or https: url", cfg.VAPIDSubject))
+		}
+		if cfg.PushTTL <= 0 {
+			errs = append(errs, errors.New("push ttl must be positive"))
+		}
+		if cfg.PushPollInterval <= 0 {
+			errs = append(errs, errors.New("push poll interval must be positive"))
+		}
+	} else if cfg.VAPIDPrivateKey != "" {
+		errs = append(errs, errors.New("vapid private key requires a vapid subject"))
+	}
+	if cfg.ReminderLeadTime < 0 {
+		errs = append(errs, errors.New("reminder lead time can't be negative"))
+	}
+	if cfg.ReminderLeadTime > 0 && cfg.ReminderInterval <= 0 {
+		errs = append(errs, errors.New("reminder interval must be positive"))
+	}
+	if cfg.PublicURL != "" {
+		publicUrl, err := url.Parse(cfg.PublicURL)
+		if err != nil || (publicUrl.Scheme != "http" && publicUrl.Scheme != "https") || publicUrl.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid public url %q", cfg.PublicURL))
+		}
+	}
+	if cfg.MetricsAddress != "" && cfg.MetricsAddress == cfg.ListenAddress {
+		errs = append(errs, errors.New("metrics address must differ from the listen address"))
+	}
+	return errors.Join(errs...)
+}
+
+// EmailEnabled reports whether notifications can be sent by email
+func (cfg *Config) EmailEnabled() bool {
+	return cfg.SMTPAddress != ""
+}
+
+// PushEnabled reports whether notifications are pushed to browsers
+func (cfg *Config) PushEnabled() bool {
+	return cfg.VAPIDSubject != ""
+}
+
+// MetricsEnabled reports whether /metrics is exposed, either on its own listener or on the main one protected by a token
+func (cfg *Config) MetricsEnabled() bool {
+	return cfg.MetricsAddress != "" || cfg.MetricsToken != ""
+}
+
+// String returns the effective config, one setting per line, with secrets redacted
+func (cfg Config) String() string {
+	var builder strings.Builder
+	for _, setting := range cfg.settings() {
+		fmt.Fprintf(&builder, "%s=%s\n", setting.Key, setting.Value)
+	}
+	return builder.String()
+}
+
+// LogValue logs the effective config as a group of settings with secrets redacted
+func (cfg Config) LogValue() slog.Value {
+	return slog.GroupValue(cfg.settings()...)
+}
+
+func (cfg Config) settings() []slog.Attr {
+	var settings []slog.Attr
+	configValue := reflect.ValueOf(cfg)
+	for _, field := range reflect.VisibleFields(configValue.Type()) {
+		value := configValue.FieldByIndex(field.Index).Interface()
+		text := fmt.Sprintf("%v", value)
+		switch typed := value.(type) {
+		case []string:
+			text = strings.Join(typed, ",")
+		case time.Time:
+			text = typed.Format(time.RFC3339)
+		}
+		if field.Tag.Get("secret") == "true" {
+			text = redact(text)
+		}
+		settings = append(settings, slog.String(field.Tag.Get("env"), text))
+	}
+	return settings
+}
+
+// redact hides a secret value, for urls only the password is hidden so the host stays visible
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	parsedUrl, err := url.Parse(value)
+	if err == nil && parsedUrl.Scheme != "" && parsedUrl.Host != "" {
+		if parsedUrl.Query().Has("password") {
+			parsedUrl.RawQuery = ""
+		}
+		return parsedUrl.Redacted()
+	}
+	return "REDACTED"
+}
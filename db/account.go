@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInvalidPassword = errors.New("invalid password")
+
+// accountDeletionRetryAfter is how long an account whose deletion failed waits before it is tried again
+const accountDeletionRetryAfter = time.Hour
+
+// ScheduleAccountDeletion checks the password, revokes every session of the user and schedules the
+// deletion of the account after the grace period. Logging in before then cancels the deletion
+func (dbService *DatabaseService) ScheduleAccountDeletion(ctx context.Context, userId uuid.UUID, password string) (*time.Time, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	// the password is checked before the row is locked, waiting for a bcrypt slot mustn't hold the lock
+	var passwordHash string
+	err := dbService.pool.QueryRow(ctx, "SELECT u.password FROM \"user\" u WHERE u.id = $1", userId).Scan(&passwordHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("user doesn't exist")
+		}
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+	var passwordCheck bool
+	err = dbService.withBcryptSlot(ctx, func() {
+		passwordCheck = MatchPassword(password, passwordHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !passwordCheck {
+		return nil, ErrInvalidPassword
+	}
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+	defer tx.Rollback(ctx)
+
+	// the password may have changed while it was checked
+	var currentHash string
+	err = tx.QueryRow(ctx, "SELECT u.password FROM \"user\" u WHERE u.id = $1 FOR UPDATE", userId).Scan(&currentHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("user doesn't exist")
+		}
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+	if currentHash != passwordHash {
+		return nil, ErrInvalidPassword
+	}
+
+	deleteAfter := time.Now().Add(dbService.accountDeletionGracePeriod).Truncate(time.Second)
+	_, err = tx.Exec(ctx, "UPDATE \"user\" SET deletion_requested_at = CURRENT_TIMESTAMP, delete_after = $1 WHERE id = $2", deleteAfter, userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+	rows, err := tx.Query(ctx, "DELETE FROM user_auth ua WHERE ua.user_id = $1 RETURNING ua.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+	tokenIds, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+	slog.InfoContext(ctx, "account deletion scheduled", "user_id", userId, "delete_after", deleteAfter)
+	for _, tokenId := range tokenIds {
+		dbService.publish(userId, events.SessionInvalidated, tokenId)
+	}
+	return &deleteAfter, nil
+}
+
+// DeleteDueAccount deletes one account whose grace period has passed, with everything that belongs
+// to it, and writes the audit record. It returns nil when no account is due. When the account can't
+// be deleted the failure is recorded and it is returned with the error, it is tried again after
+// accountDeletionRetryAfter so the other due accounts aren't held up by it
+func (dbService *DatabaseService) DeleteDueAccount(ctx context.Context) (*DeletedAccount, error) {
+	account, err := dbService.deleteDueAccount(ctx)
+	if err != nil && account != nil && ctx.Err() == nil {
+		if err := dbService.failAccountDeletion(ctx, account.UserId); err != nil {
+			return nil, err
+		}
+	}
+	return account, err
+}
+
+// failAccountDeletion postpones the next attempt to delete the account
+func (dbService *DatabaseService) failAccountDeletion(ctx context.Context, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(ctx, "UPDATE \"user\" SET deletion_attempts = deletion_attempts + 1, deletion_failed_at = CURRENT_TIMESTAMP WHERE id = $1", userId)
+	if err != nil {
+		return queryError(ctx, err, "error while recording failed account deletion")
+	}
+	return nil
+}
+
+func (dbService *DatabaseService) deleteDueAccount(ctx context.Context) (*DeletedAccount, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+	defer tx.Rollback(ctx)
+
+	var account DeletedAccount
+	var createdAt, requestedAt time.Time
+	err = tx.QueryRow(
+		ctx,
+		`SELECT u.id, u.created_at, u.deletion_requested_at FROM "user" u
+		WHERE u.delete_after <= CURRENT_TIMESTAMP AND (u.deletion_failed_at IS NULL OR u.deletion_failed_at <= $1)
+		ORDER BY u.delete_after LIMIT 1 FOR UPDATE SKIP LOCKED`,
+		time.Now().Add(-accountDeletionRetryAfter),
+	).Scan(&account.UserId, &createdAt, &requestedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, queryError(ctx, err, "error while deleting account")
+	}
+
+	rows, err := tx.Query(ctx, "SELECT ae.id FROM account_export ae WHERE ae.user_id = $1", account.UserId)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	account.ExportIds, err = pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+
+	// lists the user owns are deleted with everything in them, the tasks the user created in other lists
+	// stay there and are handed over to the owners of those lists
+	rows, err = tx.Query(ctx, "SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = $1 AND tlm.role = 'owner'", account.UserId)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	ownedLists, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	rows, err = tx.Query(ctx, "SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = $1 AND tlm.role <> 'owner'", account.UserId)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	joinedLists, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	removedMembers := map[uuid.UUID][]uuid.UUID{}
+	for _, listId := range ownedLists {
+		removedMembers[listId], err = deleteList(ctx, tx, listId)
+		if err != nil {
+			return &account, queryError(ctx, err, "error while deleting account")
+		}
+	}
+	_, err = tx.Exec(
+		ctx,
+		"UPDATE task t SET created_by = tlm.user_id FROM task_list_member tlm WHERE t.created_by = $1 AND tlm.list_id = t.list_id AND tlm.role = 'owner'",
+		account.UserId,
+	)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+
+	// history entries go before their tasks and the tombstones written by the delete triggers last
+	historyTag, err := tx.Exec(ctx, "DELETE FROM task_history th USING task t WHERE th.task_id = t.id AND t.created_by = $1", account.UserId)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	taskTag, err := tx.Exec(ctx, "DELETE FROM task WHERE created_by = $1", account.UserId)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	statements := []string{
+		"DELETE FROM user_auth WHERE user_id = $1",
+		// idempotency keys, the calendar feed, exports, memberships and invitations are deleted by ON DELETE CASCADE
+		"DELETE FROM \"user\" WHERE id = $1",
+		"DELETE FROM sync_tombstone WHERE owner_id = $1",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, account.UserId); err != nil {
+			return &account, queryError(ctx, err, "error while deleting account")
+		}
+	}
+	_, err = tx.Exec(
+		ctx,
+		"INSERT INTO account_deletion_audit(account_created_on, deletion_requested_at, task_count, task_history_count) VALUES ($1, $2, $3, $4)",
+		createdAt.UTC().Truncate(24*time.Hour),
+		requestedAt,
+		taskTag.RowsAffected(),
+		historyTag.RowsAffected(),
+	)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return &account, queryError(ctx, err, "error while deleting account")
+	}
+	var messages []events.Message
+	for listId, memberIds := range removedMembers {
+		for _, memberId := range memberIds {
+			if memberId != account.UserId {
+				messages = append(messages, events.Message{UserId: memberId, Type: events.ListRemoved, Id: listId})
+			}
+		}
+	}
+	dbService.publishMessages(messages)
+	for _, listId := range joinedLists {
+		dbService.publishToList(ctx, listId, events.ListUpdated)
+	}
+	return &account, nil
+}
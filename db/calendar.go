@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrCalendarFeedNotFound = errors.New("calendar feed doesn't exist")
+
+func (dbService *DatabaseService) GetCalendarFeed(ctx context.Context, userId uuid.UUID) (*CalendarFeedDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var feed CalendarFeedDB
+	err := dbService.pool.QueryRow(ctx, "SELECT cf.token, cf.created_at FROM calendar_feed cf WHERE cf.user_id = $1", userId).Scan(&feed.Token, &feed.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCalendarFeedNotFound
+		}
+		return nil, queryError(ctx, err, "error while getting calendar feed")
+	}
+	return &feed, nil
+}
+
+// RotateCalendarFeed creates the user's feed or replaces its secret
+func (dbService *DatabaseService) RotateCalendarFeed(ctx context.Context, userId uuid.UUID) (*CalendarFeedDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	token, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	feed := CalendarFeedDB{Token: token}
+	err = dbService.pool.QueryRow(
+		ctx,
+		`INSERT INTO calendar_feed(user_id, token) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET token = EXCLUDED.token, created_at = CURRENT_TIMESTAMP
+		RETURNING created_at`,
+		userId,
+		feed.Token,
+	).Scan(&feed.CreatedAt)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while rotating calendar feed")
+	}
+	return &feed, nil
+}
+
+func (dbService *DatabaseService) DeleteCalendarFeed(ctx context.Context, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(ctx, "DELETE FROM calendar_feed WHERE user_id = $1", userId)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting calendar feed")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrCalendarFeedNotFound
+	}
+	return nil
+}
+
+// GetCalendarFeedTasks returns the tasks with a deadline the user the feed secret belongs to can read,
+// including the tasks of shared lists, completed tasks only when includeCompleted is set
+func (dbService *DatabaseService) GetCalendarFeedTasks(ctx context.Context, token string, includeCompleted bool) ([]TaskDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var userId uuid.UUID
+	err := dbService.pool.QueryRow(ctx, "SELECT cf.user_id FROM calendar_feed cf WHERE cf.token = $1", token).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCalendarFeedNotFound
+		}
+		return nil, queryError(ctx, err, "error while getting calendar feed")
+	}
+
+	query := "SELECT " + taskColumns + " FROM task t WHERE " + taskReadable("$1") + " AND t.deadline IS NOT NULL"
+	if !includeCompleted {
+		query += " AND t.exec_status = 'ACTIVE'"
+	}
+	query += " ORDER BY t.deadline, t.id"
+	rows, err := dbService.pool.Query(ctx, query, userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting tasks from database")
+	}
+	defer rows.Close()
+	var tasks []TaskDB
+	for rows.Next() {
+		var task TaskDB
+		err := scanTask(rows, &task)
+		if err != nil {
+			return nil, queryError(ctx, err, "error while iterating dataset")
+		}
+		tasks = append(tasks, task)
+	}
+	if rows.Err() != nil {
+		return nil, queryError(ctx, rows.Err(), "error while iterating dataset")
+	}
+	return tasks, nil
+}
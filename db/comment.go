@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const MaxCommentLength = 4000
+
+// MaxCommentPageSize limits the number of comments returned at once
+const MaxCommentPageSize = 100
+
+var ErrCommentNotFound = errors.New("comment doesn't exist")
+var ErrInvalidComment = errors.New("comment must have between 1 and 4000 characters")
+
+// mentionPattern matches @username, the @ can't follow a letter or digit so email addresses aren't mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([\p{L}\p{N}_.\-]+)`)
+
+// parseMentions returns the usernames mentioned in the comment, without duplicates. Trailing dots and dashes
+// are punctuation, e.g. "thanks @ana."
+func parseMentions(body string) []string {
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		username := strings.TrimRight(match[1], ".-")
+		if username != "" && !slices.Contains(usernames, username) {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames
+}
+
+func validateComment(comment TaskCommentPost) (string, error) {
+	body := strings.TrimSpace(comment.Body)
+	if body == "" || utf8.RuneCountInString(body) > MaxCommentLength {
+		return "", ErrInvalidComment
+	}
+	return body, nil
+}
+
+const taskCommentColumns = "c.id, c.task_id, c.author_id, u.username, c.body, c.created_at, c.updated_at"
+
+// taskCommentJoins adds the author and the task to the comments c
+const taskCommentJoins = " JOIN \"user\" u ON u.id = c.author_id JOIN task t ON t.id = c.task_id"
+
+func scanTaskComment(row pgx.Row, comment *TaskComment, extra ...any) error {
+	return row.Scan(append([]any{
+		&comment.Id,
+		&comment.TaskId,
+		&comment.AuthorId,
+		&comment.Author,
+		&comment.Body,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+	}, extra...)...)
+}
+
+func collectTaskComments(rows pgx.Rows) ([]TaskComment, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (TaskComment, error) {
+		var comment TaskComment
+		err := scanTaskComment(row, &comment)
+		return comment, err
+	})
+}
+
+// GetTaskComments returns a page of the comments of the task from the newest, before is the id of the
+// last comment of the previous page
+func (dbService *DatabaseService) GetTaskComments(ctx context.Context, taskId uuid.UUID, userId uuid.UUID, limit int, before *uuid.UUID) (*TaskCommentPage, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var readable bool
+	err := dbService.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM task t WHERE t.id = $1 AND "+taskReadable("$2")+")", taskId, userId).Scan(&readable)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting comments")
+	}
+	if !readable {
+		return nil, errors.New("task doesn't exist")
+	}
+
+	// one more comment is read to know whether there is a next page
+	rows, err := dbService.pool.Query(
+		ctx,
+		"SELECT "+taskCommentColumns+" FROM task_comment c"+taskCommentJoins+` WHERE c.task_id = $1
+		AND ($2::uuid IS NULL OR (c.created_at, c.id) < (SELECT b.created_at, b.id FROM task_comment b WHERE b.id = $2 AND b.task_id = $1))
+		ORDER BY c.created_at DESC, c.id DESC LIMIT $3`,
+		taskId,
+		before,
+		limit+1,
+	)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting comments")
+	}
+	comments, err := collectTaskComments(rows)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+	page := TaskCommentPage{Comments: comments}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		page.Before = &comments[limit-1].Id
+	}
+	if page.Comments == nil {
+		page.Comments = []TaskComment{}
+	}
+	return &page, nil
+}
+
+// CreateTaskComment adds a comment to a task the user can read, the mentioned users who can read the task
+// are notified
+func (dbService *DatabaseService) CreateTaskComment(ctx context.Context, taskId uuid.UUID, comment TaskCommentPost, userId uuid.UUID) (*TaskComment, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	body, err := validateComment(comment)
+	if err != nil {
+		return nil, err
+	}
+	var created TaskComment
+	err = scanTaskComment(dbService.pool.QueryRow(
+		ctx,
+		`WITH created AS (
+			INSERT INTO task_comment(task_id, author_id, body)
+			SELECT t.id, $2, $3 FROM task t WHERE t.id = $1 AND `+taskReadable("$2")+`
+			RETURNING *
+		)
+		SELECT `+taskCommentColumns+` FROM created c`+taskCommentJoins,
+		taskId,
+		userId,
+		body,
+	), &created)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("task doesn't exist")
+		}
+		return nil, queryError(ctx, err, "error while creating comment")
+	}
+	dbService.notifyMentions(ctx, created, parseMentions(created.Body))
+	return &created, nil
+}
+
+// UpdateTaskComment changes the body of a comment, only its author can. Only the users mentioned for the
+// first time are notified
+func (dbService *DatabaseService) UpdateTaskComment(ctx context.Context, commentId uuid.UUID, comment TaskCommentPost, userId uuid.UUID) (*TaskComment, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	body, err := validateComment(comment)
+	if err != nil {
+		return nil, err
+	}
+	var updated TaskComment
+	var previousBody string
+	err = scanTaskComment(dbService.pool.QueryRow(
+		ctx,
+		`WITH previous AS (
+			SELECT id, body FROM task_comment WHERE id = $1 FOR UPDATE
+		), updated AS (
+			UPDATE task_comment c SET body = $3, updated_at = CURRENT_TIMESTAMP FROM previous
+			WHERE c.id = previous.id AND c.author_id = $2 AND EXISTS (SELECT 1 FROM task t WHERE t.id = c.task_id AND `+taskReadable("$2")+`)
+			RETURNING c.*
+		)
+		SELECT `+taskCommentColumns+`, previous.body FROM updated c JOIN previous ON previous.id = c.id`+taskCommentJoins,
+		commentId,
+		userId,
+		body,
+	), &updated, &previousBody)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, dbService.missedCommentError(ctx, commentId, userId)
+		}
+		return nil, queryError(ctx, err, "error while updating comment")
+	}
+	previousMentions := parseMentions(previousBody)
+	var mentions []string
+	for _, username := range parseMentions(updated.Body) {
+		if !slices.Contains(previousMentions, username) {
+			mentions = append(mentions, username)
+		}
+	}
+	dbService.notifyMentions(ctx, updated, mentions)
+	return &updated, nil
+}
+
+// DeleteTaskComment deletes a comment, only its author can
+func (dbService *DatabaseService) DeleteTaskComment(ctx context.Context, commentId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(
+		ctx,
+		"DELETE FROM task_comment c WHERE c.id = $1 AND c.author_id = $2 AND EXISTS (SELECT 1 FROM task t WHERE t.id = c.task_id AND "+taskReadable("$2")+")",
+		commentId,
+		userId,
+	)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting comment")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return dbService.missedCommentError(ctx, commentId, userId)
+	}
+	return nil
+}
+
+// missedCommentError is the error of a change of a comment that matched no row: the comment doesn't exist
+// (or its task isn't readable by the user) or the user isn't its author
+func (dbService *DatabaseService) missedCommentError(ctx context.Context, commentId uuid.UUID, userId uuid.UUID) error {
+	var readable bool
+	err := dbService.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM task_comment c"+taskCommentJoins+" WHERE c.id = $1 AND "+taskReadable("$2")+")", commentId, userId).Scan(&readable)
+	if err != nil {
+		return queryError(ctx, err, "unexpected error")
+	}
+	if readable {
+		return ErrForbidden
+	}
+	return ErrCommentNotFound
+}
+
+// notifyMentions notifies the mentioned users who can read the task of the comment, except its author
+func (dbService *DatabaseService) notifyMentions(ctx context.Context, comment TaskComment, usernames []string) {
+	if len(usernames) == 0 {
+		return
+	}
+	var taskName string
+	rows, err := dbService.pool.Query(
+		ctx,
+		"SELECT u.id, t.task_name FROM \"user\" u JOIN task t ON t.id = $1 WHERE u.username = ANY($2) AND u.id <> $3 AND "+taskReadable("u.id"),
+		comment.TaskId,
+		usernames,
+		comment.AuthorId,
+	)
+	if err == nil {
+		var userIds []uuid.UUID
+		userIds, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (uuid.UUID, error) {
+			var userId uuid.UUID
+			err := row.Scan(&userId, &taskName)
+			return userId, err
+		})
+		for _, userId := range userIds {
+			dbService.publish(userId, events.CommentMentioned, comment.Id)
+			dbService.notifier.Notify(ctx, Notification{
+				UserId:   userId,
+				Type:     NotificationMention,
+				EntityId: comment.Id,
+				TaskId:   &comment.TaskId,
+				ActorId:  &comment.AuthorId,
+				Subject:  taskName,
+			})
+		}
+	}
+	if err != nil {
+		// the comment is saved, the mentioned users only miss the notification
+		slog.WarnContext(ctx, "error while notifying mentioned users", "comment_id", comment.Id, "error", err)
+	}
+}
@@ -2,18 +2,65 @@ package db
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type DatabaseService struct {
-	pool *pgxpool.Pool
+	pool          *pgxpool.Pool
+	tokenLifetime time.Duration
+	bcryptCost    int
+	bcryptSlots   chan struct{}
+	queryTimeout  time.Duration
+
+	tombstoneRetention     time.Duration
+	idempotencyKeyLifetime time.Duration
+	publisher              events.Publisher
+
+	accountDeletionGracePeriod time.Duration
+
+	notifier           Notifier
+	emailNotifications bool
+	pushNotifications  bool
+	pushAllowHTTP      bool
+}
+
+type Options struct {
+	TokenLifetime time.Duration
+	BcryptCost    int
+	// BcryptConcurrency limits how many passwords are hashed at the same time
+	BcryptConcurrency int
+	QueryTimeout      time.Duration
+	// TombstoneRetention is how long deletions are kept for GET /sync, older sync tokens require a full sync
+	TombstoneRetention time.Duration
+	// IdempotencyKeyLifetime is how long responses of requests sent with an Idempotency-Key are replayed
+	IdempotencyKeyLifetime time.Duration
+	// Publisher is notified of every committed change of a task or history entry, it is optional
+	Publisher events.Publisher
+	// AccountDeletionGracePeriod is how long a deleted account can be restored by logging in
+	AccountDeletionGracePeriod time.Duration
+	// Notifier delivers the notifications of mentions, invitations and assignments, the DatabaseService
+	// itself when nil
+	Notifier Notifier
+	// EmailNotifications lets users choose to receive notifications by email, it requires SMTP to be configured
+	EmailNotifications bool
+	// PushNotifications sends in-app notifications to the push subscriptions of the user, it requires VAPID
+	// to be configured
+	PushNotifications bool
+	// PushAllowHTTP accepts push subscriptions with plain http endpoints, e.g. a local push service stand-in
+	PushAllowHTTP bool
 }
 
 // Maybe needed in future
@@ -53,14 +100,92 @@ var icons [33]string = [33]string{
 	"party",
 }
 
-func NewDatabaseService(dbPool *pgxpool.Pool) *DatabaseService {
-	return &DatabaseService{
-		pool: dbPool,
+func NewDatabaseService(dbPool *pgxpool.Pool, options Options) *DatabaseService {
+	dbService := &DatabaseService{
+		pool:          dbPool,
+		tokenLifetime: options.TokenLifetime,
+		bcryptCost:    options.BcryptCost,
+		bcryptSlots:   make(chan struct{}, options.BcryptConcurrency),
+		queryTimeout:  options.QueryTimeout,
+
+		tombstoneRetention:     options.TombstoneRetention,
+		idempotencyKeyLifetime: options.IdempotencyKeyLifetime,
+		publisher:              options.Publisher,
+
+		accountDeletionGracePeriod: options.AccountDeletionGracePeriod,
+
+		notifier:           options.Notifier,
+		emailNotifications: options.EmailNotifications,
+		pushNotifications:  options.PushNotifications,
+		pushAllowHTTP:      options.PushAllowHTTP,
+	}
+	if dbService.notifier == nil {
+		dbService.notifier = dbService
 	}
+	return dbService
+}
+
+// publish notifies the publisher of a committed change
+func (dbService *DatabaseService) publish(userId uuid.UUID, eventType string, id uuid.UUID) {
+	dbService.publishMessages([]events.Message{{UserId: userId, Type: eventType, Id: id}})
 }
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+// publishMessages publishes the messages of a committed change together, e.g. the fan-out to the
+// members of a list
+func (dbService *DatabaseService) publishMessages(messages []events.Message) {
+	if dbService.publisher != nil && len(messages) > 0 {
+		dbService.publisher.Publish(messages...)
+	}
+}
+
+// withTimeout bounds a single database operation, the operation is also canceled
+// when the parent context is canceled (e.g. the client disconnected)
+func (dbService *DatabaseService) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, dbService.queryTimeout)
+}
+
+// queryError replaces err with a generic error message, unless the operation was stopped
+// by its context, in which case the context error is kept so callers can tell the cases apart.
+// The original error is logged, the request id is taken from ctx by the logger
+func queryError(ctx context.Context, err error, message string) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		slog.WarnContext(ctx, message, "error", err)
+		return fmt.Errorf("%s: %w", message, err)
+	}
+	slog.ErrorContext(ctx, message, "error", err)
+	return errors.New(message)
+}
+
+// ErrConflict is returned when a serializable transaction kept failing because of concurrent requests
+var ErrConflict = errors.New("resource is being changed by another request, try again")
+
+// serializableAttempts is how many times a serializable transaction is run before giving up with ErrConflict
+const serializableAttempts = 3
+
+// txError is queryError for statements of a serializable transaction, a serialization failure
+// becomes ErrConflict so that the transaction can be retried
+func txError(ctx context.Context, err error, message string) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "40001" {
+		slog.WarnContext(ctx, message, "error", err)
+		return ErrConflict
+	}
+	return queryError(ctx, err, message)
+}
+
+// retrySerializable runs the transaction in run again when it failed with ErrConflict
+func retrySerializable(run func() error) error {
+	var err error
+	for attempt := 0; attempt < serializableAttempts; attempt++ {
+		if err = run(); !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+func HashPassword(password string, cost int) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
 	return string(bytes), err
 }
 
@@ -69,10 +194,114 @@ func MatchPassword(password, hash string) bool {
 	return err == nil
 }
 
+// newSecretToken returns a random URL-safe token for links that are their own credential
+// (calendar feeds, export downloads)
+func newSecretToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// withBcryptSlot runs fn once one of the bcrypt slots is free, so bursts of logins
+// and signups queue up instead of using every CPU at once
+func (dbService *DatabaseService) withBcryptSlot(ctx context.Context, fn func()) error {
+	bcryptWaiting.Add(1)
+	select {
+	case dbService.bcryptSlots <- struct{}{}:
+		bcryptWaiting.Add(-1)
+	case <-ctx.Done():
+		bcryptWaiting.Add(-1)
+		return ctx.Err()
+	}
+	bcryptActive.Add(1)
+	defer func() {
+		bcryptActive.Add(-1)
+		<-dbService.bcryptSlots
+	}()
+	fn()
+	return nil
+}
+
+// setPatchField adds "column = @column" to assignments when the field is present in the patch,
+// an explicit null sets the column to NULL
+func setPatchField[T any](assignments *[]string, args pgx.NamedArgs, column string, field PatchField[T]) {
+	if !field.Set {
+		return
+	}
+	if field.Null {
+		*assignments = append(*assignments, column+" = NULL")
+		return
+	}
+	*assignments = append(*assignments, column+" = @"+column)
+	args[column] = field.Value
+}
+
+// ErrPreconditionFailed is returned by a conditional update when the resource was modified
+// since the client read the expected version
+var ErrPreconditionFailed = errors.New("resource was modified by another request")
+
+// missedUpdateError is the error of a conditional update that matched no row, either the resource
+// doesn't exist or its version is no longer the expected one
+func missedUpdateError[T any](version *int64, get func() (T, error), notFound string) error {
+	if version != nil {
+		if _, err := get(); err == nil {
+			return ErrPreconditionFailed
+		}
+	}
+	return errors.New(notFound)
+}
+
 // TASK
 
-func (dbService *DatabaseService) GetTasks(userId uuid.UUID, searchName *string, searchIcons []string, searchOrderBy *string) ([]TaskDB, error) {
-	query := "SELECT t.* FROM task t WHERE t.created_by = @userId AND t.exec_status = 'ACTIVE'"
+const taskColumns = "t.id, t.task_name, t.task_icon, t.task_desc, t.deadline, t.starred, t.exec_status, t.created_at, t.created_by, t.version, t.updated_at, t.list_id, t.assigned_to"
+
+// scanTask scans the taskColumns of row into task, extra are the columns selected after them
+func scanTask(row pgx.Row, task *TaskDB, extra ...any) error {
+	return row.Scan(append([]any{
+		&task.Id,
+		&task.TaskName,
+		&task.TaskIcon,
+		&task.TaskDesc,
+		&task.Deadline,
+		&task.Starred,
+		&task.Exec_status,
+		&task.Created_at,
+		&task.Created_by,
+		&task.Version,
+		&task.UpdatedAt,
+		&task.ListId,
+		&task.AssignedTo,
+	}, extra...)...)
+}
+
+// assignmentFilterCondition adds the condition of filter to the query of tasks t, the user is @userId
+func assignmentFilterCondition(filter AssignmentFilter) string {
+	condition := ""
+	if filter.AssignedToMe {
+		condition += " AND t.assigned_to = @userId"
+	}
+	if filter.CreatedByMe {
+		condition += " AND t.created_by = @userId"
+	}
+	if filter.Unassigned {
+		condition += " AND t.assigned_to IS NULL"
+	}
+	return condition
+}
+
+func (dbService *DatabaseService) GetTasks(ctx context.Context, userId uuid.UUID, list ListFilter, assignment AssignmentFilter, searchName *string, searchIcons []string, searchOrderBy *string) ([]TaskDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	args := pgx.NamedArgs{
+		"userId":        userId,
+		"searchName":    *searchName,
+		"searchIcons":   searchIcons,
+		"searchOrderBy": *searchOrderBy,
+	}
+	query := "SELECT " + taskColumns + " FROM task t WHERE " + taskReadable("@userId") + " AND t.exec_status = 'ACTIVE'" + listFilterCondition(list, args) + assignmentFilterCondition(assignment)
 	if len(searchIcons) > 0 && searchIcons[0] != "null" {
 		query += " AND t.task_icon = ANY(@searchIcons::text[])"
 	}
@@ -86,122 +315,173 @@ func (dbService *DatabaseService) GetTasks(userId uuid.UUID, searchName *string,
 			query += " ORDER BY t.deadline ASC"
 		}
 	}
-	rows, err := dbService.pool.Query(
-		context.Background(),
-		query,
-		pgx.NamedArgs{
-			"userId":        userId,
-			"searchName":    *searchName,
-			"searchIcons":   searchIcons,
-			"searchOrderBy": *searchOrderBy,
-		},
-	)
+	rows, err := dbService.pool.Query(ctx, query, args)
 	if err != nil {
-		return nil, errors.New("error while getting tasks from database")
+		return nil, queryError(ctx, err, "error while getting tasks from database")
 	} else {
+		defer rows.Close()
 		var tasks []TaskDB
 		for rows.Next() {
 			var task TaskDB
-			err := rows.Scan(
-				&task.Id,
-				&task.TaskName,
-				&task.TaskIcon,
-				&task.TaskDesc,
-				&task.Deadline,
-				&task.Starred,
-				&task.Exec_status,
-				&task.Created_at,
-				&task.Created_by,
-			)
+			err := scanTask(rows, &task)
 			if err != nil {
-				return nil, errors.New("error while iterating dataset")
+				return nil, queryError(ctx, err, "error while iterating dataset")
 			}
 			tasks = append(tasks, task)
 		}
+		if rows.Err() != nil {
+			return nil, queryError(ctx, rows.Err(), "error while iterating dataset")
+		}
 		return tasks, nil
 	}
 }
 
-func (dbService *DatabaseService) GetTask(taskId uuid.UUID, userId uuid.UUID) (*TaskDB, error) {
+func (dbService *DatabaseService) GetTask(ctx context.Context, taskId uuid.UUID, userId uuid.UUID) (*TaskDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
 	var task TaskDB
-	err := dbService.pool.QueryRow(context.Background(), "SELECT t.* FROM task t WHERE t.id = $1 AND t.created_by = $2", taskId, userId).Scan(
-		&task.Id,
-		&task.TaskName,
-		&task.TaskIcon,
-		&task.TaskDesc,
-		&task.Deadline,
-		&task.Starred,
-		&task.Exec_status,
-		&task.Created_at,
-		&task.Created_by,
-	)
+	err := scanTask(dbService.pool.QueryRow(ctx, "SELECT "+taskColumns+" FROM task t WHERE t.id = $1 AND "+taskReadable("$2"), taskId, userId), &task)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("task doesn't exist")
 		}
-		return nil, errors.New("unexpected error")
+		return nil, queryError(ctx, err, "unexpected error")
 	}
 	return &task, nil
 }
 
-func (dbService *DatabaseService) CreateTask(task TaskPost) (*uuid.UUID, error) {
+func (dbService *DatabaseService) CreateTask(ctx context.Context, task TaskPost) (*uuid.UUID, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	// a task is only added to a list the user can edit and assigned to a member of the list
 	var taskId uuid.UUID
 	err := dbService.pool.QueryRow(
-		context.Background(),
-		"INSERT INTO task(task_name, task_icon, task_desc, deadline, starred, exec_status, created_by) VALUES ($1, $2, $3, $4, $5, 'ACTIVE', $6) RETURNING id",
+		ctx,
+		`INSERT INTO task(task_name, task_icon, task_desc, deadline, starred, exec_status, created_by, list_id, assigned_to)
+		SELECT $1, $2, $3, $4, $5, 'ACTIVE', $6, $7, $8
+		WHERE ($7::uuid IS NULL OR EXISTS (SELECT 1 FROM task_list_member tlm WHERE tlm.list_id = $7 AND tlm.user_id = $6 AND tlm.role <> 'viewer'))
+		AND ($8::uuid IS NULL OR ($7::uuid IS NULL AND $8 = $6) OR EXISTS (SELECT 1 FROM task_list_member tlm WHERE tlm.list_id = $7 AND tlm.user_id = $8))
+		RETURNING id`,
 		task.TaskName,
 		task.TaskIcon,
 		task.TaskDesc,
 		task.Deadline,
 		task.Starred,
 		task.CreatedBy,
+		task.ListId,
+		task.AssignedTo,
 	).Scan(&taskId)
 	if err != nil {
-		return nil, errors.New("error while creating task")
+		if errors.Is(err, pgx.ErrNoRows) {
+			if task.ListId == nil {
+				return nil, ErrInvalidAssignee
+			}
+			list, err := dbService.GetList(ctx, *task.ListId, task.CreatedBy)
+			if err != nil {
+				return nil, err
+			}
+			if list.Role == RoleViewer {
+				return nil, ErrForbidden
+			}
+			return nil, ErrInvalidAssignee
+		}
+		return nil, queryError(ctx, err, "error while creating task")
+	}
+	tasksCreated.Inc()
+	dbService.publishChanges(ctx, task.CreatedBy, change{events.TaskCreated, taskId, task.ListId})
+	if task.AssignedTo != nil && *task.AssignedTo != task.CreatedBy {
+		dbService.publish(*task.AssignedTo, events.TaskAssigned, taskId)
+		dbService.notifier.Notify(ctx, Notification{
+			UserId:   *task.AssignedTo,
+			Type:     NotificationAssignment,
+			EntityId: taskId,
+			TaskId:   &taskId,
+			ActorId:  &task.CreatedBy,
+			Subject:  task.TaskName,
+		})
 	}
 	return &taskId, nil
 }
 
-func (dbService *DatabaseService) CompleteTask(taskId uuid.UUID, userId uuid.UUID) (bool, error) {
-	tx, err := dbService.pool.BeginTx(context.Background(), pgx.TxOptions{IsoLevel: pgx.Serializable})
+func (dbService *DatabaseService) CompleteTask(ctx context.Context, taskId uuid.UUID, userId uuid.UUID) (bool, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var listId *uuid.UUID
+	var taskHistoryId uuid.UUID
+	err := retrySerializable(func() (err error) {
+		listId, taskHistoryId, err = dbService.completeTask(ctx, taskId, userId)
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
-	defer tx.Rollback(context.Background())
 
-	var owner uuid.UUID
-	var execStatus string
-	err = tx.QueryRow(context.Background(), "SELECT created_by, exec_status FROM task WHERE id = $1", taskId).Scan(&owner, &execStatus)
+	tasksCompleted.Inc()
+	dbService.publishChanges(ctx, userId, change{events.TaskUpdated, taskId, listId}, change{events.TaskHistoryCreated, taskHistoryId, listId})
+	return true, nil
+}
+
+func (dbService *DatabaseService) completeTask(ctx context.Context, taskId uuid.UUID, userId uuid.UUID) (*uuid.UUID, uuid.UUID, error) {
+	tx, err := dbService.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
 	if err != nil {
-		return false, err
+		return nil, uuid.Nil, queryError(ctx, err, "error while completing task")
 	}
+	defer tx.Rollback(ctx)
 
-	if (owner != userId) || execStatus != "ACTIVE" {
-		return false, errors.New("invalid request")
+	var listId, assignedTo *uuid.UUID
+	var execStatus, role string
+	err = tx.QueryRow(
+		ctx,
+		"SELECT t.list_id, t.assigned_to, t.exec_status, COALESCE(tlm.role, 'owner') FROM task t LEFT JOIN task_list_member tlm ON tlm.list_id = t.list_id AND tlm.user_id = $2 WHERE t.id = $1 AND "+taskReadable("$2"),
+		taskId,
+		userId,
+	).Scan(&listId, &assignedTo, &execStatus, &role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, uuid.Nil, errors.New("invalid request")
+		}
+		return nil, uuid.Nil, txError(ctx, err, "error while completing task")
 	}
 
-	_, err = tx.Exec(context.Background(), "UPDATE task SET exec_status = 'INACTIVE' WHERE id = $1", taskId)
-	if err != nil {
-		return false, err
+	// the assignee can complete the task even as a viewer
+	if role == RoleViewer && (assignedTo == nil || *assignedTo != userId) {
+		return nil, uuid.Nil, ErrForbidden
+	}
+	if execStatus != "ACTIVE" {
+		return nil, uuid.Nil, errors.New("invalid request")
 	}
 
-	_, err = tx.Exec(context.Background(), "INSERT INTO task_history(task_id) VALUES ($1)", taskId)
+	_, err = tx.Exec(ctx, "UPDATE task SET exec_status = 'INACTIVE' WHERE id = $1", taskId)
 	if err != nil {
-		return false, err
+		return nil, uuid.Nil, txError(ctx, err, "error while completing task")
 	}
 
-	err = tx.Commit(context.Background())
+	var taskHistoryId uuid.UUID
+	err = tx.QueryRow(ctx, "INSERT INTO task_history(task_id, completed_by) VALUES ($1, $2) RETURNING id", taskId, userId).Scan(&taskHistoryId)
 	if err != nil {
-		return false, err
+		return nil, uuid.Nil, txError(ctx, err, "error while completing task")
 	}
 
-	return true, nil
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, uuid.Nil, txError(ctx, err, "error while completing task")
+	}
+	return listId, taskHistoryId, nil
 }
 
-func (dbService *DatabaseService) UpdateTask(taskId uuid.UUID, task TaskPut, userId uuid.UUID) error {
-	cmdTag, err := dbService.pool.Exec(
-		context.Background(),
-		"UPDATE task SET task_name = $1, task_icon = $2, task_desc = $3, starred = $4, deadline = $5 WHERE id = $6 AND created_by = $7",
+// UpdateTask overwrites the task and returns its new version, a nil version updates it unconditionally
+func (dbService *DatabaseService) UpdateTask(ctx context.Context, taskId uuid.UUID, task TaskPut, userId uuid.UUID, version *int64) (int64, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var newVersion int64
+	var listId *uuid.UUID
+	err := dbService.pool.QueryRow(
+		ctx,
+		"UPDATE task t SET task_name = $1, task_icon = $2, task_desc = $3, starred = $4, deadline = $5 WHERE t.id = $6 AND "+taskEditable("$7")+" AND ($8::bigint IS NULL OR t.version = $8) RETURNING t.version, t.list_id",
 		task.TaskName,
 		task.TaskIcon,
 		task.TaskDesc,
@@ -209,51 +489,134 @@ func (dbService *DatabaseService) UpdateTask(taskId uuid.UUID, task TaskPut, use
 		task.Deadline,
 		taskId,
 		userId,
-	)
+		version,
+	).Scan(&newVersion, &listId)
 	if err != nil {
-		return err
-	}
-	if cmdTag.RowsAffected() == 0 {
-		return errors.New("task doesn't exist")
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, dbService.missedTaskUpdateError(ctx, taskId, userId, version, "task doesn't exist")
+		}
+		return 0, queryError(ctx, err, "error while updating task")
 	}
-	return nil
+	dbService.publishChanges(ctx, userId, change{events.TaskUpdated, taskId, listId})
+	return newVersion, nil
 }
 
-func (dbService *DatabaseService) DeleteTask(taskId uuid.UUID, userId uuid.UUID) error {
-	cmdTag, err := dbService.pool.Exec(context.Background(), "DELETE FROM task t WHERE t.id = $1 AND t.created_by = $2", taskId, userId)
+// PatchTask updates only the fields present in the patch and returns the updated task,
+// a nil version updates it unconditionally
+func (dbService *DatabaseService) PatchTask(ctx context.Context, taskId uuid.UUID, patch TaskPatch, userId uuid.UUID, version *int64) (*TaskDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var assignments []string
+	args := pgx.NamedArgs{"taskId": taskId, "userId": userId, "version": version}
+	setPatchField(&assignments, args, "task_name", patch.TaskName)
+	setPatchField(&assignments, args, "task_icon", patch.TaskIcon)
+	setPatchField(&assignments, args, "task_desc", patch.TaskDesc)
+	setPatchField(&assignments, args, "deadline", patch.Deadline)
+	setPatchField(&assignments, args, "starred", patch.Starred)
+	setPatchField(&assignments, args, "assigned_to", patch.AssignedTo)
+	if len(assignments) == 0 {
+		task, err := dbService.GetTask(ctx, taskId, userId)
+		if err == nil && version != nil && task.Version != *version {
+			return nil, ErrPreconditionFailed
+		}
+		return task, err
+	}
+
+	// the previous assignee is read from the locked row, so the new assignee is only notified when it changed.
+	// Only a task the user can edit is locked. The assignee has to be able to read the task
+	var task TaskDB
+	var previousAssignee *uuid.UUID
+	query := "UPDATE task t SET " + strings.Join(assignments, ", ") +
+		" FROM (SELECT t.id, t.assigned_to FROM task t WHERE t.id = @taskId AND " + taskEditable("@userId") + " FOR UPDATE OF t) previous" +
+		" WHERE t.id = previous.id AND " + taskEditable("@userId") + " AND (@version::bigint IS NULL OR t.version = @version)"
+	if patch.AssignedTo.Set && !patch.AssignedTo.Null {
+		query += " AND " + taskReadable("@assigned_to")
+	}
+	query += " RETURNING " + taskColumns + ", previous.assigned_to"
+	err := scanTask(dbService.pool.QueryRow(ctx, query, args), &task, &previousAssignee)
 	if err != nil {
-		return err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, dbService.missedTaskAssignmentError(ctx, taskId, userId, patch.AssignedTo, version)
+		}
+		return nil, queryError(ctx, err, "error while updating task")
+	}
+	dbService.publishChanges(ctx, userId, change{events.TaskUpdated, taskId, task.ListId})
+	if task.AssignedTo != nil && *task.AssignedTo != userId && (previousAssignee == nil || *previousAssignee != *task.AssignedTo) {
+		dbService.publish(*task.AssignedTo, events.TaskAssigned, taskId)
+		dbService.notifier.Notify(ctx, Notification{
+			UserId:   *task.AssignedTo,
+			Type:     NotificationAssignment,
+			EntityId: taskId,
+			TaskId:   &taskId,
+			ActorId:  &userId,
+			Subject:  task.TaskName,
+		})
 	}
-	if cmdTag.RowsAffected() == 0 {
-		return errors.New("task doesn't exist")
+	return &task, nil
+}
+
+func (dbService *DatabaseService) DeleteTask(ctx context.Context, taskId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var listId *uuid.UUID
+	err := dbService.pool.QueryRow(ctx, "DELETE FROM task t WHERE t.id = $1 AND "+taskEditable("$2")+" RETURNING t.list_id", taskId, userId).Scan(&listId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dbService.missedTaskUpdateError(ctx, taskId, userId, nil, "task doesn't exist")
+		}
+		return queryError(ctx, err, "error while deleting task")
 	}
+	dbService.publishChanges(ctx, userId, change{events.TaskDeleted, taskId, listId})
 	return nil
 }
 
 // TASK HISTORY
 
-func (dbService *DatabaseService) GetTaskHistory(taskHistoryId uuid.UUID, userId uuid.UUID) (*TaskHistoryDB, error) {
+const taskHistoryColumns = "th.id, th.exec_rating, th.exec_comment, th.task_id, t.task_name, t.task_icon, th.version, th.updated_at, t.list_id, th.completed_by"
+
+func scanTaskHistory(row pgx.Row, taskHistory *TaskHistoryDB) error {
+	return row.Scan(
+		&taskHistory.Id,
+		&taskHistory.ExecRating,
+		&taskHistory.ExecComment,
+		&taskHistory.TaskId,
+		&taskHistory.TaskName,
+		&taskHistory.TaskIcon,
+		&taskHistory.Version,
+		&taskHistory.UpdatedAt,
+		&taskHistory.ListId,
+		&taskHistory.CompletedBy,
+	)
+}
+
+func (dbService *DatabaseService) GetTaskHistory(ctx context.Context, taskHistoryId uuid.UUID, userId uuid.UUID) (*TaskHistoryDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
 	var taskHistory TaskHistoryDB
-	err := dbService.pool.QueryRow(context.Background(), "SELECT th.*, t.task_name, t.task_icon FROM task_history th JOIN task t ON th.task_id = t.id WHERE t.created_by = $1 AND th.id = $2", userId, taskHistoryId).
-		Scan(
-			&taskHistory.Id,
-			&taskHistory.ExecRating,
-			&taskHistory.ExecComment,
-			&taskHistory.TaskId,
-			&taskHistory.TaskName,
-			&taskHistory.TaskIcon,
-		)
+	err := scanTaskHistory(dbService.pool.QueryRow(ctx, "SELECT "+taskHistoryColumns+" FROM task_history th JOIN task t ON th.task_id = t.id WHERE "+taskReadable("$1")+" AND th.id = $2", userId, taskHistoryId), &taskHistory)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("task history doesn't exist")
 		}
-		return nil, errors.New("unexpected error")
+		return nil, queryError(ctx, err, "unexpected error")
 	}
 	return &taskHistory, nil
 }
 
-func (dbService *DatabaseService) GetTasksHistory(userId uuid.UUID, searchName *string, searchIcons []string, searchRating int) ([]TaskHistoryDB, error) {
-	query := "SELECT th.*, t.task_name, t.task_icon FROM task_history th JOIN task t ON th.task_id = t.id WHERE t.created_by = @userId"
+func (dbService *DatabaseService) GetTasksHistory(ctx context.Context, userId uuid.UUID, list ListFilter, searchName *string, searchIcons []string, searchRating int) ([]TaskHistoryDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	args := pgx.NamedArgs{
+		"userId":       userId,
+		"searchName":   *searchName,
+		"searchIcons":  searchIcons,
+		"searchRating": searchRating,
+	}
+	query := "SELECT " + taskHistoryColumns + " FROM task_history th JOIN task t ON th.task_id = t.id WHERE " + taskReadable("@userId") + listFilterCondition(list, args)
 	if len(searchIcons) > 0 && searchIcons[0] != "null" {
 		query += " AND t.task_icon = ANY(@searchIcons::text[])"
 	}
@@ -263,110 +626,159 @@ func (dbService *DatabaseService) GetTasksHistory(userId uuid.UUID, searchName *
 	if searchRating >= 1 && searchRating <= 3 {
 		query += " AND th.exec_rating = @searchRating::int"
 	}
-	rows, err := dbService.pool.Query(
-		context.Background(),
-		query,
-		pgx.NamedArgs{
-			"userId":       userId,
-			"searchName":   *searchName,
-			"searchIcons":  searchIcons,
-			"searchRating": searchRating,
-		},
-	)
+	rows, err := dbService.pool.Query(ctx, query, args)
 	if err != nil {
-		return nil, errors.New("error while getting tasks history from database")
+		return nil, queryError(ctx, err, "error while getting tasks history from database")
 	} else {
+		defer rows.Close()
 		var tasksHistory []TaskHistoryDB
 		for rows.Next() {
 			var taskHistory TaskHistoryDB
-			err := rows.Scan(
-				&taskHistory.Id,
-				&taskHistory.ExecRating,
-				&taskHistory.ExecComment,
-				&taskHistory.TaskId,
-				&taskHistory.TaskName,
-				&taskHistory.TaskIcon,
-			)
+			err := scanTaskHistory(rows, &taskHistory)
 			if err != nil {
-				return nil, errors.New("error while iterating dataset")
+				return nil, queryError(ctx, err, "error while iterating dataset")
 			}
 			tasksHistory = append(tasksHistory, taskHistory)
 		}
+		if rows.Err() != nil {
+			return nil, queryError(ctx, rows.Err(), "error while iterating dataset")
+		}
 		return tasksHistory, nil
 	}
 }
 
-func (dbService *DatabaseService) UpdateTaskHistory(taskHistoryId uuid.UUID, taskHistory TaskHistoryPut, userId uuid.UUID) error {
-	cmdTag, err := dbService.pool.Exec(
-		context.Background(),
-		"UPDATE task_history SET exec_comment = $1, exec_rating = $2 WHERE id = $3 AND EXISTS (SELECT 1 FROM task t WHERE t.created_by = $4 AND t.id = task_id)",
+// UpdateTaskHistory overwrites the history entry and returns its new version, a nil version updates it unconditionally
+func (dbService *DatabaseService) UpdateTaskHistory(ctx context.Context, taskHistoryId uuid.UUID, taskHistory TaskHistoryPut, userId uuid.UUID, version *int64) (int64, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var newVersion int64
+	var listId *uuid.UUID
+	err := dbService.pool.QueryRow(
+		ctx,
+		"UPDATE task_history th SET exec_comment = $1, exec_rating = $2 FROM task t WHERE th.id = $3 AND t.id = th.task_id AND "+taskEditable("$4")+" AND ($5::bigint IS NULL OR th.version = $5) RETURNING th.version, t.list_id",
 		taskHistory.ExecComment,
 		taskHistory.ExecRating,
 		taskHistoryId,
 		userId,
-	)
+		version,
+	).Scan(&newVersion, &listId)
 	if err != nil {
-		return err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, dbService.missedTaskHistoryUpdateError(ctx, taskHistoryId, userId, version, "error while updating")
+		}
+		return 0, queryError(ctx, err, "error while updating task history")
 	}
-	if cmdTag.RowsAffected() == 0 {
-		return errors.New("error while updating")
+	dbService.publishChanges(ctx, userId, change{events.TaskHistoryUpdated, taskHistoryId, listId})
+	return newVersion, nil
+}
+
+// PatchTaskHistory updates only the fields present in the patch and returns the updated history entry,
+// a nil version updates it unconditionally
+func (dbService *DatabaseService) PatchTaskHistory(ctx context.Context, taskHistoryId uuid.UUID, patch TaskHistoryPatch, userId uuid.UUID, version *int64) (*TaskHistoryDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var assignments []string
+	args := pgx.NamedArgs{"taskHistoryId": taskHistoryId, "userId": userId, "version": version}
+	setPatchField(&assignments, args, "exec_rating", patch.ExecRating)
+	setPatchField(&assignments, args, "exec_comment", patch.ExecComment)
+	if len(assignments) == 0 {
+		taskHistory, err := dbService.GetTaskHistory(ctx, taskHistoryId, userId)
+		if err == nil && version != nil && taskHistory.Version != *version {
+			return nil, ErrPreconditionFailed
+		}
+		return taskHistory, err
 	}
-	return nil
+
+	var taskHistory TaskHistoryDB
+	query := "WITH th AS (UPDATE task_history SET " + strings.Join(assignments, ", ") + " WHERE id = @taskHistoryId AND EXISTS (SELECT 1 FROM task t WHERE t.id = task_id AND " + taskEditable("@userId") + ") AND (@version::bigint IS NULL OR version = @version) RETURNING *) " +
+		"SELECT " + taskHistoryColumns + " FROM th JOIN task t ON th.task_id = t.id"
+	err := scanTaskHistory(dbService.pool.QueryRow(ctx, query, args), &taskHistory)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, dbService.missedTaskHistoryUpdateError(ctx, taskHistoryId, userId, version, "task history doesn't exist")
+		}
+		return nil, queryError(ctx, err, "error while updating task history")
+	}
+	dbService.publishChanges(ctx, userId, change{events.TaskHistoryUpdated, taskHistoryId, taskHistory.ListId})
+	return &taskHistory, nil
 }
 
-func (dbService *DatabaseService) DeleteTaskAndHistory(taskHistoryId uuid.UUID, userId uuid.UUID) error {
-	tx, err := dbService.pool.BeginTx(context.Background(), pgx.TxOptions{IsoLevel: pgx.Serializable})
+func (dbService *DatabaseService) DeleteTaskAndHistory(ctx context.Context, taskHistoryId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var taskId uuid.UUID
+	var listId *uuid.UUID
+	err := retrySerializable(func() (err error) {
+		taskId, listId, err = dbService.deleteTaskAndHistory(ctx, taskHistoryId, userId)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback(context.Background())
+
+	dbService.publishChanges(ctx, userId, change{events.TaskHistoryDeleted, taskHistoryId, listId}, change{events.TaskDeleted, taskId, listId})
+	return nil
+}
+
+func (dbService *DatabaseService) deleteTaskAndHistory(ctx context.Context, taskHistoryId uuid.UUID, userId uuid.UUID) (uuid.UUID, *uuid.UUID, error) {
+	tx, err := dbService.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return uuid.Nil, nil, queryError(ctx, err, "error while deleting task")
+	}
+	defer tx.Rollback(ctx)
 
 	var taskId uuid.UUID
-	err = tx.QueryRow(context.Background(), "SELECT t.id FROM task t JOIN task_history th ON t.id = th.task_id WHERE th.id = $1 AND t.created_by = $2", taskHistoryId, userId).Scan(&taskId)
+	var listId *uuid.UUID
+	err = tx.QueryRow(ctx, "SELECT t.id, t.list_id FROM task t JOIN task_history th ON t.id = th.task_id WHERE th.id = $1 AND "+taskEditable("$2"), taskHistoryId, userId).Scan(&taskId, &listId)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return errors.New("task doesn't exist")
+			return uuid.Nil, nil, dbService.missedTaskHistoryUpdateError(ctx, taskHistoryId, userId, nil, "task doesn't exist")
 		}
-		return errors.New("unexpected error")
+		return uuid.Nil, nil, txError(ctx, err, "unexpected error")
 	}
 
 	cmdTag, err := tx.Exec(
-		context.Background(),
+		ctx,
 		"DELETE FROM task_history WHERE id = $1",
 		taskHistoryId,
 	)
 	if err != nil {
-		return err
+		return uuid.Nil, nil, txError(ctx, err, "error while deleting task history")
 	}
 	if cmdTag.RowsAffected() == 0 {
-		return errors.New("error while deleting task history")
+		return uuid.Nil, nil, errors.New("error while deleting task history")
 	}
 
 	cmdTag, err = tx.Exec(
-		context.Background(),
+		ctx,
 		"DELETE FROM task WHERE id = $1",
 		taskId,
 	)
 	if err != nil {
-		return err
+		return uuid.Nil, nil, txError(ctx, err, "error while deleting task")
 	}
 	if cmdTag.RowsAffected() == 0 {
-		return errors.New("error while deleting task")
+		return uuid.Nil, nil, errors.New("error while deleting task")
 	}
 
-	err = tx.Commit(context.Background())
+	err = tx.Commit(ctx)
 	if err != nil {
-		return err
+		return uuid.Nil, nil, txError(ctx, err, "error while deleting task")
 	}
-
-	return nil
+	return taskId, listId, nil
 }
 
 // AUTH
 
-func (dbService *DatabaseService) GetLoggedInUser(tokenId uuid.UUID) (*uuid.UUID, error) {
+func (dbService *DatabaseService) GetLoggedInUser(ctx context.Context, tokenId uuid.UUID) (*uuid.UUID, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
 	var userId uuid.UUID
-	err := dbService.pool.QueryRow(context.Background(), "SELECT u.id FROM \"user\" u JOIN user_auth ua ON u.id = ua.user_id WHERE ua.id = $1 AND ua.expires_at > CURRENT_TIMESTAMP", tokenId).Scan(&userId)
+	err := dbService.pool.QueryRow(ctx, "SELECT u.id FROM \"user\" u JOIN user_auth ua ON u.id = ua.user_id WHERE ua.id = $1 AND ua.expires_at > CURRENT_TIMESTAMP", tokenId).Scan(&userId)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("invalid token")
@@ -376,112 +788,220 @@ func (dbService *DatabaseService) GetLoggedInUser(tokenId uuid.UUID) (*uuid.UUID
 	return &userId, nil
 }
 
-func (dbService *DatabaseService) CreateToken(credentials Credentials) (*AuthDB, error) {
-	tx, err := dbService.pool.BeginTx(context.Background(), pgx.TxOptions{IsoLevel: pgx.Serializable})
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback(context.Background())
+func (dbService *DatabaseService) CreateToken(ctx context.Context, credentials Credentials) (*AuthDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
 
+	// the password is checked before the transaction, so logins waiting for a bcrypt slot don't hold connections
 	var userId uuid.UUID
 	var passwordHash string
-	err = tx.QueryRow(context.Background(), "SELECT u.id, u.password FROM \"user\" u WHERE u.username = $1", credentials.Username).Scan(&userId, &passwordHash)
+	err := dbService.pool.QueryRow(ctx, "SELECT u.id, u.password FROM \"user\" u WHERE u.username = $1", credentials.Username).Scan(&userId, &passwordHash)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("user doesn't exist")
 		}
-		return nil, errors.New("unexpected error")
+		return nil, queryError(ctx, err, "unexpected error")
 	}
 
-	passwordCheck := MatchPassword(credentials.Password, passwordHash)
+	var passwordCheck bool
+	err = dbService.withBcryptSlot(ctx, func() {
+		passwordCheck = MatchPassword(credentials.Password, passwordHash)
+	})
+	if err != nil {
+		return nil, err
+	}
 	if !passwordCheck {
 		return nil, errors.New("invalid credentials")
 	}
 
+	tx, err := dbService.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// the password may have changed while it was checked
+	var unchanged bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM \"user\" u WHERE u.id = $1 AND u.password = $2)", userId, passwordHash).Scan(&unchanged)
+	if err != nil {
+		return nil, queryError(ctx, err, "unexpected error")
+	}
+	if !unchanged {
+		return nil, errors.New("invalid credentials")
+	}
+
+	// logging in during the grace period of an account deletion cancels it
+	cmdTag, err := tx.Exec(ctx, "UPDATE \"user\" SET deletion_requested_at = NULL, delete_after = NULL, deletion_attempts = 0, deletion_failed_at = NULL WHERE id = $1 AND delete_after IS NOT NULL", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "unexpected error")
+	}
+
 	var authRow AuthDB
-	err = tx.QueryRow(context.Background(), "INSERT INTO user_auth(user_id, expires_at) VALUES ($1, $2) RETURNING *", userId, time.Now().Add(7*24*time.Hour)).Scan(&authRow.Id, &authRow.UserId, &authRow.ExpiresAt)
+	authRow.DeletionCancelled = cmdTag.RowsAffected() > 0
+	err = tx.QueryRow(ctx, "INSERT INTO user_auth(user_id, expires_at) VALUES ($1, $2) RETURNING *", userId, time.Now().Add(dbService.tokenLifetime)).Scan(&authRow.Id, &authRow.UserId, &authRow.ExpiresAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("error creating the token")
 		}
-		return nil, errors.New("unexpected error")
+		return nil, queryError(ctx, err, "unexpected error")
 	}
 
-	err = tx.Commit(context.Background())
+	err = tx.Commit(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if authRow.DeletionCancelled {
+		slog.InfoContext(ctx, "account deletion cancelled by login", "user_id", userId)
+	}
 
 	return &authRow, nil
 }
 
-func (dbService *DatabaseService) InvalidateToken(tokenId uuid.UUID) {
-	cmdTag, err := dbService.pool.Exec(context.Background(), "DELETE FROM user_auth ua WHERE ua.id = $1", tokenId)
+func (dbService *DatabaseService) InvalidateToken(ctx context.Context, tokenId uuid.UUID) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var userId uuid.UUID
+	err := dbService.pool.QueryRow(ctx, "DELETE FROM user_auth ua WHERE ua.id = $1 RETURNING ua.user_id", tokenId).Scan(&userId)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			slog.ErrorContext(ctx, "error while deleting token", "error", err)
+		}
+		return
+	}
+	slog.DebugContext(ctx, "token invalidated")
+	dbService.publish(userId, events.SessionInvalidated, tokenId)
+}
+
+func (dbService *DatabaseService) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(ctx, "DELETE FROM user_auth ua WHERE ua.expires_at <= CURRENT_TIMESTAMP")
 	if err != nil {
-		log.Printf("Error while deleting tag")
+		return 0, err
 	}
-	log.Printf("%v", cmdTag)
+	return cmdTag.RowsAffected(), nil
 }
 
 // USER
 
-func (dbService *DatabaseService) GetUserInfo(userId uuid.UUID) (*UserGet, error) {
+func (dbService *DatabaseService) GetUserInfo(ctx context.Context, userId uuid.UUID) (*UserGet, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
 	var user UserGet
-	err := dbService.pool.QueryRow(context.Background(), "SELECT u.username, u.email, u.created_at FROM \"user\" u WHERE u.id = $1", userId).Scan(
+	err := dbService.pool.QueryRow(ctx, "SELECT u.username, u.email, u.created_at, u.version FROM \"user\" u WHERE u.id = $1", userId).Scan(
 		&user.Username,
 		&user.Email,
 		&user.CreatedAt,
+		&user.Version,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, errors.New("user doesn't exist")
 		}
-		return nil, errors.New("unexpected error")
+		return nil, queryError(ctx, err, "unexpected error")
 	}
 	return &user, nil
 }
 
-func (dbService *DatabaseService) UpdateUser(user UserPut, userId uuid.UUID) error {
-	cmdTag, err := dbService.pool.Exec(
-		context.Background(),
-		"UPDATE \"user\" SET username = $1, email = $2 WHERE id = $3",
+// UpdateUser overwrites the profile and returns its new version, a nil version updates it unconditionally
+func (dbService *DatabaseService) UpdateUser(ctx context.Context, user UserPut, userId uuid.UUID, version *int64) (int64, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var newVersion int64
+	err := dbService.pool.QueryRow(
+		ctx,
+		"UPDATE \"user\" SET username = $1, email = $2 WHERE id = $3 AND ($4::bigint IS NULL OR version = $4) RETURNING version",
 		user.Username,
 		user.Email,
 		userId,
-	)
+		version,
+	).Scan(&newVersion)
 	if err != nil {
-		return err
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, missedUpdateError(version, func() (*UserGet, error) { return dbService.GetUserInfo(ctx, userId) }, "error while updating user")
+		}
+		return 0, err
 	}
-	if cmdTag.RowsAffected() == 0 {
-		return errors.New("error while updating user")
+	return newVersion, nil
+}
+
+// PatchUser updates only the fields present in the patch and returns the updated profile,
+// a nil version updates it unconditionally
+func (dbService *DatabaseService) PatchUser(ctx context.Context, patch UserPatch, userId uuid.UUID, version *int64) (*UserGet, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var assignments []string
+	args := pgx.NamedArgs{"userId": userId, "version": version}
+	setPatchField(&assignments, args, "username", patch.Username)
+	setPatchField(&assignments, args, "email", patch.Email)
+	if len(assignments) == 0 {
+		user, err := dbService.GetUserInfo(ctx, userId)
+		if err == nil && version != nil && user.Version != *version {
+			return nil, ErrPreconditionFailed
+		}
+		return user, err
 	}
-	return nil
+
+	var user UserGet
+	query := "UPDATE \"user\" SET " + strings.Join(assignments, ", ") + " WHERE id = @userId AND (@version::bigint IS NULL OR version = @version) RETURNING username, email, created_at, version"
+	err := dbService.pool.QueryRow(ctx, query, args).Scan(
+		&user.Username,
+		&user.Email,
+		&user.CreatedAt,
+		&user.Version,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, missedUpdateError(version, func() (*UserGet, error) { return dbService.GetUserInfo(ctx, userId) }, "user doesn't exist")
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, errors.New("username or email taken")
+		}
+		return nil, queryError(ctx, err, "error while updating user")
+	}
+	return &user, nil
 }
 
-func (dbService *DatabaseService) CreateUser(user UserPost) (*uuid.UUID, error) {
-	tx, err := dbService.pool.BeginTx(context.Background(), pgx.TxOptions{IsoLevel: pgx.Serializable})
+func (dbService *DatabaseService) CreateUser(ctx context.Context, user UserPost) (*uuid.UUID, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	// the password is hashed before the transaction, so signups waiting for a bcrypt slot don't hold connections
+	var hashErr error
+	err := dbService.withBcryptSlot(ctx, func() {
+		user.Password, hashErr = HashPassword(user.Password, dbService.bcryptCost)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hashErr != nil {
+		return nil, errors.New("error hashing password")
+	}
+
+	tx, err := dbService.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback(context.Background())
+	defer tx.Rollback(ctx)
 
 	var cnt int
-	err = tx.QueryRow(context.Background(), "SELECT COUNT(*) FROM \"user\" u WHERE u.username = $1", user.Username).Scan(&cnt)
+	err = tx.QueryRow(ctx, "SELECT COUNT(*) FROM \"user\" u WHERE u.username = $1", user.Username).Scan(&cnt)
 	if err != nil {
-		return nil, errors.New("unexpected error")
+		return nil, queryError(ctx, err, "unexpected error")
 	}
 	if cnt > 0 {
 		return nil, errors.New("username taken")
 	}
 
-	user.Password, err = HashPassword(user.Password)
-	if err != nil {
-		return nil, errors.New("error hashing password")
-	}
-
 	var userId uuid.UUID
 	err = tx.QueryRow(
-		context.Background(),
+		ctx,
 		"INSERT INTO \"user\"(username, email, password) VALUES ($1, $2, $3) RETURNING id",
 		user.Username,
 		user.Email,
@@ -491,7 +1011,7 @@ func (dbService *DatabaseService) CreateUser(user UserPost) (*uuid.UUID, error)
 		return nil, errors.New("error creating user")
 	}
 
-	err = tx.Commit(context.Background())
+	err = tx.Commit(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,313 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrExportNotFound = errors.New("export doesn't exist")
+var ErrExportExpired = errors.New("export has expired, request a new one")
+
+// exportStaleAfter is how long an export can be running before it is considered lost
+// (e.g. the instance generating it was killed) and is claimed again
+const exportStaleAfter = 10 * time.Minute
+
+// exportedTask matches the tasks of an export: the user's own tasks, every task of the lists they are
+// a member of and the tasks they created, are assigned or completed in lists they have left
+func exportedTask(user string) string {
+	return "(" + taskReadable(user) + " OR t.created_by = " + user + " OR t.assigned_to = " + user +
+		" OR EXISTS (SELECT 1 FROM task_history completed WHERE completed.task_id = t.id AND completed.completed_by = " + user + "))"
+}
+
+const exportColumns = "ae.id, ae.user_id, ae.status, ae.token, ae.size, ae.error, ae.created_at, ae.completed_at, ae.expires_at"
+
+func scanExport(row pgx.Row, export *ExportDB) error {
+	return row.Scan(
+		&export.Id,
+		&export.UserId,
+		&export.Status,
+		&export.Token,
+		&export.Size,
+		&export.Error,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	)
+}
+
+// CreateExport queues an export of the user's account, when one is already waiting or being
+// generated that one is returned instead
+func (dbService *DatabaseService) CreateExport(ctx context.Context, userId uuid.UUID) (*ExportDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var export ExportDB
+	err := scanExport(dbService.pool.QueryRow(
+		ctx,
+		`INSERT INTO account_export AS ae(user_id) VALUES ($1)
+		ON CONFLICT (user_id) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING `+exportColumns,
+		userId,
+	), &export)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = scanExport(dbService.pool.QueryRow(ctx, "SELECT "+exportColumns+" FROM account_export ae WHERE ae.user_id = $1 AND ae.status IN ('pending', 'running')", userId), &export)
+	}
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating export")
+	}
+	return &export, nil
+}
+
+func (dbService *DatabaseService) GetExport(ctx context.Context, exportId uuid.UUID, userId uuid.UUID) (*ExportDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var export ExportDB
+	err := scanExport(dbService.pool.QueryRow(ctx, "SELECT "+exportColumns+" FROM account_export ae WHERE ae.id = $1 AND ae.user_id = $2", exportId, userId), &export)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrExportNotFound
+		}
+		return nil, queryError(ctx, err, "error while getting export")
+	}
+	return &export, nil
+}
+
+// GetExportDownload returns the ready export the download token belongs to
+func (dbService *DatabaseService) GetExportDownload(ctx context.Context, token string) (*ExportDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var export ExportDB
+	err := scanExport(dbService.pool.QueryRow(ctx, "SELECT "+exportColumns+" FROM account_export ae WHERE ae.token = $1 AND ae.status = 'ready'", token), &export)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrExportNotFound
+		}
+		return nil, queryError(ctx, err, "error while getting export")
+	}
+	if export.ExpiresAt != nil && !export.ExpiresAt.After(time.Now()) {
+		return nil, ErrExportExpired
+	}
+	return &export, nil
+}
+
+// ClaimExport marks the oldest waiting export as running and returns it, nil when there is none.
+// SKIP LOCKED lets every instance claim exports without generating one twice
+func (dbService *DatabaseService) ClaimExport(ctx context.Context) (*ExportDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var export ExportDB
+	err := scanExport(dbService.pool.QueryRow(
+		ctx,
+		`UPDATE account_export ae SET status = 'running', started_at = CURRENT_TIMESTAMP
+		WHERE ae.id = (
+			SELECT id FROM account_export
+			WHERE status = 'pending' OR (status = 'running' AND started_at <= $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+exportColumns,
+		time.Now().Add(-exportStaleAfter),
+	), &export)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, queryError(ctx, err, "error while claiming export")
+	}
+	return &export, nil
+}
+
+// CompleteExport marks the export as ready to be downloaded until expiresAt
+func (dbService *DatabaseService) CompleteExport(ctx context.Context, export ExportDB, size int64, expiresAt time.Time) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	token, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	_, err = dbService.pool.Exec(
+		ctx,
+		"UPDATE account_export SET status = 'ready', token = $1, size = $2, completed_at = CURRENT_TIMESTAMP, expires_at = $3 WHERE id = $4",
+		token,
+		size,
+		expiresAt,
+		export.Id,
+	)
+	if err != nil {
+		return queryError(ctx, err, "error while completing export")
+	}
+	dbService.publish(export.UserId, events.ExportReady, export.Id)
+	return nil
+}
+
+// FailExport marks the export as failed, failed exports are deleted after expiresAt like ready ones
+func (dbService *DatabaseService) FailExport(ctx context.Context, export ExportDB, message string, expiresAt time.Time) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(
+		ctx,
+		"UPDATE account_export SET status = 'failed', error = $1, completed_at = CURRENT_TIMESTAMP, expires_at = $2 WHERE id = $3",
+		message,
+		expiresAt,
+		export.Id,
+	)
+	if err != nil {
+		return queryError(ctx, err, "error while failing export")
+	}
+	dbService.publish(export.UserId, events.ExportFailed, export.Id)
+	return nil
+}
+
+// DeleteExpiredExports deletes the expired exports and returns their ids, so their files can be removed
+func (dbService *DatabaseService) DeleteExpiredExports(ctx context.Context) ([]uuid.UUID, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := dbService.pool.Query(ctx, "DELETE FROM account_export ae WHERE ae.expires_at <= CURRENT_TIMESTAMP RETURNING ae.id")
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// GetExportData reads the account from one snapshot, so the files of the export are consistent
+func (dbService *DatabaseService) GetExportData(ctx context.Context, userId uuid.UUID) (*ExportData, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	defer tx.Rollback(ctx)
+
+	data := ExportData{Profile: ExportProfile{Id: userId}}
+	err = tx.QueryRow(ctx, "SELECT u.username, u.email, u.created_at, u.version FROM \"user\" u WHERE u.id = $1", userId).Scan(
+		&data.Profile.Username,
+		&data.Profile.Email,
+		&data.Profile.CreatedAt,
+		&data.Profile.Version,
+	)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+
+	rows, err := tx.Query(ctx, "SELECT "+taskColumns+" FROM task t WHERE "+exportedTask("$1")+" ORDER BY t.created_at, t.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	for rows.Next() {
+		var task TaskDB
+		if err := scanTask(rows, &task); err != nil {
+			rows.Close()
+			return nil, queryError(ctx, err, "error while reading account")
+		}
+		data.Tasks = append(data.Tasks, task)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, queryError(ctx, rows.Err(), "error while reading account")
+	}
+
+	rows, err = tx.Query(ctx, "SELECT "+taskHistoryColumns+", th.created_at FROM task_history th JOIN task t ON th.task_id = t.id WHERE "+exportedTask("$1")+" ORDER BY th.created_at, th.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	for rows.Next() {
+		var taskHistory ExportTaskHistory
+		err := rows.Scan(
+			&taskHistory.Id,
+			&taskHistory.ExecRating,
+			&taskHistory.ExecComment,
+			&taskHistory.TaskId,
+			&taskHistory.TaskName,
+			&taskHistory.TaskIcon,
+			&taskHistory.Version,
+			&taskHistory.UpdatedAt,
+			&taskHistory.ListId,
+			&taskHistory.CompletedBy,
+			&taskHistory.CompletedAt,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, queryError(ctx, err, "error while reading account")
+		}
+		data.TasksHistory = append(data.TasksHistory, taskHistory)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, queryError(ctx, rows.Err(), "error while reading account")
+	}
+
+	rows, err = tx.Query(ctx, "SELECT "+taskListColumns+" FROM task_list tl JOIN task_list_member tlm ON tlm.list_id = tl.id WHERE tlm.user_id = $1 ORDER BY tl.list_name, tl.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	data.Lists, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (ExportList, error) {
+		list := ExportList{Members: []TaskListMember{}}
+		err := scanTaskList(row, &list.TaskListDB)
+		return list, err
+	})
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	lists := map[uuid.UUID]*ExportList{}
+	for i := range data.Lists {
+		lists[data.Lists[i].Id] = &data.Lists[i]
+	}
+	rows, err = tx.Query(
+		ctx,
+		`SELECT tlm.list_id, tlm.user_id, u.username, tlm.role, tlm.created_at FROM task_list_member tlm JOIN "user" u ON u.id = tlm.user_id
+		WHERE tlm.list_id IN (SELECT m.list_id FROM task_list_member m WHERE m.user_id = $1)
+		ORDER BY tlm.created_at, u.username`,
+		userId,
+	)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	for rows.Next() {
+		var listId uuid.UUID
+		var member TaskListMember
+		if err := rows.Scan(&listId, &member.UserId, &member.Username, &member.Role, &member.JoinedAt); err != nil {
+			rows.Close()
+			return nil, queryError(ctx, err, "error while reading account")
+		}
+		if list, ok := lists[listId]; ok {
+			list.Members = append(list.Members, member)
+		}
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, queryError(ctx, rows.Err(), "error while reading account")
+	}
+
+	rows, err = tx.Query(ctx, "SELECT "+taskCommentColumns+" FROM task_comment c"+taskCommentJoins+" WHERE ("+exportedTask("$1")+" OR c.author_id = $1) ORDER BY c.created_at, c.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	data.Comments, err = collectTaskComments(rows)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+
+	rows, err = tx.Query(ctx, "SELECT "+notificationColumns+notificationJoins+" WHERE n.user_id = $1 AND n.channel = 'in_app' ORDER BY n.created_at, n.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	data.Notifications, err = collectNotifications(rows)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while reading account")
+	}
+	return &data, nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// IdempotentResponse is the stored response of a request sent with an Idempotency-Key
+type IdempotentResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+var ErrIdempotencyKeyMismatch = errors.New("idempotency key was already used for a different request")
+var ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+
+// abandonedAfter is how long a reserved key waits for the response of its request, after that
+// the request is considered lost (e.g. the server was killed) and the key can be reserved again
+const abandonedAfter = time.Minute
+
+// BeginIdempotentRequest reserves the key for a request, if the key was already used the stored response
+// is returned instead. An expired or abandoned reservation is taken over
+func (dbService *DatabaseService) BeginIdempotentRequest(ctx context.Context, userId uuid.UUID, key string, fingerprint string) (*IdempotentResponse, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var reserved bool
+	err := dbService.pool.QueryRow(
+		ctx,
+		`INSERT INTO idempotency_key(user_id, key, fingerprint, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = NULL, body = NULL, created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_key.expires_at <= CURRENT_TIMESTAMP OR (idempotency_key.status IS NULL AND idempotency_key.created_at <= $5)
+		RETURNING true`,
+		userId,
+		key,
+		fingerprint,
+		time.Now().Add(dbService.idempotencyKeyLifetime),
+		time.Now().Add(-abandonedAfter),
+	).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, queryError(ctx, err, "error while reserving idempotency key")
+	}
+
+	var storedFingerprint string
+	var status *int
+	var response IdempotentResponse
+	err = dbService.pool.QueryRow(ctx, "SELECT ik.fingerprint, ik.status, ik.headers, ik.body FROM idempotency_key ik WHERE ik.user_id = $1 AND ik.key = $2", userId, key).
+		Scan(&storedFingerprint, &status, &response.Headers, &response.Body)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// deleted by the first request in the meantime, which failed
+			return nil, ErrIdempotencyKeyInProgress
+		}
+		return nil, queryError(ctx, err, "error while reading idempotency key")
+	}
+	if storedFingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyMismatch
+	}
+	if status == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	response.Status = *status
+	return &response, nil
+}
+
+// CompleteIdempotentRequest stores the response of the request the key was reserved for
+func (dbService *DatabaseService) CompleteIdempotentRequest(ctx context.Context, userId uuid.UUID, key string, response IdempotentResponse) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(
+		ctx,
+		"UPDATE idempotency_key SET status = $1, headers = $2, body = $3 WHERE user_id = $4 AND key = $5",
+		response.Status,
+		response.Headers,
+		response.Body,
+		userId,
+		key,
+	)
+	if err != nil {
+		return queryError(ctx, err, "error while storing idempotent response")
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets the key after its request failed, so the request can be retried
+func (dbService *DatabaseService) ReleaseIdempotencyKey(ctx context.Context, userId uuid.UUID, key string) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(ctx, "DELETE FROM idempotency_key WHERE user_id = $1 AND key = $2 AND status IS NULL", userId, key)
+	if err != nil {
+		return queryError(ctx, err, "error while releasing idempotency key")
+	}
+	return nil
+}
+
+func (dbService *DatabaseService) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(ctx, "DELETE FROM idempotency_key ik WHERE ik.expires_at <= CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return cmdTag.RowsAffected(), nil
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ImportBatchSize is the number of tasks imported per transaction, so a large file doesn't
+// hold one long transaction and every batch fits in the query timeout
+const ImportBatchSize = 500
+
+// ImportTasks creates the tasks in batches, skipping the ones the user already has (same name and
+// deadline) and repeated ones. With dryRun every batch is rolled back, so the results are exactly
+// what an import would do. When a batch fails the results of the committed batches are returned
+// with the error, importing the file again skips those tasks as duplicates
+func (dbService *DatabaseService) ImportTasks(ctx context.Context, userId uuid.UUID, tasks []ImportTask, dryRun bool) ([]ImportRowResult, error) {
+	results := make([]ImportRowResult, 0, len(tasks))
+	seen := map[string]bool{}
+	for start := 0; start < len(tasks); start += ImportBatchSize {
+		batch := tasks[start:min(start+ImportBatchSize, len(tasks))]
+		batchResults, err := dbService.importBatch(ctx, userId, batch, seen, dryRun)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, batchResults...)
+	}
+	return results, nil
+}
+
+func (dbService *DatabaseService) importBatch(ctx context.Context, userId uuid.UUID, tasks []ImportTask, seen map[string]bool, dryRun bool) ([]ImportRowResult, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while importing tasks")
+	}
+	defer tx.Rollback(ctx)
+
+	names := make([]string, len(tasks))
+	for i, task := range tasks {
+		names[i] = task.TaskName
+	}
+	rows, err := tx.Query(ctx, "SELECT t.task_name, t.deadline FROM task t WHERE t.created_by = $1 AND t.list_id IS NULL AND t.task_name = ANY($2)", userId, names)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while importing tasks")
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var deadline *time.Time
+		if err := rows.Scan(&name, &deadline); err != nil {
+			rows.Close()
+			return nil, queryError(ctx, err, "error while importing tasks")
+		}
+		existing[importKey(name, deadline)] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, queryError(ctx, rows.Err(), "error while importing tasks")
+	}
+
+	var taskRows, historyRows [][]any
+	var changes []change
+	var completed int
+	results := make([]ImportRowResult, 0, len(tasks))
+	for _, task := range tasks {
+		key := importKey(task.TaskName, task.Deadline)
+		if existing[key] || seen[key] {
+			results = append(results, ImportRowResult{Row: task.Row, Status: ImportDuplicate, Task: &task})
+			continue
+		}
+		seen[key] = true
+		taskId := uuid.New()
+		execStatus := "ACTIVE"
+		if task.Completed {
+			execStatus = "INACTIVE"
+		}
+		taskRows = append(taskRows, []any{taskId, task.TaskName, task.TaskIcon, task.TaskDesc, task.Deadline, task.Starred, execStatus, userId})
+		changes = append(changes, change{events.TaskCreated, taskId, nil})
+		if task.Completed {
+			historyId := uuid.New()
+			historyRows = append(historyRows, []any{historyId, task.ExecRating, task.ExecComment, taskId, userId})
+			changes = append(changes, change{events.TaskHistoryCreated, historyId, nil})
+			completed++
+		}
+		result := ImportRowResult{Row: task.Row, Status: ImportCreated, Task: &task}
+		if !dryRun {
+			result.TaskId = &taskId
+		}
+		results = append(results, result)
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"task"}, []string{"id", "task_name", "task_icon", "task_desc", "deadline", "starred", "exec_status", "created_by"}, pgx.CopyFromRows(taskRows))
+	if err != nil {
+		return nil, queryError(ctx, err, "error while importing tasks")
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"task_history"}, []string{"id", "exec_rating", "exec_comment", "task_id", "completed_by"}, pgx.CopyFromRows(historyRows))
+	if err != nil {
+		return nil, queryError(ctx, err, "error while importing tasks")
+	}
+	if dryRun {
+		return results, nil
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while importing tasks")
+	}
+	tasksCreated.Add(float64(len(taskRows)))
+	tasksCompleted.Add(float64(completed))
+	dbService.publishChanges(ctx, userId, changes...)
+	return results, nil
+}
+
+// importKey identifies a task for duplicate detection, deadlines are stored with second precision
+func importKey(name string, deadline *time.Time) string {
+	if deadline == nil {
+		return name
+	}
+	return name + "\x00" + deadline.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportKey(t *testing.T) {
+	deadline := time.Date(2024, time.March, 1, 9, 30, 0, 0, time.UTC)
+	sameInstant := deadline.In(time.FixedZone("CET", 3600)).Add(400 * time.Millisecond)
+	later := deadline.Add(time.Second)
+
+	tests := []struct {
+		name      string
+		a, b      string
+		deadlineA *time.Time
+		deadlineB *time.Time
+		duplicate bool
+	}{
+		{"same name without deadline", "Water plants", "Water plants", nil, nil, true},
+		{"same deadline in another zone and below a second", "Water plants", "Water plants", &deadline, &sameInstant, true},
+		{"names are compared exactly", "Water plants", "water plants", nil, nil, false},
+		{"deadline and no deadline", "Water plants", "Water plants", &deadline, nil, false},
+		{"different deadlines", "Water plants", "Water plants", &deadline, &later, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if duplicate := importKey(test.a, test.deadlineA) == importKey(test.b, test.deadlineB); duplicate != test.duplicate {
+				t.Errorf("expected duplicate to be %v", test.duplicate)
+			}
+		})
+	}
+}
@@ -0,0 +1,737 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var ErrForbidden = errors.New("you don't have permission to do that")
+var ErrListNotFound = errors.New("list doesn't exist")
+var ErrInvitationNotFound = errors.New("invitation doesn't exist")
+var ErrInvalidRole = errors.New("role must be editor or viewer")
+var ErrInvalidInvitee = errors.New("user must be a username or email")
+var ErrAlreadyMember = errors.New("user is already a member of the list")
+var ErrOwnerCantLeave = errors.New("the owner can't leave the list, delete it instead")
+var ErrInvalidAssignee = errors.New("the assignee must be a member of the task's list")
+
+// The access checks of tasks, user is the SQL parameter of the user (e.g. $2 or @userId). Personal tasks
+// are only accessible by their creator and the tasks of a list by its members, viewers can only read them
+
+func taskReadable(user string) string {
+	return "(t.list_id IS NULL AND t.created_by = " + user + " OR t.list_id IN (SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = " + user + "))"
+}
+
+func taskEditable(user string) string {
+	return "(t.list_id IS NULL AND t.created_by = " + user + " OR t.list_id IN (SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = " + user + " AND tlm.role <> 'viewer'))"
+}
+
+// taskCompletable also lets the assignee complete the task, even as a viewer of its list
+func taskCompletable(user string) string {
+	return "(" + taskEditable(user) + " OR t.assigned_to = " + user + ")"
+}
+
+// listFilterCondition adds the condition of filter to the query of tasks t
+func listFilterCondition(filter ListFilter, args pgx.NamedArgs) string {
+	switch {
+	case filter.ListId != nil:
+		args["listId"] = *filter.ListId
+		return " AND t.list_id = @listId"
+	case filter.Personal:
+		return " AND t.list_id IS NULL"
+	}
+	return ""
+}
+
+// taskRole returns the role of the user for the task, owner for their personal tasks and "" when
+// they can't access it
+func (dbService *DatabaseService) taskRole(ctx context.Context, taskId uuid.UUID, userId uuid.UUID) (string, error) {
+	var role string
+	err := dbService.pool.QueryRow(
+		ctx,
+		"SELECT COALESCE(tlm.role, 'owner') FROM task t LEFT JOIN task_list_member tlm ON tlm.list_id = t.list_id AND tlm.user_id = $2 WHERE t.id = $1 AND "+taskReadable("$2"),
+		taskId,
+		userId,
+	).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// taskHistoryRole is taskRole for the task of a history entry
+func (dbService *DatabaseService) taskHistoryRole(ctx context.Context, taskHistoryId uuid.UUID, userId uuid.UUID) (string, error) {
+	var role string
+	err := dbService.pool.QueryRow(
+		ctx,
+		"SELECT COALESCE(tlm.role, 'owner') FROM task_history th JOIN task t ON th.task_id = t.id LEFT JOIN task_list_member tlm ON tlm.list_id = t.list_id AND tlm.user_id = $2 WHERE th.id = $1 AND "+taskReadable("$2"),
+		taskHistoryId,
+		userId,
+	).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// missedTaskUpdateError is the error of a change of a task that matched no row: the task doesn't exist,
+// the user may only read it or its version is no longer the expected one
+func (dbService *DatabaseService) missedTaskUpdateError(ctx context.Context, taskId uuid.UUID, userId uuid.UUID, version *int64, notFound string) error {
+	role, err := dbService.taskRole(ctx, taskId, userId)
+	return missedChangeError(ctx, role, err, version, notFound)
+}
+
+func (dbService *DatabaseService) missedTaskHistoryUpdateError(ctx context.Context, taskHistoryId uuid.UUID, userId uuid.UUID, version *int64, notFound string) error {
+	role, err := dbService.taskHistoryRole(ctx, taskHistoryId, userId)
+	return missedChangeError(ctx, role, err, version, notFound)
+}
+
+// missedTaskAssignmentError is missedTaskUpdateError for a patch that may assign the task, it also misses
+// when the new assignee can't read the task
+func (dbService *DatabaseService) missedTaskAssignmentError(ctx context.Context, taskId uuid.UUID, userId uuid.UUID, assignee PatchField[uuid.UUID], version *int64) error {
+	role, err := dbService.taskRole(ctx, taskId, userId)
+	if err == nil && role != "" && role != RoleViewer && assignee.Set && !assignee.Null {
+		assigneeRole, err := dbService.taskRole(ctx, taskId, assignee.Value)
+		if err != nil {
+			return queryError(ctx, err, "unexpected error")
+		}
+		if assigneeRole == "" {
+			return ErrInvalidAssignee
+		}
+	}
+	return missedChangeError(ctx, role, err, version, "task doesn't exist")
+}
+
+func missedChangeError(ctx context.Context, role string, err error, version *int64, notFound string) error {
+	if err != nil {
+		return queryError(ctx, err, "unexpected error")
+	}
+	switch {
+	case role == "":
+		return errors.New(notFound)
+	case role == RoleViewer:
+		return ErrForbidden
+	case version != nil:
+		return ErrPreconditionFailed
+	}
+	return errors.New(notFound)
+}
+
+// listRole returns the role of the user in the list, "" when they aren't a member
+func listRole(ctx context.Context, tx pgx.Tx, listId uuid.UUID, userId uuid.UUID) (string, error) {
+	var role string
+	err := tx.QueryRow(ctx, "SELECT tlm.role FROM task_list_member tlm WHERE tlm.list_id = $1 AND tlm.user_id = $2", listId, userId).Scan(&role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+// requireListRole fails unless the user is a member of the list with one of the roles
+func requireListRole(ctx context.Context, tx pgx.Tx, listId uuid.UUID, userId uuid.UUID, roles ...string) error {
+	role, err := listRole(ctx, tx, listId, userId)
+	if err != nil {
+		return queryError(ctx, err, "unexpected error")
+	}
+	if role == "" {
+		return ErrListNotFound
+	}
+	for _, allowed := range roles {
+		if role == allowed {
+			return nil
+		}
+	}
+	return ErrForbidden
+}
+
+func (dbService *DatabaseService) listMemberIds(ctx context.Context, listId uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := dbService.pool.Query(ctx, "SELECT tlm.user_id FROM task_list_member tlm WHERE tlm.list_id = $1", listId)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// publishChanges publishes committed changes to everyone who can read them: the user for personal
+// tasks, every member for the tasks of a list
+func (dbService *DatabaseService) publishChanges(ctx context.Context, userId uuid.UUID, changes ...change) {
+	if dbService.publisher == nil {
+		return
+	}
+	members := map[uuid.UUID][]uuid.UUID{}
+	var messages []events.Message
+	for _, change := range changes {
+		if change.listId == nil {
+			messages = append(messages, events.Message{UserId: userId, Type: change.eventType, Id: change.id})
+			continue
+		}
+		listMembers, ok := members[*change.listId]
+		if !ok {
+			var err error
+			listMembers, err = dbService.listMemberIds(ctx, *change.listId)
+			if err != nil {
+				// the other members get the change with their next sync
+				slog.WarnContext(ctx, "error while getting list members", "list_id", *change.listId, "error", err)
+				listMembers = []uuid.UUID{userId}
+			}
+			members[*change.listId] = listMembers
+		}
+		for _, memberId := range listMembers {
+			messages = append(messages, events.Message{UserId: memberId, Type: change.eventType, Id: change.id})
+		}
+	}
+	dbService.publishMessages(messages)
+}
+
+// publishToList publishes a change of the list itself to its members
+func (dbService *DatabaseService) publishToList(ctx context.Context, listId uuid.UUID, eventType string) {
+	if dbService.publisher == nil {
+		return
+	}
+	memberIds, err := dbService.listMemberIds(ctx, listId)
+	if err != nil {
+		slog.WarnContext(ctx, "error while getting list members", "list_id", listId, "error", err)
+		return
+	}
+	dbService.publishMessages(listMessages(memberIds, eventType, listId))
+}
+
+func listMessages(memberIds []uuid.UUID, eventType string, listId uuid.UUID) []events.Message {
+	messages := make([]events.Message, 0, len(memberIds))
+	for _, memberId := range memberIds {
+		messages = append(messages, events.Message{UserId: memberId, Type: eventType, Id: listId})
+	}
+	return messages
+}
+
+// LISTS
+
+const taskListColumns = "tl.id, tl.list_name, tl.created_at, tlm.role"
+
+func scanTaskList(row pgx.Row, list *TaskListDB) error {
+	return row.Scan(&list.Id, &list.ListName, &list.CreatedAt, &list.Role)
+}
+
+func (dbService *DatabaseService) GetLists(ctx context.Context, userId uuid.UUID) ([]TaskListDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := dbService.pool.Query(ctx, "SELECT "+taskListColumns+" FROM task_list tl JOIN task_list_member tlm ON tlm.list_id = tl.id WHERE tlm.user_id = $1 ORDER BY tl.list_name, tl.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting lists")
+	}
+	lists, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TaskListDB, error) {
+		var list TaskListDB
+		err := scanTaskList(row, &list)
+		return list, err
+	})
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+	return lists, nil
+}
+
+func (dbService *DatabaseService) GetList(ctx context.Context, listId uuid.UUID, userId uuid.UUID) (*TaskListDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var list TaskListDB
+	err := scanTaskList(dbService.pool.QueryRow(ctx, "SELECT "+taskListColumns+" FROM task_list tl JOIN task_list_member tlm ON tlm.list_id = tl.id WHERE tl.id = $1 AND tlm.user_id = $2", listId, userId), &list)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrListNotFound
+		}
+		return nil, queryError(ctx, err, "error while getting list")
+	}
+	return &list, nil
+}
+
+// CreateList creates a list owned by the user
+func (dbService *DatabaseService) CreateList(ctx context.Context, list TaskListPost, userId uuid.UUID) (*TaskListDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating list")
+	}
+	defer tx.Rollback(ctx)
+
+	created := TaskListDB{ListName: list.ListName, Role: RoleOwner}
+	err = tx.QueryRow(ctx, "INSERT INTO task_list(list_name) VALUES ($1) RETURNING id, created_at", list.ListName).Scan(&created.Id, &created.CreatedAt)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating list")
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO task_list_member(list_id, user_id, role) VALUES ($1, $2, 'owner')", created.Id, userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating list")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating list")
+	}
+	dbService.publish(userId, events.ListUpdated, created.Id)
+	return &created, nil
+}
+
+// RenameList changes the name of a list, only its owner can
+func (dbService *DatabaseService) RenameList(ctx context.Context, listId uuid.UUID, list TaskListPost, userId uuid.UUID) (*TaskListDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var renamed TaskListDB
+	err := scanTaskList(dbService.pool.QueryRow(
+		ctx,
+		"UPDATE task_list tl SET list_name = $1 FROM task_list_member tlm WHERE tl.id = $2 AND tlm.list_id = tl.id AND tlm.user_id = $3 AND tlm.role = 'owner' RETURNING "+taskListColumns,
+		list.ListName,
+		listId,
+		userId,
+	), &renamed)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			if _, err := dbService.GetList(ctx, listId, userId); err != nil {
+				return nil, err
+			}
+			return nil, ErrForbidden
+		}
+		return nil, queryError(ctx, err, "error while renaming list")
+	}
+	dbService.publishToList(ctx, listId, events.ListUpdated)
+	return &renamed, nil
+}
+
+// DeleteList deletes the list with its tasks and history entries, only its owner can
+func (dbService *DatabaseService) DeleteList(ctx context.Context, listId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting list")
+	}
+	defer tx.Rollback(ctx)
+
+	// waits for tasks being added to the list, adding a task locks the list row through the foreign key
+	_, err = tx.Exec(ctx, "SELECT 1 FROM task_list WHERE id = $1 FOR UPDATE", listId)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting list")
+	}
+	if err := requireListRole(ctx, tx, listId, userId, RoleOwner); err != nil {
+		return err
+	}
+	memberIds, err := deleteList(ctx, tx, listId)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting list")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting list")
+	}
+	dbService.publishMessages(listMessages(memberIds, events.ListRemoved, listId))
+	return nil
+}
+
+// deleteList deletes the list and everything in it and returns the ids of its members. The members
+// are deleted by ON DELETE CASCADE, which leaves their tombstones
+func deleteList(ctx context.Context, tx pgx.Tx, listId uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := tx.Query(ctx, "SELECT tlm.user_id FROM task_list_member tlm WHERE tlm.list_id = $1", listId)
+	if err != nil {
+		return nil, err
+	}
+	memberIds, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+	statements := []string{
+		"DELETE FROM task_history th USING task t WHERE th.task_id = t.id AND t.list_id = $1",
+		"DELETE FROM task WHERE list_id = $1",
+		"DELETE FROM task_list WHERE id = $1",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(ctx, statement, listId); err != nil {
+			return nil, err
+		}
+	}
+	return memberIds, nil
+}
+
+// MEMBERS
+
+func (dbService *DatabaseService) GetListMembers(ctx context.Context, listId uuid.UUID, userId uuid.UUID) ([]TaskListMember, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	if _, err := dbService.GetList(ctx, listId, userId); err != nil {
+		return nil, err
+	}
+	rows, err := dbService.pool.Query(
+		ctx,
+		"SELECT tlm.user_id, u.username, tlm.role, tlm.created_at FROM task_list_member tlm JOIN \"user\" u ON u.id = tlm.user_id WHERE tlm.list_id = $1 ORDER BY tlm.created_at, u.username",
+		listId,
+	)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting list members")
+	}
+	members, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (TaskListMember, error) {
+		var member TaskListMember
+		err := row.Scan(&member.UserId, &member.Username, &member.Role, &member.JoinedAt)
+		return member, err
+	})
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+	return members, nil
+}
+
+// UpdateListMember changes the role of a member, only the owner can and the owner's own role can't be changed
+func (dbService *DatabaseService) UpdateListMember(ctx context.Context, listId uuid.UUID, memberId uuid.UUID, role string, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	if role != RoleEditor && role != RoleViewer {
+		return ErrInvalidRole
+	}
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return queryError(ctx, err, "error while updating list member")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := requireListRole(ctx, tx, listId, userId, RoleOwner); err != nil {
+		return err
+	}
+	cmdTag, err := tx.Exec(ctx, "UPDATE task_list_member SET role = $1 WHERE list_id = $2 AND user_id = $3 AND role <> 'owner'", role, listId, memberId)
+	if err != nil {
+		return queryError(ctx, err, "error while updating list member")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		if memberId == userId {
+			return ErrForbidden
+		}
+		return errors.New("member doesn't exist")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return queryError(ctx, err, "error while updating list member")
+	}
+	dbService.publishToList(ctx, listId, events.ListUpdated)
+	return nil
+}
+
+// RemoveListMember removes a member from the list. The owner can remove anyone else, the other members
+// can only remove themselves (leave the list)
+func (dbService *DatabaseService) RemoveListMember(ctx context.Context, listId uuid.UUID, memberId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return queryError(ctx, err, "error while removing list member")
+	}
+	defer tx.Rollback(ctx)
+
+	role, err := listRole(ctx, tx, listId, userId)
+	if err != nil {
+		return queryError(ctx, err, "error while removing list member")
+	}
+	switch {
+	case role == "":
+		return ErrListNotFound
+	case memberId == userId && role == RoleOwner:
+		return ErrOwnerCantLeave
+	case memberId != userId && role != RoleOwner:
+		return ErrForbidden
+	}
+	cmdTag, err := tx.Exec(ctx, "DELETE FROM task_list_member WHERE list_id = $1 AND user_id = $2", listId, memberId)
+	if err != nil {
+		return queryError(ctx, err, "error while removing list member")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return errors.New("member doesn't exist")
+	}
+	// the tasks assigned to the removed member become unassigned
+	rows, err := tx.Query(ctx, "UPDATE task SET assigned_to = NULL WHERE list_id = $1 AND assigned_to = $2 RETURNING id", listId, memberId)
+	if err != nil {
+		return queryError(ctx, err, "error while removing list member")
+	}
+	unassignedIds, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return queryError(ctx, err, "error while removing list member")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return queryError(ctx, err, "error while removing list member")
+	}
+	dbService.publish(memberId, events.ListRemoved, listId)
+	dbService.publishToList(ctx, listId, events.ListUpdated)
+	changes := make([]change, 0, len(unassignedIds))
+	for _, taskId := range unassignedIds {
+		changes = append(changes, change{events.TaskUpdated, taskId, &listId})
+	}
+	dbService.publishChanges(ctx, userId, changes...)
+	return nil
+}
+
+// INVITATIONS
+
+const invitationColumns = "tli.id, tli.list_id, tl.list_name, tli.invited_as, tli.role, inviter.username, tli.created_at"
+
+const invitationJoins = " FROM task_list_invitation tli JOIN task_list tl ON tl.id = tli.list_id JOIN \"user\" inviter ON inviter.id = tli.invited_by"
+
+func scanInvitation(row pgx.Row, invitation *TaskListInvitation) error {
+	return row.Scan(
+		&invitation.Id,
+		&invitation.ListId,
+		&invitation.ListName,
+		&invitation.Username,
+		&invitation.Role,
+		&invitation.InvitedBy,
+		&invitation.CreatedAt,
+	)
+}
+
+func collectInvitations(rows pgx.Rows) ([]TaskListInvitation, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (TaskListInvitation, error) {
+		var invitation TaskListInvitation
+		err := scanInvitation(row, &invitation)
+		return invitation, err
+	})
+}
+
+// findInvitee returns the user with the email when invitee contains an @ and the one with the username
+// otherwise, nil when there is none. Emails are compared case-insensitively, an exact match is preferred
+// and an ambiguous one matches nobody
+func findInvitee(ctx context.Context, tx pgx.Tx, invitee string, byEmail bool) (*uuid.UUID, error) {
+	if !byEmail {
+		var userId uuid.UUID
+		err := tx.QueryRow(ctx, "SELECT u.id FROM \"user\" u WHERE u.username = $1", invitee).Scan(&userId)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return &userId, err
+	}
+	rows, err := tx.Query(ctx, "SELECT u.id, u.email = $1 FROM \"user\" u WHERE lower(u.email) = lower($1) ORDER BY u.email = $1 DESC LIMIT 2", invitee)
+	if err != nil {
+		return nil, err
+	}
+	type match struct {
+		userId uuid.UUID
+		exact  bool
+	}
+	matches, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (match, error) {
+		var m match
+		err := row.Scan(&m.userId, &m.exact)
+		return m, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 1 || (len(matches) == 2 && matches[0].exact) {
+		return &matches[0].userId, nil
+	}
+	return nil, nil
+}
+
+// CreateInvitation invites the user with the username or email to the list, only the owner can invite.
+// Inviting the same username or email again changes the role of the invitation. The response is the same
+// whether or not someone has the username or email, an invitation nobody matches is stored without a user
+// and never delivered
+func (dbService *DatabaseService) CreateInvitation(ctx context.Context, listId uuid.UUID, invitation TaskListInvitationPost, userId uuid.UUID) (*TaskListInvitation, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	if invitation.Role != RoleEditor && invitation.Role != RoleViewer {
+		return nil, ErrInvalidRole
+	}
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating invitation")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := requireListRole(ctx, tx, listId, userId, RoleOwner); err != nil {
+		return nil, err
+	}
+	invitee := strings.TrimSpace(invitation.User)
+	if invitee == "" {
+		return nil, ErrInvalidInvitee
+	}
+	byEmail := strings.Contains(invitee, "@")
+	// inviting the same email in other case is the same invitation
+	invitedAs := invitee
+	if byEmail {
+		invitedAs = strings.ToLower(invitee)
+	}
+	inviteeId, err := findInvitee(ctx, tx, invitee, byEmail)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating invitation")
+	}
+	if inviteeId != nil {
+		role, err := listRole(ctx, tx, listId, *inviteeId)
+		if err != nil {
+			return nil, queryError(ctx, err, "error while creating invitation")
+		}
+		if role != "" && !byEmail {
+			return nil, ErrAlreadyMember
+		}
+		if role != "" {
+			// answering 409 would tell the owner which member has the email, the invitation isn't delivered instead
+			inviteeId = nil
+		}
+	}
+
+	var invitationId uuid.UUID
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO task_list_invitation(list_id, user_id, invited_as, role, invited_by) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (list_id, invited_as) DO UPDATE SET user_id = EXCLUDED.user_id, role = EXCLUDED.role, invited_by = EXCLUDED.invited_by
+		RETURNING id`,
+		listId,
+		inviteeId,
+		invitedAs,
+		invitation.Role,
+		userId,
+	).Scan(&invitationId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating invitation")
+	}
+	var created TaskListInvitation
+	err = scanInvitation(tx.QueryRow(ctx, "SELECT "+invitationColumns+invitationJoins+" WHERE tli.id = $1", invitationId), &created)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating invitation")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating invitation")
+	}
+	if inviteeId == nil {
+		return &created, nil
+	}
+	dbService.publish(*inviteeId, events.ListInvitation, invitationId)
+	dbService.notifier.Notify(ctx, Notification{
+		UserId:   *inviteeId,
+		Type:     NotificationInvitation,
+		EntityId: invitationId,
+		ActorId:  &userId,
+		Subject:  created.ListName,
+	})
+	return &created, nil
+}
+
+// GetListInvitations returns the pending invitations of a list, only the owner can see them
+func (dbService *DatabaseService) GetListInvitations(ctx context.Context, listId uuid.UUID, userId uuid.UUID) ([]TaskListInvitation, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	list, err := dbService.GetList(ctx, listId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if list.Role != RoleOwner {
+		return nil, ErrForbidden
+	}
+	rows, err := dbService.pool.Query(ctx, "SELECT "+invitationColumns+invitationJoins+" WHERE tli.list_id = $1 ORDER BY tli.created_at, tli.id", listId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting invitations")
+	}
+	invitations, err := collectInvitations(rows)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+	return invitations, nil
+}
+
+// GetInvitations returns the invitations the user received
+func (dbService *DatabaseService) GetInvitations(ctx context.Context, userId uuid.UUID) ([]TaskListInvitation, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := dbService.pool.Query(ctx, "SELECT "+invitationColumns+invitationJoins+" WHERE tli.user_id = $1 ORDER BY tli.created_at, tli.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting invitations")
+	}
+	invitations, err := collectInvitations(rows)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+	return invitations, nil
+}
+
+// AcceptInvitation makes the user a member of the list with the role of the invitation
+func (dbService *DatabaseService) AcceptInvitation(ctx context.Context, invitationId uuid.UUID, userId uuid.UUID) (*TaskListDB, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while accepting invitation")
+	}
+	defer tx.Rollback(ctx)
+
+	var listId uuid.UUID
+	var role string
+	err = tx.QueryRow(ctx, "DELETE FROM task_list_invitation tli WHERE tli.id = $1 AND tli.user_id = $2 RETURNING tli.list_id, tli.role", invitationId, userId).Scan(&listId, &role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, queryError(ctx, err, "error while accepting invitation")
+	}
+	// the user may have been invited by both username and email
+	_, err = tx.Exec(ctx, "DELETE FROM task_list_invitation tli WHERE tli.list_id = $1 AND tli.user_id = $2", listId, userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while accepting invitation")
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO task_list_member(list_id, user_id, role) VALUES ($1, $2, $3)", listId, userId, role)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrAlreadyMember
+		}
+		return nil, queryError(ctx, err, "error while accepting invitation")
+	}
+	var list TaskListDB
+	err = scanTaskList(tx.QueryRow(ctx, "SELECT "+taskListColumns+" FROM task_list tl JOIN task_list_member tlm ON tlm.list_id = tl.id WHERE tl.id = $1 AND tlm.user_id = $2", listId, userId), &list)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while accepting invitation")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while accepting invitation")
+	}
+	dbService.publishToList(ctx, listId, events.ListUpdated)
+	return &list, nil
+}
+
+// DeleteInvitation declines the invitation when the user was invited, or cancels it when they own the list
+func (dbService *DatabaseService) DeleteInvitation(ctx context.Context, invitationId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(
+		ctx,
+		`DELETE FROM task_list_invitation tli WHERE tli.id = $1 AND (tli.user_id = $2
+		OR EXISTS (SELECT 1 FROM task_list_member tlm WHERE tlm.list_id = tli.list_id AND tlm.user_id = $2 AND tlm.role = 'owner'))`,
+		invitationId,
+		userId,
+	)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting invitation")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
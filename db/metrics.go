@@ -0,0 +1,49 @@
+package db
+
+import (
+	"sync/atomic"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/metrics"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	tasksCreated   = metrics.NewCounterVec("taskjournal_tasks_created_total", "Number of created tasks.")
+	tasksCompleted = metrics.NewCounterVec("taskjournal_tasks_completed_total", "Number of completed tasks.")
+
+	bcryptWaiting atomic.Int64
+	bcryptActive  atomic.Int64
+)
+
+func init() {
+	metrics.NewGaugeFunc("taskjournal_bcrypt_queue_depth", "Number of password hash operations waiting for a free slot.", func() float64 {
+		return float64(bcryptWaiting.Load())
+	})
+	metrics.NewGaugeFunc("taskjournal_bcrypt_in_progress", "Number of password hash operations currently running.", func() float64 {
+		return float64(bcryptActive.Load())
+	})
+}
+
+// RegisterPoolMetrics exposes the connection pool statistics, it must be called once per process.
+// pgxpool doesn't report how many acquires are currently waiting, the number of acquires that had to wait
+// for a connection and the total time spent acquiring are exported instead
+func RegisterPoolMetrics(pool *pgxpool.Pool) {
+	metrics.NewGaugeFunc("taskjournal_db_pool_acquired_connections", "Number of connections currently in use.", func() float64 {
+		return float64(pool.Stat().AcquiredConns())
+	})
+	metrics.NewGaugeFunc("taskjournal_db_pool_idle_connections", "Number of idle connections in the pool.", func() float64 {
+		return float64(pool.Stat().IdleConns())
+	})
+	metrics.NewGaugeFunc("taskjournal_db_pool_total_connections", "Number of connections in the pool.", func() float64 {
+		return float64(pool.Stat().TotalConns())
+	})
+	metrics.NewGaugeFunc("taskjournal_db_pool_max_connections", "Maximum size of the pool.", func() float64 {
+		return float64(pool.Stat().MaxConns())
+	})
+	metrics.NewCounterFunc("taskjournal_db_pool_empty_acquire_total", "Number of acquires that had to wait for a connection.", func() float64 {
+		return float64(pool.Stat().EmptyAcquireCount())
+	})
+	metrics.NewCounterFunc("taskjournal_db_pool_acquire_duration_seconds_total", "Total time spent waiting for a connection.", func() float64 {
+		return pool.Stat().AcquireDuration().Seconds()
+	})
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migrations are SQL files in the migrations directory named <version>_<description>.sql,
+// they are applied in order of version and every applied version is recorded in schema_migration.
+// 0001_initial.sql creates the tables with IF NOT EXISTS so databases created with the old
+// create_script.sql are adopted without changes
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockId is the key of the advisory lock held while migrating, so only one instance migrates at a time
+const migrationLockId = 7243158
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	for _, entry := range entries {
+		versionString, _, found := strings.Cut(entry.Name(), "_")
+		version, err := strconv.Atoi(versionString)
+		if !found || err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: entry.Name(), sql: string(content)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// Migrate applies all migrations that haven't been applied yet, each in its own transaction
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockId); err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockId)
+
+	_, err = conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migration(version int NOT NULL, applied_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL, CONSTRAINT pk_schema_migration_version PRIMARY KEY(version))")
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migration(version) VALUES ($1)", m.version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error applying migration %s: %w", m.name, err)
+		}
+		slog.InfoContext(ctx, "migration applied", "migration", m.name)
+	}
+	return nil
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, q querier) (map[int]bool, error) {
+	rows, err := q.Query(ctx, "SELECT version FROM schema_migration")
+	if err != nil {
+		return nil, err
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// PendingMigrations returns the names of migrations that haven't been applied to the database
+func (dbService *DatabaseService) PendingMigrations(ctx context.Context) ([]string, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, dbService.pool)
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, m := range migrations {
+		if !applied[m.version] {
+			pending = append(pending, m.name)
+		}
+	}
+	return pending, nil
+}
+
+func (dbService *DatabaseService) Ping(ctx context.Context) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	return dbService.pool.Ping(ctx)
+}
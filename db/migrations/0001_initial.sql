@@ -1,4 +1,4 @@
-CREATE TABLE "user"(
+CREATE TABLE IF NOT EXISTS "user"(
     id uuid DEFAULT gen_random_uuid() NOT NULL,
     username text NOT NULL UNIQUE,
     email text NOT NULL UNIQUE,
@@ -7,7 +7,7 @@ CREATE TABLE "user"(
     CONSTRAINT pk_user_id PRIMARY KEY(id)
 );
 
-CREATE TABLE user_auth(
+CREATE TABLE IF NOT EXISTS user_auth(
     id uuid DEFAULT gen_random_uuid() NOT NULL,
     user_id uuid NOT NULL,
     expires_at timestamp(0) NOT NULL,
@@ -15,7 +15,7 @@ CREATE TABLE user_auth(
     CONSTRAINT fk_user_auth_user_id FOREIGN KEY(user_id) REFERENCES "user"(id)
 );
 
-CREATE TABLE task(
+CREATE TABLE IF NOT EXISTS task(
     id uuid DEFAULT gen_random_uuid() NOT NULL,
     task_name text NOT NULL,
     task_icon text NOT NULL, 
@@ -29,11 +29,11 @@ CREATE TABLE task(
     CONSTRAINT fk_task_created_by FOREIGN KEY(created_by) REFERENCES "user"(id)
 );
 
-CREATE TABLE task_history(
+CREATE TABLE IF NOT EXISTS task_history(
     id uuid DEFAULT gen_random_uuid() NOT NULL,
     exec_rating int,
     exec_comment text,
     task_id uuid NOT NULL,
     CONSTRAINT pk_task_history_id PRIMARY KEY(id),
     CONSTRAINT fk_task_history_task_id FOREIGN KEY(task_id) REFERENCES task(id)
-);
\ No newline at end of file
+);
@@ -0,0 +1,30 @@
+-- version is incremented on every update and is returned to clients as the ETag of the resource
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS version bigint DEFAULT 1 NOT NULL;
+ALTER TABLE task ADD COLUMN IF NOT EXISTS version bigint DEFAULT 1 NOT NULL;
+ALTER TABLE task_history ADD COLUMN IF NOT EXISTS version bigint DEFAULT 1 NOT NULL;
+
+CREATE OR REPLACE FUNCTION increment_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_increment_version BEFORE UPDATE ON "user"
+    FOR EACH ROW EXECUTE FUNCTION increment_version();
+CREATE TRIGGER task_increment_version BEFORE UPDATE ON task
+    FOR EACH ROW EXECUTE FUNCTION increment_version();
+CREATE TRIGGER task_history_increment_version BEFORE UPDATE ON task_history
+    FOR EACH ROW EXECUTE FUNCTION increment_version();
+
+-- history entries include the name and icon of their task, so renaming the task changes them too
+CREATE OR REPLACE FUNCTION touch_task_history() RETURNS trigger AS $$
+BEGIN
+    UPDATE task_history SET task_id = task_id WHERE task_id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER task_touch_task_history AFTER UPDATE OF task_name, task_icon ON task
+    FOR EACH ROW WHEN (OLD.task_name IS DISTINCT FROM NEW.task_name OR OLD.task_icon IS DISTINCT FROM NEW.task_icon)
+    EXECUTE FUNCTION touch_task_history();
@@ -0,0 +1,59 @@
+-- sync_xid is the id of the transaction that last wrote the row, GET /sync returns the rows written
+-- by transactions that weren't finished when the client's previous sync token was issued
+ALTER TABLE task
+    ADD COLUMN IF NOT EXISTS updated_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    ADD COLUMN IF NOT EXISTS sync_xid xid8 DEFAULT pg_current_xact_id() NOT NULL;
+ALTER TABLE task_history
+    ADD COLUMN IF NOT EXISTS updated_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    ADD COLUMN IF NOT EXISTS sync_xid xid8 DEFAULT pg_current_xact_id() NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_task_created_by_sync_xid ON task(created_by, sync_xid);
+CREATE INDEX IF NOT EXISTS idx_task_history_sync_xid ON task_history(sync_xid);
+
+CREATE OR REPLACE FUNCTION touch_sync() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at := CURRENT_TIMESTAMP;
+    NEW.sync_xid := pg_current_xact_id();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER task_touch_sync BEFORE UPDATE ON task
+    FOR EACH ROW EXECUTE FUNCTION touch_sync();
+CREATE TRIGGER task_history_touch_sync BEFORE UPDATE ON task_history
+    FOR EACH ROW EXECUTE FUNCTION touch_sync();
+
+-- tombstones let clients that synced before a delete remove their copy
+CREATE TABLE IF NOT EXISTS sync_tombstone(
+    id uuid NOT NULL,
+    entity text NOT NULL,
+    owner_id uuid NOT NULL,
+    deleted_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    sync_xid xid8 DEFAULT pg_current_xact_id() NOT NULL,
+    CONSTRAINT pk_sync_tombstone PRIMARY KEY(entity, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_tombstone_owner_sync_xid ON sync_tombstone(owner_id, sync_xid);
+
+CREATE OR REPLACE FUNCTION task_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO sync_tombstone(id, entity, owner_id) VALUES (OLD.id, 'task', OLD.created_by)
+        ON CONFLICT (entity, id) DO UPDATE SET deleted_at = EXCLUDED.deleted_at, sync_xid = EXCLUDED.sync_xid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- history entries are deleted before their task, so the owner can still be looked up
+CREATE OR REPLACE FUNCTION task_history_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO sync_tombstone(id, entity, owner_id)
+        SELECT OLD.id, 'task_history', t.created_by FROM task t WHERE t.id = OLD.task_id
+        ON CONFLICT (entity, id) DO UPDATE SET deleted_at = EXCLUDED.deleted_at, sync_xid = EXCLUDED.sync_xid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER task_tombstone AFTER DELETE ON task
+    FOR EACH ROW EXECUTE FUNCTION task_tombstone();
+CREATE TRIGGER task_history_tombstone AFTER DELETE ON task_history
+    FOR EACH ROW EXECUTE FUNCTION task_history_tombstone();
@@ -0,0 +1,16 @@
+-- responses of requests sent with an Idempotency-Key, replayed when the request is retried.
+-- status is null while the first request is still being processed
+CREATE TABLE IF NOT EXISTS idempotency_key(
+    user_id uuid NOT NULL,
+    key text NOT NULL,
+    fingerprint text NOT NULL,
+    status int,
+    headers jsonb,
+    body bytea,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at timestamp(0) WITH TIME ZONE NOT NULL,
+    CONSTRAINT pk_idempotency_key PRIMARY KEY(user_id, key),
+    CONSTRAINT fk_idempotency_key_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_expires_at ON idempotency_key(expires_at);
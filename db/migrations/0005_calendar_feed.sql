@@ -0,0 +1,8 @@
+-- the secret of a user's iCalendar feed URL, rotating it replaces the row so old URLs stop working
+CREATE TABLE IF NOT EXISTS calendar_feed(
+    user_id uuid NOT NULL,
+    token text NOT NULL UNIQUE,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT pk_calendar_feed PRIMARY KEY(user_id),
+    CONSTRAINT fk_calendar_feed_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
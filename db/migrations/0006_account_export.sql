@@ -0,0 +1,31 @@
+-- when a task was completed, for the journal of the account export. Entries created before this
+-- migration get the time their task was last updated, which is the completion unless it was edited later
+-- The version and sync triggers are disabled for the backfill, it doesn't change anything clients see
+ALTER TABLE task_history ADD COLUMN IF NOT EXISTS created_at timestamp(0) WITH TIME ZONE;
+ALTER TABLE task_history DISABLE TRIGGER USER;
+UPDATE task_history th SET created_at = t.updated_at FROM task t WHERE t.id = th.task_id AND th.created_at IS NULL;
+ALTER TABLE task_history ENABLE TRIGGER USER;
+ALTER TABLE task_history
+    ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP,
+    ALTER COLUMN created_at SET NOT NULL;
+
+-- account exports generated in the background, the ZIP is written to the export directory
+-- and downloaded with token until expires_at
+CREATE TABLE IF NOT EXISTS account_export(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id uuid NOT NULL,
+    status text DEFAULT 'pending' NOT NULL,
+    token text UNIQUE,
+    size bigint,
+    error text,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    started_at timestamp(0) WITH TIME ZONE,
+    completed_at timestamp(0) WITH TIME ZONE,
+    expires_at timestamp(0) WITH TIME ZONE,
+    CONSTRAINT pk_account_export_id PRIMARY KEY(id),
+    CONSTRAINT fk_account_export_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+
+-- a user has at most one export waiting or being generated
+CREATE UNIQUE INDEX IF NOT EXISTS idx_account_export_user_id_unfinished ON account_export(user_id) WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_account_export_status ON account_export(status, created_at);
@@ -0,0 +1,17 @@
+-- a user whose delete_after is set is deleted for good once it has passed, logging in before clears it
+ALTER TABLE "user"
+    ADD COLUMN IF NOT EXISTS deletion_requested_at timestamp(0) WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS delete_after timestamp(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_user_delete_after ON "user"(delete_after) WHERE delete_after IS NOT NULL;
+
+-- one row per deleted account, without anything that identifies the user
+CREATE TABLE IF NOT EXISTS account_deletion_audit(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    account_created_on date NOT NULL,
+    deletion_requested_at timestamp(0) WITH TIME ZONE NOT NULL,
+    deleted_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    task_count int NOT NULL,
+    task_history_count int NOT NULL,
+    CONSTRAINT pk_account_deletion_audit_id PRIMARY KEY(id)
+);
@@ -0,0 +1,90 @@
+-- shared task lists, a task without a list is personal and only accessible by its creator.
+-- Every list has exactly one owner, editors can change its tasks and viewers only read them
+CREATE TABLE IF NOT EXISTS task_list(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    list_name text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT pk_task_list_id PRIMARY KEY(id)
+);
+
+-- sync_xid is the transaction that added the member, GET /sync returns every task of a list
+-- joined since the client's sync token even though the tasks themselves didn't change
+CREATE TABLE IF NOT EXISTS task_list_member(
+    id uuid DEFAULT gen_random_uuid() NOT NULL UNIQUE,
+    list_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    role text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    sync_xid xid8 DEFAULT pg_current_xact_id() NOT NULL,
+    CONSTRAINT pk_task_list_member PRIMARY KEY(list_id, user_id),
+    CONSTRAINT fk_task_list_member_list_id FOREIGN KEY(list_id) REFERENCES task_list(id) ON DELETE CASCADE,
+    CONSTRAINT fk_task_list_member_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+    CONSTRAINT ck_task_list_member_role CHECK (role IN ('owner', 'editor', 'viewer'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_task_list_member_owner ON task_list_member(list_id) WHERE role = 'owner';
+CREATE INDEX IF NOT EXISTS idx_task_list_member_user_id ON task_list_member(user_id);
+
+-- invitations are sent to existing users (found by username or email), accepting one adds the member
+CREATE TABLE IF NOT EXISTS task_list_invitation(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    list_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    role text NOT NULL,
+    invited_by uuid NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT pk_task_list_invitation_id PRIMARY KEY(id),
+    CONSTRAINT uq_task_list_invitation UNIQUE(list_id, user_id),
+    CONSTRAINT fk_task_list_invitation_list_id FOREIGN KEY(list_id) REFERENCES task_list(id) ON DELETE CASCADE,
+    CONSTRAINT fk_task_list_invitation_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+    CONSTRAINT fk_task_list_invitation_invited_by FOREIGN KEY(invited_by) REFERENCES "user"(id) ON DELETE CASCADE,
+    CONSTRAINT ck_task_list_invitation_role CHECK (role IN ('editor', 'viewer'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_list_invitation_user_id ON task_list_invitation(user_id);
+
+-- a task stays in the list it was created in
+ALTER TABLE task ADD COLUMN IF NOT EXISTS list_id uuid;
+ALTER TABLE task ADD CONSTRAINT fk_task_list_id FOREIGN KEY(list_id) REFERENCES task_list(id);
+CREATE INDEX IF NOT EXISTS idx_task_list_id_sync_xid ON task(list_id, sync_xid) WHERE list_id IS NOT NULL;
+
+-- the member who completed the task, entries created before this migration were completed by the
+-- creator of their task. The version and sync triggers are disabled for the backfill like in 0006
+ALTER TABLE task_history ADD COLUMN IF NOT EXISTS completed_by uuid;
+ALTER TABLE task_history DISABLE TRIGGER USER;
+UPDATE task_history th SET completed_by = t.created_by FROM task t WHERE t.id = th.task_id AND th.completed_by IS NULL;
+ALTER TABLE task_history ENABLE TRIGGER USER;
+ALTER TABLE task_history ADD CONSTRAINT fk_task_history_completed_by FOREIGN KEY(completed_by) REFERENCES "user"(id) ON DELETE SET NULL;
+
+-- deletions of list tasks reach every member through list_id. A removed membership leaves a
+-- 'task_list_member' tombstone for the removed user, so their clients drop the list and its tasks
+ALTER TABLE sync_tombstone ADD COLUMN IF NOT EXISTS list_id uuid;
+CREATE INDEX IF NOT EXISTS idx_sync_tombstone_list_sync_xid ON sync_tombstone(list_id, sync_xid) WHERE list_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION task_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO sync_tombstone(id, entity, owner_id, list_id) VALUES (OLD.id, 'task', OLD.created_by, OLD.list_id)
+        ON CONFLICT (entity, id) DO UPDATE SET deleted_at = EXCLUDED.deleted_at, sync_xid = EXCLUDED.sync_xid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION task_history_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO sync_tombstone(id, entity, owner_id, list_id)
+        SELECT OLD.id, 'task_history', t.created_by, t.list_id FROM task t WHERE t.id = OLD.task_id
+        ON CONFLICT (entity, id) DO UPDATE SET deleted_at = EXCLUDED.deleted_at, sync_xid = EXCLUDED.sync_xid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION task_list_member_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO sync_tombstone(id, entity, owner_id, list_id) VALUES (OLD.id, 'task_list_member', OLD.user_id, OLD.list_id)
+        ON CONFLICT (entity, id) DO UPDATE SET deleted_at = EXCLUDED.deleted_at, sync_xid = EXCLUDED.sync_xid;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER task_list_member_tombstone AFTER DELETE ON task_list_member
+    FOR EACH ROW EXECUTE FUNCTION task_list_member_tombstone();
@@ -0,0 +1,5 @@
+-- the member responsible for a task, they can complete it even as a viewer of its list. The assignee
+-- of a personal task can only be its creator, the one of a list task has to be a member of the list
+ALTER TABLE task ADD COLUMN IF NOT EXISTS assigned_to uuid;
+ALTER TABLE task ADD CONSTRAINT fk_task_assigned_to FOREIGN KEY(assigned_to) REFERENCES "user"(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_task_assigned_to ON task(assigned_to) WHERE assigned_to IS NOT NULL;
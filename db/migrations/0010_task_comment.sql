@@ -0,0 +1,17 @@
+-- comments on tasks, everyone who can read the task can read and write them. Only the author edits or
+-- deletes a comment, comments are deleted with their task and with the account of their author
+CREATE TABLE IF NOT EXISTS task_comment(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    task_id uuid NOT NULL,
+    author_id uuid NOT NULL,
+    body text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT pk_task_comment_id PRIMARY KEY(id),
+    CONSTRAINT fk_task_comment_task_id FOREIGN KEY(task_id) REFERENCES task(id) ON DELETE CASCADE,
+    CONSTRAINT fk_task_comment_author_id FOREIGN KEY(author_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+
+-- comments are paged from the newest
+CREATE INDEX IF NOT EXISTS idx_task_comment_task_id ON task_comment(task_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_task_comment_author_id ON task_comment(author_id);
@@ -0,0 +1,40 @@
+-- notifications of mentions, invitations and assignments. In-app notifications are kept until the user
+-- deletes them, email notifications wait here until they are sent and are deleted afterwards.
+-- task_id is the task the notification is about, the notification is deleted with it. subject is the name
+-- of the task or list, the message is built when the notification is read so it shows the current username
+-- of the actor
+CREATE TABLE IF NOT EXISTS notification(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id uuid NOT NULL,
+    type text NOT NULL,
+    entity_id uuid NOT NULL,
+    task_id uuid,
+    actor_id uuid,
+    subject text NOT NULL,
+    channel text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    read_at timestamp(0) WITH TIME ZONE,
+    email_claimed_at timestamp(0) WITH TIME ZONE,
+    email_attempts int DEFAULT 0 NOT NULL,
+    CONSTRAINT pk_notification_id PRIMARY KEY(id),
+    CONSTRAINT fk_notification_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+    CONSTRAINT fk_notification_task_id FOREIGN KEY(task_id) REFERENCES task(id) ON DELETE CASCADE,
+    CONSTRAINT fk_notification_actor_id FOREIGN KEY(actor_id) REFERENCES "user"(id) ON DELETE SET NULL,
+    CONSTRAINT ck_notification_channel CHECK (channel IN ('in_app', 'email'))
+);
+
+-- the inbox lists unread notifications first, then from the newest
+CREATE INDEX IF NOT EXISTS idx_notification_inbox ON notification(user_id, (read_at IS NOT NULL), created_at DESC, id DESC) WHERE channel = 'in_app';
+CREATE INDEX IF NOT EXISTS idx_notification_email ON notification(created_at) WHERE channel = 'email';
+CREATE INDEX IF NOT EXISTS idx_notification_task_id ON notification(task_id) WHERE task_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_notification_actor_id ON notification(actor_id) WHERE actor_id IS NOT NULL;
+
+-- how the user receives every type of notification, types without a row are delivered in-app
+CREATE TABLE IF NOT EXISTS notification_preference(
+    user_id uuid NOT NULL,
+    type text NOT NULL,
+    channel text NOT NULL,
+    CONSTRAINT pk_notification_preference PRIMARY KEY(user_id, type),
+    CONSTRAINT fk_notification_preference_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE,
+    CONSTRAINT ck_notification_preference_channel CHECK (channel IN ('in_app', 'email', 'none'))
+);
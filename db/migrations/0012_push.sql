@@ -0,0 +1,49 @@
+-- Push API subscriptions, one per browser or device. endpoint is unique, a device that subscribes again
+-- (e.g. after another user logged in on it) takes over the existing row
+CREATE TABLE IF NOT EXISTS push_subscription(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    user_id uuid NOT NULL,
+    endpoint text NOT NULL,
+    p256dh text NOT NULL,
+    auth text NOT NULL,
+    device text,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT pk_push_subscription_id PRIMARY KEY(id),
+    CONSTRAINT uq_push_subscription_endpoint UNIQUE(endpoint),
+    CONSTRAINT fk_push_subscription_user_id FOREIGN KEY(user_id) REFERENCES "user"(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_subscription_user_id ON push_subscription(user_id);
+
+-- push messages waiting to be sent to a subscription, deleted once sent or dropped
+CREATE TABLE IF NOT EXISTS push_delivery(
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    subscription_id uuid NOT NULL,
+    payload text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    claimed_at timestamp(0) WITH TIME ZONE,
+    attempts int DEFAULT 0 NOT NULL,
+    CONSTRAINT pk_push_delivery_id PRIMARY KEY(id),
+    CONSTRAINT fk_push_delivery_subscription_id FOREIGN KEY(subscription_id) REFERENCES push_subscription(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_delivery_created_at ON push_delivery(created_at);
+CREATE INDEX IF NOT EXISTS idx_push_delivery_subscription_id ON push_delivery(subscription_id);
+
+-- the VAPID key generated when none is configured, shared by all instances
+CREATE TABLE IF NOT EXISTS push_vapid_key(
+    id int DEFAULT 1 NOT NULL,
+    private_key text NOT NULL,
+    created_at timestamp(0) WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CONSTRAINT pk_push_vapid_key_id PRIMARY KEY(id),
+    CONSTRAINT ck_push_vapid_key_single CHECK (id = 1)
+);
+
+-- the deadline the last due reminder of a task was sent for, a changed deadline is reminded again.
+-- It is kept apart from task so sending reminders doesn't change the version of tasks
+CREATE TABLE IF NOT EXISTS task_reminder(
+    task_id uuid NOT NULL,
+    deadline timestamp(0) WITH TIME ZONE NOT NULL,
+    CONSTRAINT pk_task_reminder_task_id PRIMARY KEY(task_id),
+    CONSTRAINT fk_task_reminder_task_id FOREIGN KEY(task_id) REFERENCES task(id) ON DELETE CASCADE
+);
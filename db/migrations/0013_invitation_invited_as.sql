@@ -0,0 +1,9 @@
+-- an invitation keeps the username or email the owner typed and is identified by it, that is what the
+-- owner sees. Invitations to someone without an account are stored without a user, so the owner can't
+-- find out whether an email address is registered. They are listed like the others but never delivered
+ALTER TABLE task_list_invitation ADD COLUMN IF NOT EXISTS invited_as text;
+UPDATE task_list_invitation tli SET invited_as = u.username FROM "user" u WHERE u.id = tli.user_id AND tli.invited_as IS NULL;
+ALTER TABLE task_list_invitation ALTER COLUMN invited_as SET NOT NULL;
+ALTER TABLE task_list_invitation ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE task_list_invitation DROP CONSTRAINT IF EXISTS uq_task_list_invitation;
+ALTER TABLE task_list_invitation ADD CONSTRAINT uq_task_list_invitation UNIQUE(list_id, invited_as);
@@ -0,0 +1,4 @@
+-- an account whose deletion failed is tried again later, so it doesn't block the other due accounts
+ALTER TABLE "user"
+    ADD COLUMN IF NOT EXISTS deletion_attempts int DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS deletion_failed_at timestamp(0) WITH TIME ZONE;
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,15 +21,26 @@ type TaskDB struct {
 	Exec_status string     `json:"execStatus"`
 	Created_at  time.Time  `json:"createdAt"`
 	Created_by  uuid.UUID  `json:"createdBy"`
+	Version     int64      `json:"version"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	// ListId is the shared list of the task, nil for personal tasks
+	ListId *uuid.UUID `json:"listId"`
+	// AssignedTo is the member responsible for the task, nil when it is unassigned
+	AssignedTo *uuid.UUID `json:"assignedTo"`
 }
 
 type TaskHistoryDB struct {
-	Id          uuid.UUID `json:"id"`
-	ExecRating  *int      `json:"execRating"`
-	ExecComment *string   `json:"execComment"`
-	TaskId      uuid.UUID `json:"taskId"`
-	TaskName    string    `json:"taskName"`
-	TaskIcon    string    `json:"taskIcon"`
+	Id          uuid.UUID  `json:"id"`
+	ExecRating  *int       `json:"execRating"`
+	ExecComment *string    `json:"execComment"`
+	TaskId      uuid.UUID  `json:"taskId"`
+	TaskName    string     `json:"taskName"`
+	TaskIcon    string     `json:"taskIcon"`
+	Version     int64      `json:"version"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	ListId      *uuid.UUID `json:"listId"`
+	// CompletedBy is the member who completed the task, nil when their account was deleted
+	CompletedBy *uuid.UUID `json:"completedBy"`
 }
 
 type TaskHistoryPut struct {
@@ -47,6 +60,8 @@ type AuthDB struct {
 	Id        uuid.UUID `json:"id"`
 	UserId    uuid.UUID `json:"userId"`
 	ExpiresAt time.Time `json:"expiresAt"`
+	// DeletionCancelled is set when logging in cancelled a scheduled deletion of the account
+	DeletionCancelled bool `json:"-"`
 }
 
 type Credentials struct {
@@ -64,6 +79,7 @@ type UserGet struct {
 	Username  string    `json:"username"`
 	Email     string    `json:"email"`
 	CreatedAt time.Time `json:"createdAt"`
+	Version   int64     `json:"version"`
 }
 
 type UserPut struct {
@@ -78,6 +94,10 @@ type TaskPost struct {
 	Deadline  *time.Time `json:"deadline"`
 	Starred   bool       `json:"starred"`
 	CreatedBy uuid.UUID  `json:"createdBy"`
+	// ListId adds the task to a shared list, the creator has to be its owner or an editor
+	ListId *uuid.UUID `json:"listId"`
+	// AssignedTo has to be a member of the list, or the creator for personal tasks
+	AssignedTo *uuid.UUID `json:"assignedTo"`
 }
 
 type TaskPut struct {
@@ -95,3 +115,406 @@ type Id struct {
 type Success struct {
 	Success bool `json:"success"`
 }
+
+// Patch models hold the fields of a JSON Merge Patch (RFC 7396) document.
+// Set tells whether the field was present in the document and Null whether it was explicitly null,
+// which clears nullable columns, so "not provided" and "clear the value" can be told apart
+
+type PatchField[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+func (f *PatchField[T]) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if string(data) == "null" {
+		f.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &f.Value)
+}
+
+type TaskPatch struct {
+	TaskName PatchField[string]    `json:"taskName"`
+	TaskIcon PatchField[string]    `json:"taskIcon"`
+	TaskDesc PatchField[string]    `json:"taskDesc"`
+	Deadline PatchField[time.Time] `json:"deadline"`
+	Starred  PatchField[bool]      `json:"starred"`
+	// AssignedTo assigns the task to a member of its list, null unassigns it
+	AssignedTo PatchField[uuid.UUID] `json:"assignedTo"`
+}
+
+type TaskHistoryPatch struct {
+	ExecRating  PatchField[int]    `json:"execRating"`
+	ExecComment PatchField[string] `json:"execComment"`
+}
+
+type UserPatch struct {
+	Username PatchField[string] `json:"username"`
+	Email    PatchField[string] `json:"email"`
+}
+
+func (patch TaskPatch) Validate() error {
+	if patch.TaskName.Null || patch.TaskIcon.Null || patch.TaskDesc.Null || patch.Starred.Null {
+		return errors.New("only deadline and assignedTo can be null")
+	}
+	return nil
+}
+
+func (patch TaskHistoryPatch) Validate() error {
+	if patch.ExecRating.Set && !patch.ExecRating.Null && (patch.ExecRating.Value < 1 || patch.ExecRating.Value > 3) {
+		return errors.New("rating must be between 1 and 3")
+	}
+	return nil
+}
+
+func (patch UserPatch) Validate() error {
+	if patch.Username.Null || patch.Email.Null {
+		return errors.New("username and email can't be null")
+	}
+	if (patch.Username.Set && patch.Username.Value == "") || (patch.Email.Set && patch.Email.Value == "") {
+		return errors.New("username and email can't be empty")
+	}
+	return nil
+}
+
+type SyncDeleted struct {
+	Tasks        []uuid.UUID `json:"tasks"`
+	TasksHistory []uuid.UUID `json:"tasksHistory"`
+	// Lists the user lost access to, clients drop their tasks and history entries
+	Lists []uuid.UUID `json:"lists"`
+}
+
+type SyncResponse struct {
+	Tasks        []TaskDB        `json:"tasks"`
+	TasksHistory []TaskHistoryDB `json:"tasksHistory"`
+	Deleted      SyncDeleted     `json:"deleted"`
+	Token        string          `json:"token"`
+}
+
+// SyncMutation is one offline edit replayed by POST /sync, ids are generated by the client
+// so a retried batch doesn't create duplicates
+type SyncMutation struct {
+	Op     string    `json:"op"`
+	Entity string    `json:"entity"`
+	Id     uuid.UUID `json:"id"`
+	// BaseVersion is the version the edit was made on, required for updates
+	BaseVersion *int64 `json:"baseVersion"`
+	// HistoryId is the id of the history entry created by completing a task
+	HistoryId *uuid.UUID `json:"historyId"`
+	// ListId is the shared list a created task is added to
+	ListId      *uuid.UUID      `json:"listId"`
+	Task        *TaskPut        `json:"task"`
+	TaskHistory *TaskHistoryPut `json:"taskHistory"`
+}
+
+type SyncBatch struct {
+	Mutations []SyncMutation `json:"mutations"`
+}
+
+const (
+	SyncApplied   = "applied"
+	SyncDuplicate = "duplicate"
+	SyncConflict  = "conflict"
+	SyncRejected  = "rejected"
+)
+
+type SyncMutationResult struct {
+	Id      uuid.UUID `json:"id"`
+	Status  string    `json:"status"`
+	Version *int64    `json:"version,omitempty"`
+	// Server is the server copy of the task or history entry, sent with conflicts and duplicates
+	Server any    `json:"server,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type SyncBatchResponse struct {
+	Results []SyncMutationResult `json:"results"`
+}
+
+type CalendarFeedDB struct {
+	Token     string
+	CreatedAt time.Time
+}
+
+type CalendarFeed struct {
+	Url       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ImportTask is a task read from an imported file, completed tasks are imported with a history entry
+type ImportTask struct {
+	// Row is the line (or entry) of the file the task was read from
+	Row         int        `json:"-"`
+	TaskName    string     `json:"taskName"`
+	TaskIcon    string     `json:"taskIcon"`
+	TaskDesc    string     `json:"taskDesc"`
+	Deadline    *time.Time `json:"deadline"`
+	Starred     bool       `json:"starred"`
+	Completed   bool       `json:"completed"`
+	ExecRating  *int       `json:"execRating"`
+	ExecComment *string    `json:"execComment"`
+}
+
+const (
+	ImportCreated   = "created"
+	ImportDuplicate = "duplicate"
+	ImportInvalid   = "invalid"
+)
+
+type ImportRowResult struct {
+	Row    int         `json:"row"`
+	Status string      `json:"status"`
+	Task   *ImportTask `json:"task,omitempty"`
+	TaskId *uuid.UUID  `json:"taskId,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	DryRun     bool              `json:"dryRun"`
+	Created    int               `json:"created"`
+	Duplicates int               `json:"duplicates"`
+	Invalid    int               `json:"invalid"`
+	Rows       []ImportRowResult `json:"rows"`
+}
+
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	// ExportExpired is only reported, expired exports are deleted by a background job
+	ExportExpired = "expired"
+)
+
+type ExportDB struct {
+	Id          uuid.UUID
+	UserId      uuid.UUID
+	Status      string
+	Token       *string
+	Size        *int64
+	Error       *string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+}
+
+type AccountExport struct {
+	Id          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	Size        *int64     `json:"size"`
+	// Url is the download link of a ready export
+	Url   string `json:"url,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type ExportProfile struct {
+	Id uuid.UUID `json:"id"`
+	UserGet
+}
+
+type ExportTaskHistory struct {
+	TaskHistoryDB
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// ExportList is a list the user is a member of, with its members
+type ExportList struct {
+	TaskListDB
+	Members []TaskListMember `json:"members"`
+}
+
+// ExportData is everything an account export contains except the profile icon
+type ExportData struct {
+	Profile ExportProfile
+	// Tasks the user created, is assigned to or completed (in shared lists)
+	Tasks []TaskDB
+	// TasksHistory has the completions of the tasks the user created and the completions by the user
+	TasksHistory []ExportTaskHistory
+	Lists        []ExportList
+	// Comments on the tasks of the user and the comments the user wrote on other tasks
+	Comments      []TaskComment
+	Notifications []NotificationDB
+}
+
+type AccountDeletion struct {
+	Password string `json:"password"`
+}
+
+type AccountDeletionScheduled struct {
+	DeleteAfter time.Time `json:"deleteAfter"`
+}
+
+// DeletedAccount is an account deleted for good, its files are removed after the database rows
+type DeletedAccount struct {
+	UserId    uuid.UUID
+	ExportIds []uuid.UUID
+}
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// TaskListDB is a shared list together with the role of the user who requested it
+type TaskListDB struct {
+	Id        uuid.UUID `json:"id"`
+	ListName  string    `json:"listName"`
+	CreatedAt time.Time `json:"createdAt"`
+	Role      string    `json:"role"`
+}
+
+type TaskListPost struct {
+	ListName string `json:"listName"`
+}
+
+type TaskListMember struct {
+	UserId   uuid.UUID `json:"userId"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+type TaskListMemberPut struct {
+	Role string `json:"role"`
+}
+
+type TaskListInvitation struct {
+	Id       uuid.UUID `json:"id"`
+	ListId   uuid.UUID `json:"listId"`
+	ListName string    `json:"listName"`
+	// Username is the username or email the user was invited with
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	InvitedBy string    `json:"invitedBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type TaskListInvitationPost struct {
+	// User is the username of the invited user, or their email when it contains an @
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+type TaskComment struct {
+	Id       uuid.UUID `json:"id"`
+	TaskId   uuid.UUID `json:"taskId"`
+	AuthorId uuid.UUID `json:"authorId"`
+	// Author is the username of the author
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type TaskCommentPost struct {
+	Body string `json:"body"`
+}
+
+// TaskCommentPage is a page of the comments of a task from the newest, Before is passed as before
+// to get the next page and is nil on the last one
+type TaskCommentPage struct {
+	Comments []TaskComment `json:"comments"`
+	Before   *uuid.UUID    `json:"before"`
+}
+
+// TaskWithComments is a task with the newest page of its comments
+type TaskWithComments struct {
+	TaskDB
+	Comments TaskCommentPage `json:"comments"`
+}
+
+// Notification is a notification to be delivered to UserId by the Notifier. EntityId is the comment,
+// invitation or task it is about and TaskId the task, if any. ActorId is nil for reminders
+type Notification struct {
+	UserId   uuid.UUID
+	Type     string
+	EntityId uuid.UUID
+	TaskId   *uuid.UUID
+	ActorId  *uuid.UUID
+	// Subject is the name of the task or list, used in the message
+	Subject string
+}
+
+type NotificationDB struct {
+	Id       uuid.UUID  `json:"id"`
+	Type     string     `json:"type"`
+	EntityId uuid.UUID  `json:"entityId"`
+	TaskId   *uuid.UUID `json:"taskId"`
+	ActorId  *uuid.UUID `json:"actorId"`
+	// Actor is the username of the user who caused the notification, nil when their account was deleted
+	Actor     *string    `json:"actor"`
+	Message   string     `json:"message"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ReadAt    *time.Time `json:"readAt"`
+}
+
+// NotificationPage is a page of the inbox, unread notifications first and then from the newest.
+// Before is passed as before to get the next page and is nil on the last one
+type NotificationPage struct {
+	Notifications []NotificationDB `json:"notifications"`
+	Before        *uuid.UUID       `json:"before"`
+	Unread        int              `json:"unread"`
+}
+
+// NotificationPreferences maps every type of notification to the channel it is delivered on
+type NotificationPreferences map[string]string
+
+// EmailNotification is a notification claimed to be sent by email
+type EmailNotification struct {
+	Id       uuid.UUID
+	Email    string
+	Message  string
+	Attempts int
+}
+
+type PushSubscriptionKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// PushSubscriptionPost is the JSON of a browser's PushSubscription
+type PushSubscriptionPost struct {
+	Endpoint string               `json:"endpoint"`
+	Keys     PushSubscriptionKeys `json:"keys"`
+}
+
+type PushSubscription struct {
+	Id       uuid.UUID `json:"id"`
+	Endpoint string    `json:"endpoint"`
+	// Device is the User-Agent of the browser that subscribed
+	Device    *string   `json:"device"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// PushDelivery is a push message claimed to be sent to a subscription
+type PushDelivery struct {
+	Id             uuid.UUID
+	SubscriptionId uuid.UUID
+	Endpoint       string
+	P256dh         string
+	Auth           string
+	Payload        string
+	CreatedAt      time.Time
+	Attempts       int
+}
+
+// AssignmentFilter limits tasks to the ones assigned to the user, created by the user or without an
+// assignee, the zero value matches every task
+type AssignmentFilter struct {
+	AssignedToMe bool
+	CreatedByMe  bool
+	Unassigned   bool
+}
+
+// ListFilter limits tasks and history entries to one list (ListId) or to the personal ones (Personal),
+// the zero value matches everything the user can read
+type ListFilter struct {
+	ListId   *uuid.UUID
+	Personal bool
+}
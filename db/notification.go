@@ -0,0 +1,410 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Types of notifications
+const (
+	NotificationMention    = "mention"
+	NotificationInvitation = "invitation"
+	NotificationAssignment = "assignment"
+	// NotificationReminder is sent to the assignee of a task (its creator when it is unassigned) before its deadline
+	NotificationReminder = "reminder"
+)
+
+var NotificationTypes = []string{NotificationMention, NotificationInvitation, NotificationAssignment, NotificationReminder}
+
+// Channels notifications are delivered on, ChannelNone turns a type of notification off
+const (
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+	ChannelNone  = "none"
+)
+
+// MaxNotificationPageSize limits the number of notifications returned at once
+const MaxNotificationPageSize = 100
+
+// MaxEmailAttempts is how many times sending an email notification is tried before it is dropped
+const MaxEmailAttempts = 5
+
+// emailRetryAfter is how long a claimed email notification waits before it is claimed again, either
+// because sending it failed or because the instance sending it was killed
+const emailRetryAfter = 5 * time.Minute
+
+var ErrNotificationNotFound = errors.New("notification doesn't exist")
+var ErrInvalidPreferences = errors.New("preferences must map mention, invitation, assignment or reminder to in_app, email or none")
+var ErrEmailUnavailable = errors.New("email notifications aren't available on this server")
+
+// Notifier delivers notifications to users, every subsystem notifies through it. Delivering is best effort,
+// the change the notification is about is already saved, so errors are only logged
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification)
+}
+
+// notificationMessage is the text of the notification shown to the user, actor is nil when their account
+// was deleted
+func notificationMessage(notificationType string, actor *string, subject string) string {
+	name := "Someone"
+	if actor != nil {
+		name = *actor
+	}
+	switch notificationType {
+	case NotificationMention:
+		return name + " mentioned you in a comment on " + subject
+	case NotificationInvitation:
+		return name + " invited you to the list " + subject
+	case NotificationAssignment:
+		return name + " assigned " + subject + " to you"
+	case NotificationReminder:
+		return subject + " is due soon"
+	}
+	return subject
+}
+
+// Notify delivers the notification on the channel the user chose for its type. Email notifications are
+// delivered in-app when email isn't configured, in-app notifications are also pushed to the devices of the
+// user when push is configured
+func (dbService *DatabaseService) Notify(ctx context.Context, notification Notification) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	err := dbService.notify(ctx, notification)
+	if err != nil {
+		slog.WarnContext(ctx, "error while notifying user", "user_id", notification.UserId, "type", notification.Type, "error", err)
+	}
+}
+
+func (dbService *DatabaseService) notify(ctx context.Context, notification Notification) error {
+	var channel string
+	err := dbService.pool.QueryRow(
+		ctx,
+		"SELECT COALESCE((SELECT np.channel FROM notification_preference np WHERE np.user_id = $1 AND np.type = $2), 'in_app')",
+		notification.UserId,
+		notification.Type,
+	).Scan(&channel)
+	if err != nil {
+		return err
+	}
+	if channel == ChannelNone {
+		return nil
+	}
+	if channel == ChannelEmail && !dbService.emailNotifications {
+		channel = ChannelInApp
+	}
+
+	if channel == ChannelEmail {
+		_, err = dbService.pool.Exec(
+			ctx,
+			`INSERT INTO notification(user_id, type, entity_id, task_id, actor_id, subject, channel)
+			VALUES ($1, $2, $3, $4, $5, $6, 'email')`,
+			notification.UserId,
+			notification.Type,
+			notification.EntityId,
+			notification.TaskId,
+			notification.ActorId,
+			notification.Subject,
+		)
+		return err
+	}
+
+	rows, err := dbService.pool.Query(
+		ctx,
+		`WITH n AS (
+			INSERT INTO notification(user_id, type, entity_id, task_id, actor_id, subject, channel)
+			VALUES ($1, $2, $3, $4, $5, $6, 'in_app')
+			RETURNING *
+		)
+		SELECT `+notificationColumns+` FROM n LEFT JOIN "user" actor ON actor.id = n.actor_id`,
+		notification.UserId,
+		notification.Type,
+		notification.EntityId,
+		notification.TaskId,
+		notification.ActorId,
+		notification.Subject,
+	)
+	if err != nil {
+		return err
+	}
+	created, err := pgx.CollectExactlyOneRow(rows, scanNotification)
+	if err != nil {
+		return err
+	}
+	dbService.publish(notification.UserId, events.NotificationCreated, created.Id)
+	if dbService.pushNotifications {
+		return dbService.queuePush(ctx, notification.UserId, created)
+	}
+	return nil
+}
+
+const notificationColumns = "n.id, n.type, n.entity_id, n.task_id, n.actor_id, actor.username, n.subject, n.created_at, n.read_at"
+
+const notificationJoins = " FROM notification n LEFT JOIN \"user\" actor ON actor.id = n.actor_id"
+
+func scanNotification(row pgx.CollectableRow) (NotificationDB, error) {
+	var notification NotificationDB
+	var subject string
+	err := row.Scan(
+		&notification.Id,
+		&notification.Type,
+		&notification.EntityId,
+		&notification.TaskId,
+		&notification.ActorId,
+		&notification.Actor,
+		&subject,
+		&notification.CreatedAt,
+		&notification.ReadAt,
+	)
+	notification.Message = notificationMessage(notification.Type, notification.Actor, subject)
+	return notification, err
+}
+
+func collectNotifications(rows pgx.Rows) ([]NotificationDB, error) {
+	return pgx.CollectRows(rows, scanNotification)
+}
+
+// GetNotifications returns a page of the inbox of the user, unread notifications first and then from the
+// newest. before is the id of the last notification of the previous page
+func (dbService *DatabaseService) GetNotifications(ctx context.Context, userId uuid.UUID, limit int, before *uuid.UUID) (*NotificationPage, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	// one more notification is read to know whether there is a next page
+	rows, err := dbService.pool.Query(
+		ctx,
+		"SELECT "+notificationColumns+notificationJoins+` WHERE n.user_id = $1 AND n.channel = 'in_app'
+		AND ($2::uuid IS NULL OR EXISTS (SELECT 1 FROM notification b WHERE b.id = $2 AND b.user_id = $1 AND (
+			(n.read_at IS NOT NULL) > (b.read_at IS NOT NULL)
+			OR ((n.read_at IS NOT NULL) = (b.read_at IS NOT NULL) AND (n.created_at, n.id) < (b.created_at, b.id)))))
+		ORDER BY (n.read_at IS NOT NULL), n.created_at DESC, n.id DESC LIMIT $3`,
+		userId,
+		before,
+		limit+1,
+	)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting notifications")
+	}
+	notifications, err := collectNotifications(rows)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+	page := NotificationPage{Notifications: notifications}
+	if len(notifications) > limit {
+		page.Notifications = notifications[:limit]
+		page.Before = &notifications[limit-1].Id
+	}
+	if page.Notifications == nil {
+		page.Notifications = []NotificationDB{}
+	}
+
+	err = dbService.pool.QueryRow(ctx, "SELECT count(*) FROM notification n WHERE n.user_id = $1 AND n.channel = 'in_app' AND n.read_at IS NULL", userId).Scan(&page.Unread)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting notifications")
+	}
+	return &page, nil
+}
+
+// MarkNotificationRead marks a notification of the user as read, marking it again keeps the first read time
+func (dbService *DatabaseService) MarkNotificationRead(ctx context.Context, notificationId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(
+		ctx,
+		"UPDATE notification n SET read_at = COALESCE(n.read_at, CURRENT_TIMESTAMP) WHERE n.id = $1 AND n.user_id = $2 AND n.channel = 'in_app'",
+		notificationId,
+		userId,
+	)
+	if err != nil {
+		return queryError(ctx, err, "error while marking notification as read")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of the user as read and returns how many there were
+func (dbService *DatabaseService) MarkAllNotificationsRead(ctx context.Context, userId uuid.UUID) (int64, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(
+		ctx,
+		"UPDATE notification n SET read_at = CURRENT_TIMESTAMP WHERE n.user_id = $1 AND n.channel = 'in_app' AND n.read_at IS NULL",
+		userId,
+	)
+	if err != nil {
+		return 0, queryError(ctx, err, "error while marking notifications as read")
+	}
+	return cmdTag.RowsAffected(), nil
+}
+
+func (dbService *DatabaseService) DeleteNotification(ctx context.Context, notificationId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(ctx, "DELETE FROM notification n WHERE n.id = $1 AND n.user_id = $2 AND n.channel = 'in_app'", notificationId, userId)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting notification")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// GetNotificationPreferences returns the channel of every type of notification, types the user never
+// changed are delivered in-app
+func (dbService *DatabaseService) GetNotificationPreferences(ctx context.Context, userId uuid.UUID) (NotificationPreferences, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	preferences := NotificationPreferences{}
+	for _, notificationType := range NotificationTypes {
+		preferences[notificationType] = ChannelInApp
+	}
+	rows, err := dbService.pool.Query(ctx, "SELECT np.type, np.channel FROM notification_preference np WHERE np.user_id = $1", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting notification preferences")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var notificationType, channel string
+		if err := rows.Scan(&notificationType, &channel); err != nil {
+			return nil, queryError(ctx, err, "error while getting notification preferences")
+		}
+		preferences[notificationType] = channel
+	}
+	if rows.Err() != nil {
+		return nil, queryError(ctx, rows.Err(), "error while getting notification preferences")
+	}
+	return preferences, nil
+}
+
+// UpdateNotificationPreferences changes the channels of the types of notifications in preferences, the
+// other types are left as they are. The preferences after the change are returned
+func (dbService *DatabaseService) UpdateNotificationPreferences(ctx context.Context, userId uuid.UUID, preferences NotificationPreferences) (NotificationPreferences, error) {
+	var types, channels []string
+	for notificationType, channel := range preferences {
+		if !slices.Contains(NotificationTypes, notificationType) || (channel != ChannelInApp && channel != ChannelEmail && channel != ChannelNone) {
+			return nil, ErrInvalidPreferences
+		}
+		if channel == ChannelEmail && !dbService.emailNotifications {
+			return nil, ErrEmailUnavailable
+		}
+		types = append(types, notificationType)
+		channels = append(channels, channel)
+	}
+
+	if len(types) > 0 {
+		queryCtx, cancel := dbService.withTimeout(ctx)
+		defer cancel()
+
+		_, err := dbService.pool.Exec(
+			queryCtx,
+			`INSERT INTO notification_preference(user_id, type, channel)
+			SELECT $1, p.type, p.channel FROM unnest($2::text[], $3::text[]) AS p(type, channel)
+			ON CONFLICT (user_id, type) DO UPDATE SET channel = EXCLUDED.channel`,
+			userId,
+			types,
+			channels,
+		)
+		if err != nil {
+			return nil, queryError(queryCtx, err, "error while updating notification preferences")
+		}
+	}
+	return dbService.GetNotificationPreferences(ctx, userId)
+}
+
+// ClaimEmailNotification claims the oldest email notification waiting to be sent and returns it, nil when
+// there is none. Notifications whose sending failed are claimed again after emailRetryAfter
+func (dbService *DatabaseService) ClaimEmailNotification(ctx context.Context) (*EmailNotification, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var notification EmailNotification
+	var notificationType, subject string
+	var actor *string
+	err := dbService.pool.QueryRow(
+		ctx,
+		`UPDATE notification n SET email_claimed_at = CURRENT_TIMESTAMP, email_attempts = n.email_attempts + 1
+		FROM "user" u
+		WHERE n.id = (
+			SELECT id FROM notification
+			WHERE channel = 'email' AND (email_claimed_at IS NULL OR email_claimed_at <= $1)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) AND u.id = n.user_id
+		RETURNING n.id, n.type, u.email, (SELECT actor.username FROM "user" actor WHERE actor.id = n.actor_id), n.subject, n.email_attempts`,
+		time.Now().Add(-emailRetryAfter),
+	).Scan(&notification.Id, &notificationType, &notification.Email, &actor, &subject, &notification.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, queryError(ctx, err, "error while claiming email notification")
+	}
+	notification.Message = notificationMessage(notificationType, actor, subject)
+	return &notification, nil
+}
+
+// CompleteEmailNotification deletes an email notification that was sent or won't be tried again
+func (dbService *DatabaseService) CompleteEmailNotification(ctx context.Context, notificationId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(ctx, "DELETE FROM notification WHERE id = $1 AND channel = 'email'", notificationId)
+	if err != nil {
+		return queryError(ctx, err, "error while completing email notification")
+	}
+	return nil
+}
+
+// SendDueReminders notifies the assignees of active tasks due within lead, the creator when a task is
+// unassigned, and returns how many were reminded. Every deadline of a task is reminded once, even
+// with several instances running
+func (dbService *DatabaseService) SendDueReminders(ctx context.Context, lead time.Duration) (int, error) {
+	queryCtx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := dbService.pool.Query(
+		queryCtx,
+		`WITH due AS (
+			SELECT t.id, t.deadline, t.task_name, COALESCE(t.assigned_to, t.created_by) AS user_id FROM task t
+			WHERE t.exec_status = 'ACTIVE' AND t.deadline > CURRENT_TIMESTAMP AND t.deadline <= $1
+			AND NOT EXISTS (SELECT 1 FROM task_reminder tr WHERE tr.task_id = t.id AND tr.deadline = t.deadline)
+		), reminded AS (
+			INSERT INTO task_reminder(task_id, deadline) SELECT id, deadline FROM due
+			ON CONFLICT (task_id) DO UPDATE SET deadline = EXCLUDED.deadline WHERE task_reminder.deadline <> EXCLUDED.deadline
+			RETURNING task_id
+		)
+		SELECT due.id, due.task_name, due.user_id FROM due JOIN reminded ON reminded.task_id = due.id`,
+		time.Now().Add(lead),
+	)
+	if err != nil {
+		return 0, queryError(queryCtx, err, "error while reminding due tasks")
+	}
+	reminders, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (Notification, error) {
+		notification := Notification{Type: NotificationReminder}
+		err := row.Scan(&notification.EntityId, &notification.Subject, &notification.UserId)
+		notification.TaskId = &notification.EntityId
+		return notification, err
+	})
+	if err != nil {
+		return 0, queryError(queryCtx, err, "error while reminding due tasks")
+	}
+	for _, reminder := range reminders {
+		dbService.notifier.Notify(ctx, reminder)
+	}
+	return len(reminders), nil
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// MaxPushAttempts is how many times sending a push message is tried before it is dropped
+const MaxPushAttempts = 5
+
+// pushRetryAfter is how long a claimed push message waits before it is claimed again, either because
+// sending it failed or because the instance sending it was killed
+const pushRetryAfter = time.Minute
+
+const maxPushEndpointLength = 2048
+
+// MaxPushPayloadSize is the largest push message that still fits the 4096 byte body push services accept
+// once it is encrypted, push.MaxPayloadSize
+const MaxPushPayloadSize = 3993
+
+var ErrPushUnavailable = errors.New("push notifications aren't available on this server")
+var ErrInvalidPushSubscription = errors.New("subscription must have an https endpoint and the p256dh and auth keys of the browser")
+var ErrPushSubscriptionNotFound = errors.New("push subscription doesn't exist")
+var ErrPushSubscriptionTaken = errors.New("push endpoint is subscribed by another user, subscribe the browser again to get a new endpoint")
+
+// DecodePushKey decodes a key of a push subscription, browsers encode them as unpadded base64url
+// but some clients add the padding
+func DecodePushKey(key string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "="))
+}
+
+func (dbService *DatabaseService) validatePushSubscription(subscription PushSubscriptionPost) error {
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Host == "" || len(subscription.Endpoint) > maxPushEndpointLength {
+		return ErrInvalidPushSubscription
+	}
+	if endpoint.Scheme != "https" && (endpoint.Scheme != "http" || !dbService.pushAllowHTTP) {
+		return ErrInvalidPushSubscription
+	}
+	// p256dh is the uncompressed P-256 public key of the browser, auth a 16 byte secret
+	p256dh, err := DecodePushKey(subscription.Keys.P256dh)
+	if err != nil {
+		return ErrInvalidPushSubscription
+	}
+	if _, err := ecdh.P256().NewPublicKey(p256dh); err != nil {
+		return ErrInvalidPushSubscription
+	}
+	auth, err := DecodePushKey(subscription.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return ErrInvalidPushSubscription
+	}
+	return nil
+}
+
+const pushSubscriptionColumns = "ps.id, ps.endpoint, ps.device, ps.created_at"
+
+func scanPushSubscription(row pgx.CollectableRow) (PushSubscription, error) {
+	var subscription PushSubscription
+	err := row.Scan(&subscription.Id, &subscription.Endpoint, &subscription.Device, &subscription.CreatedAt)
+	return subscription, err
+}
+
+// CreatePushSubscription saves the push subscription of a device of the user. Subscribing an endpoint
+// again updates its keys, an endpoint subscribed by another user isn't taken over because nothing proves
+// the browser belongs to the user
+func (dbService *DatabaseService) CreatePushSubscription(ctx context.Context, userId uuid.UUID, subscription PushSubscriptionPost, device string) (*PushSubscription, error) {
+	if !dbService.pushNotifications {
+		return nil, ErrPushUnavailable
+	}
+	if err := dbService.validatePushSubscription(subscription); err != nil {
+		return nil, err
+	}
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var deviceValue *string
+	if device != "" {
+		deviceValue = &device
+	}
+	rows, err := dbService.pool.Query(
+		ctx,
+		`INSERT INTO push_subscription AS ps(user_id, endpoint, p256dh, auth, device) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (endpoint) DO UPDATE SET p256dh = EXCLUDED.p256dh, auth = EXCLUDED.auth, device = EXCLUDED.device
+		WHERE ps.user_id = EXCLUDED.user_id
+		RETURNING `+pushSubscriptionColumns,
+		userId,
+		subscription.Endpoint,
+		subscription.Keys.P256dh,
+		subscription.Keys.Auth,
+		deviceValue,
+	)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating push subscription")
+	}
+	created, err := pgx.CollectExactlyOneRow(rows, scanPushSubscription)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPushSubscriptionTaken
+	}
+	if err != nil {
+		return nil, queryError(ctx, err, "error while creating push subscription")
+	}
+	return &created, nil
+}
+
+func (dbService *DatabaseService) GetPushSubscriptions(ctx context.Context, userId uuid.UUID) ([]PushSubscription, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := dbService.pool.Query(ctx, "SELECT "+pushSubscriptionColumns+" FROM push_subscription ps WHERE ps.user_id = $1 ORDER BY ps.created_at, ps.id", userId)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting push subscriptions")
+	}
+	subscriptions, err := pgx.CollectRows(rows, scanPushSubscription)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+	if subscriptions == nil {
+		subscriptions = []PushSubscription{}
+	}
+	return subscriptions, nil
+}
+
+// DeletePushSubscription unsubscribes a device of the user, its waiting push messages are dropped
+func (dbService *DatabaseService) DeletePushSubscription(ctx context.Context, subscriptionId uuid.UUID, userId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(ctx, "DELETE FROM push_subscription ps WHERE ps.id = $1 AND ps.user_id = $2", subscriptionId, userId)
+	if err != nil {
+		return queryError(ctx, err, "error while deleting push subscription")
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return ErrPushSubscriptionNotFound
+	}
+	return nil
+}
+
+// RemovePushSubscription deletes a subscription the push service reported as expired or unsubscribed
+func (dbService *DatabaseService) RemovePushSubscription(ctx context.Context, subscriptionId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(ctx, "DELETE FROM push_subscription WHERE id = $1", subscriptionId)
+	if err != nil {
+		return queryError(ctx, err, "error while removing push subscription")
+	}
+	return nil
+}
+
+// pushPayload encodes the notification as a push message of at most MaxPushPayloadSize bytes, a message
+// that is too long (e.g. because of a long task name) is shortened
+func pushPayload(notification NotificationDB) ([]byte, error) {
+	for {
+		payload, err := json.Marshal(notification)
+		if err != nil || len(payload) <= MaxPushPayloadSize {
+			return payload, err
+		}
+		if notification.Message == "" {
+			// the client can still get the notification by its id
+			return json.Marshal(map[string]any{"id": notification.Id, "type": notification.Type})
+		}
+		notification.Message = shortenMessage(notification.Message, len(payload)-MaxPushPayloadSize)
+	}
+}
+
+// shortenMessage cuts at least excess bytes off the end of the message without splitting a character.
+// The encoded message is at least as long as the message, so its encoding shrinks by at least as much
+func shortenMessage(message string, excess int) string {
+	const ellipsis = "…"
+	cut := len(message) - excess - len(ellipsis)
+	if cut <= 0 {
+		return ""
+	}
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return message[:cut] + ellipsis
+}
+
+// queuePush queues the notification to be pushed to every subscription of the user
+func (dbService *DatabaseService) queuePush(ctx context.Context, userId uuid.UUID, notification NotificationDB) error {
+	payload, err := pushPayload(notification)
+	if err != nil {
+		return err
+	}
+	_, err = dbService.pool.Exec(
+		ctx,
+		"INSERT INTO push_delivery(subscription_id, payload) SELECT ps.id, $2 FROM push_subscription ps WHERE ps.user_id = $1",
+		userId,
+		string(payload),
+	)
+	return err
+}
+
+// ClaimPushDelivery claims the oldest push message waiting to be sent and returns it with its subscription,
+// nil when there is none. Messages whose sending failed are claimed again after pushRetryAfter
+func (dbService *DatabaseService) ClaimPushDelivery(ctx context.Context) (*PushDelivery, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	var delivery PushDelivery
+	err := dbService.pool.QueryRow(
+		ctx,
+		`UPDATE push_delivery pd SET claimed_at = CURRENT_TIMESTAMP, attempts = pd.attempts + 1
+		FROM push_subscription ps
+		WHERE pd.id = (
+			SELECT id FROM push_delivery
+			WHERE claimed_at IS NULL OR claimed_at <= $1
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) AND ps.id = pd.subscription_id
+		RETURNING pd.id, pd.subscription_id, ps.endpoint, ps.p256dh, ps.auth, pd.payload, pd.created_at, pd.attempts`,
+		time.Now().Add(-pushRetryAfter),
+	).Scan(
+		&delivery.Id,
+		&delivery.SubscriptionId,
+		&delivery.Endpoint,
+		&delivery.P256dh,
+		&delivery.Auth,
+		&delivery.Payload,
+		&delivery.CreatedAt,
+		&delivery.Attempts,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, queryError(ctx, err, "error while claiming push delivery")
+	}
+	return &delivery, nil
+}
+
+// CompletePushDelivery deletes a push message that was sent or won't be tried again
+func (dbService *DatabaseService) CompletePushDelivery(ctx context.Context, deliveryId uuid.UUID) error {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(ctx, "DELETE FROM push_delivery WHERE id = $1", deliveryId)
+	if err != nil {
+		return queryError(ctx, err, "error while completing push delivery")
+	}
+	return nil
+}
+
+// VAPIDPrivateKey returns the VAPID private key shared by all instances. The first instance to start
+// stores generated, the others get the stored key
+func (dbService *DatabaseService) VAPIDPrivateKey(ctx context.Context, generated string) (string, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	_, err := dbService.pool.Exec(ctx, "INSERT INTO push_vapid_key(id, private_key) VALUES (1, $1) ON CONFLICT (id) DO NOTHING", generated)
+	if err != nil {
+		return "", queryError(ctx, err, "error while storing vapid key")
+	}
+	var privateKey string
+	err = dbService.pool.QueryRow(ctx, "SELECT private_key FROM push_vapid_key WHERE id = 1").Scan(&privateKey)
+	if err != nil {
+		return "", queryError(ctx, err, "error while reading vapid key")
+	}
+	return privateKey, nil
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+func TestPushPayload(t *testing.T) {
+	actor := "alice"
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"short", "alice mentioned you in Water plants"},
+		{"long task name", "alice assigned you " + strings.Repeat("Water plants ", 400)},
+		{"multi-byte characters", "alice assigned you " + strings.Repeat("Zalij biljke ✿ ", 400)},
+		{"escaped characters", "alice assigned you " + strings.Repeat("<\"\n>", 1000)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := pushPayload(NotificationDB{Id: uuid.New(), Type: NotificationAssignment, Actor: &actor, Message: test.message})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(payload) > MaxPushPayloadSize {
+				t.Errorf("expected at most %d bytes, got %d", MaxPushPayloadSize, len(payload))
+			}
+			var decoded NotificationDB
+			if err := json.Unmarshal(payload, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !utf8.ValidString(decoded.Message) {
+				t.Errorf("message was cut inside a character: %q", decoded.Message)
+			}
+			if len(test.message) < MaxPushPayloadSize/2 && decoded.Message != test.message {
+				t.Errorf("expected the message to be kept, got %q", decoded.Message)
+			}
+		})
+	}
+}
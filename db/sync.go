@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// SyncToken marks the changes a client has already received. Xmin is the oldest transaction that was
+// still running when the token was issued: the writes of every earlier transaction were returned with
+// the token, the writes of later ones may not have been, so they are returned again by the next sync.
+// Clients apply changes idempotently, so a change returned twice does no harm but none is ever missed,
+// no matter how writes interleave with the sync
+type SyncToken struct {
+	Xmin     uint64
+	IssuedAt time.Time
+}
+
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// ErrSyncTokenExpired is returned for tokens older than the tombstone retention, deletions made since
+// then may already be forgotten so the client has to do a full sync
+var ErrSyncTokenExpired = errors.New("sync token expired, a full sync is required")
+
+func ParseSyncToken(token string) (*SyncToken, error) {
+	xmin, issuedAt, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidSyncToken
+	}
+	xminValue, err := strconv.ParseUint(xmin, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSyncToken
+	}
+	issuedAtValue, err := strconv.ParseInt(issuedAt, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSyncToken
+	}
+	return &SyncToken{Xmin: xminValue, IssuedAt: time.Unix(issuedAtValue, 0)}, nil
+}
+
+func (token SyncToken) String() string {
+	return fmt.Sprintf("%d.%d", token.Xmin, token.IssuedAt.Unix())
+}
+
+// joinedSince is the condition under which the task t is in a list the user joined since the token
+const joinedSince = "t.list_id IN (SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = @userId AND tlm.sync_xid >= @since::text::xid8)"
+
+// Sync returns the tasks and history entries of the user written since the token and the ids of the
+// deleted ones, a nil token returns everything. All of it is read from one snapshot, whose xmin is the new token.
+// The tasks of lists the user joined since the token are returned even though they didn't change, and the
+// lists the user lost access to are returned as deleted
+func (dbService *DatabaseService) Sync(ctx context.Context, userId uuid.UUID, since *SyncToken) (*SyncResponse, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	if since != nil && time.Since(since.IssuedAt) > dbService.tombstoneRetention {
+		return nil, ErrSyncTokenExpired
+	}
+
+	tx, err := dbService.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, queryError(ctx, err, "error while starting sync")
+	}
+	defer tx.Rollback(ctx)
+
+	var xmin string
+	err = tx.QueryRow(ctx, "SELECT pg_snapshot_xmin(pg_current_snapshot())::text").Scan(&xmin)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while starting sync")
+	}
+	newToken := SyncToken{IssuedAt: time.Now()}
+	newToken.Xmin, err = strconv.ParseUint(xmin, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected snapshot xmin %q", xmin)
+	}
+
+	args := pgx.NamedArgs{"userId": userId, "since": nil}
+	if since != nil {
+		args["since"] = strconv.FormatUint(since.Xmin, 10)
+	}
+	response := SyncResponse{
+		Deleted: SyncDeleted{Tasks: []uuid.UUID{}, TasksHistory: []uuid.UUID{}, Lists: []uuid.UUID{}},
+		Token:   newToken.String(),
+	}
+
+	rows, err := tx.Query(ctx, "SELECT "+taskColumns+" FROM task t WHERE "+taskReadable("@userId")+" AND (@since::text IS NULL OR t.sync_xid >= @since::text::xid8 OR "+joinedSince+")", args)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting changed tasks")
+	}
+	response.Tasks, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (TaskDB, error) {
+		var task TaskDB
+		err := scanTask(row, &task)
+		return task, err
+	})
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+
+	rows, err = tx.Query(ctx, "SELECT "+taskHistoryColumns+" FROM task_history th JOIN task t ON th.task_id = t.id WHERE "+taskReadable("@userId")+" AND (@since::text IS NULL OR th.sync_xid >= @since::text::xid8 OR "+joinedSince+")", args)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while getting changed tasks history")
+	}
+	response.TasksHistory, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (TaskHistoryDB, error) {
+		var taskHistory TaskHistoryDB
+		err := scanTaskHistory(row, &taskHistory)
+		return taskHistory, err
+	})
+	if err != nil {
+		return nil, queryError(ctx, err, "error while iterating dataset")
+	}
+
+	if since != nil {
+		// deletions of personal tasks and of the tasks of the user's lists, and the memberships the user
+		// lost unless they joined the list again since
+		rows, err = tx.Query(
+			ctx,
+			`SELECT st.entity, st.id, st.list_id FROM sync_tombstone st WHERE st.sync_xid >= @since::text::xid8 AND (
+				st.entity <> 'task_list_member' AND (st.list_id IS NULL AND st.owner_id = @userId OR st.list_id IN (SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = @userId))
+				OR st.entity = 'task_list_member' AND st.owner_id = @userId AND st.list_id NOT IN (SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = @userId))`,
+			args,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err, "error while getting deletions")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var entity string
+			var id uuid.UUID
+			var listId *uuid.UUID
+			err := rows.Scan(&entity, &id, &listId)
+			if err != nil {
+				return nil, queryError(ctx, err, "error while iterating dataset")
+			}
+			switch entity {
+			case "task":
+				response.Deleted.Tasks = append(response.Deleted.Tasks, id)
+			case "task_history":
+				response.Deleted.TasksHistory = append(response.Deleted.TasksHistory, id)
+			case "task_list_member":
+				response.Deleted.Lists = append(response.Deleted.Lists, *listId)
+			}
+		}
+		if rows.Err() != nil {
+			return nil, queryError(ctx, rows.Err(), "error while iterating dataset")
+		}
+	}
+
+	return &response, nil
+}
+
+// DeleteExpiredTombstones forgets deletions older than the tombstone retention
+func (dbService *DatabaseService) DeleteExpiredTombstones(ctx context.Context) (int64, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	cmdTag, err := dbService.pool.Exec(ctx, "DELETE FROM sync_tombstone st WHERE st.deleted_at <= $1", time.Now().Add(-dbService.tombstoneRetention))
+	if err != nil {
+		return 0, err
+	}
+	return cmdTag.RowsAffected(), nil
+}
@@ -0,0 +1,413 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// change is a change of a task or history entry, published once it is committed. listId is the list
+// of the task, the change is published to its members
+type change struct {
+	eventType string
+	id        uuid.UUID
+	listId    *uuid.UUID
+}
+
+// MaxSyncMutations limits the size of a batch, so a batch fits in the query timeout
+const MaxSyncMutations = 500
+
+// ApplySyncBatch applies the mutations in one transaction, in order. Every mutation runs in its own
+// savepoint, so a conflicting or rejected mutation is rolled back without affecting the others
+func (dbService *DatabaseService) ApplySyncBatch(ctx context.Context, userId uuid.UUID, mutations []SyncMutation) ([]SyncMutationResult, error) {
+	ctx, cancel := dbService.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := dbService.pool.Begin(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while applying sync batch")
+	}
+	defer tx.Rollback(ctx)
+
+	var created, completed int
+	var changes []change
+	results := make([]SyncMutationResult, 0, len(mutations))
+	for _, mutation := range mutations {
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, queryError(ctx, err, "error while applying sync batch")
+		}
+		mutationChanges := []change{}
+		result, err := applySyncMutation(ctx, savepoint, userId, mutation, &mutationChanges)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, queryError(ctx, err, "error while applying sync batch")
+			}
+			slog.WarnContext(ctx, "sync mutation failed", "op", mutation.Op, "entity", mutation.Entity, "id", mutation.Id, "error", err)
+			result = SyncMutationResult{Id: mutation.Id, Status: SyncRejected, Error: "unexpected error"}
+		}
+		if result.Status == SyncApplied {
+			err = savepoint.Commit(ctx)
+		} else {
+			err = savepoint.Rollback(ctx)
+		}
+		if err != nil {
+			return nil, queryError(ctx, err, "error while applying sync batch")
+		}
+		if result.Status == SyncApplied {
+			changes = append(changes, mutationChanges...)
+		}
+		if result.Status == SyncApplied && mutation.Entity == "task" {
+			switch mutation.Op {
+			case "create":
+				created++
+			case "complete":
+				completed++
+			}
+		}
+		results = append(results, result)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, queryError(ctx, err, "error while applying sync batch")
+	}
+	tasksCreated.Add(float64(created))
+	tasksCompleted.Add(float64(completed))
+	dbService.publishChanges(ctx, userId, changes...)
+	return results, nil
+}
+
+func applySyncMutation(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, changes *[]change) (SyncMutationResult, error) {
+	switch {
+	case mutation.Entity == "task" && mutation.Op == "create":
+		return createSyncTask(ctx, tx, userId, mutation, changes)
+	case mutation.Entity == "task" && mutation.Op == "update":
+		return updateSyncTask(ctx, tx, userId, mutation, changes)
+	case mutation.Entity == "task" && mutation.Op == "complete":
+		return completeSyncTask(ctx, tx, userId, mutation, changes)
+	case mutation.Entity == "task" && mutation.Op == "delete":
+		return deleteSyncTask(ctx, tx, userId, mutation, changes)
+	case mutation.Entity == "task_history" && mutation.Op == "update":
+		return updateSyncTaskHistory(ctx, tx, userId, mutation, changes)
+	case mutation.Entity == "task_history" && mutation.Op == "delete":
+		return deleteSyncTaskHistory(ctx, tx, userId, mutation, changes)
+	}
+	return rejected(mutation, "unsupported operation"), nil
+}
+
+func rejected(mutation SyncMutation, message string) SyncMutationResult {
+	return SyncMutationResult{Id: mutation.Id, Status: SyncRejected, Error: message}
+}
+
+func conflict(mutation SyncMutation, server any, version int64) SyncMutationResult {
+	return SyncMutationResult{Id: mutation.Id, Status: SyncConflict, Server: server, Version: &version}
+}
+
+func duplicate(mutation SyncMutation, server any, version int64) SyncMutationResult {
+	return SyncMutationResult{Id: mutation.Id, Status: SyncDuplicate, Server: server, Version: &version}
+}
+
+func applied(mutation SyncMutation, version int64) SyncMutationResult {
+	return SyncMutationResult{Id: mutation.Id, Status: SyncApplied, Version: &version}
+}
+
+// deleted is the result of a mutation of a task or history entry that was deleted on the server
+func deleted(mutation SyncMutation, status string) SyncMutationResult {
+	return SyncMutationResult{Id: mutation.Id, Status: status, Error: "deleted on the server"}
+}
+
+// lockTask returns the task locked for the rest of the transaction, nil when the user has no such task
+// or may only read it
+func lockTask(ctx context.Context, tx pgx.Tx, taskId uuid.UUID, userId uuid.UUID) (*TaskDB, error) {
+	return lockTaskWhere(ctx, tx, taskId, userId, taskEditable("$2"))
+}
+
+// lockTaskWhere is lockTask with the access condition of the task, user is $2
+func lockTaskWhere(ctx context.Context, tx pgx.Tx, taskId uuid.UUID, userId uuid.UUID, access string) (*TaskDB, error) {
+	var task TaskDB
+	err := scanTask(tx.QueryRow(ctx, "SELECT "+taskColumns+" FROM task t WHERE t.id = $1 AND "+access+" FOR UPDATE OF t", taskId, userId), &task)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return &task, err
+}
+
+// lockTaskHistory returns the history entry locked for the rest of the transaction, nil when the user has no such entry
+// or may only read it
+func lockTaskHistory(ctx context.Context, tx pgx.Tx, taskHistoryId uuid.UUID, userId uuid.UUID) (*TaskHistoryDB, error) {
+	var taskHistory TaskHistoryDB
+	err := scanTaskHistory(tx.QueryRow(ctx, "SELECT "+taskHistoryColumns+" FROM task_history th JOIN task t ON th.task_id = t.id WHERE th.id = $1 AND "+taskEditable("$2")+" FOR UPDATE OF th", taskHistoryId, userId), &taskHistory)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return &taskHistory, err
+}
+
+// isDeleted reports whether the user (or another member of its list) deleted the task or history entry,
+// according to the sync tombstones
+func isDeleted(ctx context.Context, tx pgx.Tx, entity string, id uuid.UUID, userId uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM sync_tombstone st WHERE st.entity = $1 AND st.id = $2 AND (st.owner_id = $3 OR st.list_id IN (SELECT tlm.list_id FROM task_list_member tlm WHERE tlm.user_id = $3)))",
+		entity,
+		id,
+		userId,
+	).Scan(&exists)
+	return exists, err
+}
+
+// canReadTask reports whether the user can read the task, used to tell a viewer's mutation apart from
+// a mutation of a task that doesn't exist
+func canReadTask(ctx context.Context, tx pgx.Tx, taskId uuid.UUID, userId uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM task t WHERE t.id = $1 AND "+taskReadable("$2")+")", taskId, userId).Scan(&exists)
+	return exists, err
+}
+
+func createSyncTask(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, changes *[]change) (SyncMutationResult, error) {
+	if mutation.Task == nil {
+		return rejected(mutation, "task is required"), nil
+	}
+	// a retried create of a task that was deleted since must not bring it back
+	wasDeleted, err := isDeleted(ctx, tx, "task", mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if wasDeleted {
+		return deleted(mutation, SyncDuplicate), nil
+	}
+	if mutation.ListId != nil {
+		role, err := listRole(ctx, tx, *mutation.ListId, userId)
+		if err != nil {
+			return SyncMutationResult{}, err
+		}
+		if role == "" {
+			return rejected(mutation, ErrListNotFound.Error()), nil
+		}
+		if role == RoleViewer {
+			return rejected(mutation, ErrForbidden.Error()), nil
+		}
+	}
+	var version int64
+	err = tx.QueryRow(
+		ctx,
+		"INSERT INTO task(id, task_name, task_icon, task_desc, deadline, starred, exec_status, created_by, list_id) VALUES ($1, $2, $3, $4, $5, $6, 'ACTIVE', $7, $8) ON CONFLICT (id) DO NOTHING RETURNING version",
+		mutation.Id,
+		mutation.Task.TaskName,
+		mutation.Task.TaskIcon,
+		mutation.Task.TaskDesc,
+		mutation.Task.Deadline,
+		mutation.Task.Starred,
+		userId,
+		mutation.ListId,
+	).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		task, err := lockTask(ctx, tx, mutation.Id, userId)
+		if err != nil {
+			return SyncMutationResult{}, err
+		}
+		if task == nil {
+			return rejected(mutation, "id is already in use"), nil
+		}
+		return duplicate(mutation, task, task.Version), nil
+	}
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	*changes = append(*changes, change{events.TaskCreated, mutation.Id, mutation.ListId})
+	return applied(mutation, version), nil
+}
+
+func updateSyncTask(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, changes *[]change) (SyncMutationResult, error) {
+	if mutation.Task == nil || mutation.BaseVersion == nil {
+		return rejected(mutation, "task and baseVersion are required"), nil
+	}
+	task, err := lockTask(ctx, tx, mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if task == nil {
+		return missingTaskResult(ctx, tx, userId, mutation, SyncConflict)
+	}
+	if task.Version != *mutation.BaseVersion {
+		return conflict(mutation, task, task.Version), nil
+	}
+	var version int64
+	err = tx.QueryRow(
+		ctx,
+		"UPDATE task SET task_name = $1, task_icon = $2, task_desc = $3, starred = $4, deadline = $5 WHERE id = $6 RETURNING version",
+		mutation.Task.TaskName,
+		mutation.Task.TaskIcon,
+		mutation.Task.TaskDesc,
+		mutation.Task.Starred,
+		mutation.Task.Deadline,
+		mutation.Id,
+	).Scan(&version)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	*changes = append(*changes, change{events.TaskUpdated, mutation.Id, task.ListId})
+	return applied(mutation, version), nil
+}
+
+func completeSyncTask(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, changes *[]change) (SyncMutationResult, error) {
+	task, err := lockTaskWhere(ctx, tx, mutation.Id, userId, taskCompletable("$2"))
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if task == nil {
+		return missingTaskResult(ctx, tx, userId, mutation, SyncConflict)
+	}
+	if task.Exec_status != "ACTIVE" {
+		return duplicate(mutation, task, task.Version), nil
+	}
+	if mutation.BaseVersion != nil && task.Version != *mutation.BaseVersion {
+		return conflict(mutation, task, task.Version), nil
+	}
+	var version int64
+	err = tx.QueryRow(ctx, "UPDATE task SET exec_status = 'INACTIVE' WHERE id = $1 RETURNING version", mutation.Id).Scan(&version)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	historyId := uuid.New()
+	if mutation.HistoryId != nil {
+		historyId = *mutation.HistoryId
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO task_history(id, task_id, completed_by) VALUES ($1, $2, $3)", historyId, mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	*changes = append(*changes, change{events.TaskUpdated, mutation.Id, task.ListId}, change{events.TaskHistoryCreated, historyId, task.ListId})
+	return applied(mutation, version), nil
+}
+
+func deleteSyncTask(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, changes *[]change) (SyncMutationResult, error) {
+	task, err := lockTask(ctx, tx, mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if task == nil {
+		return missingTaskResult(ctx, tx, userId, mutation, SyncDuplicate)
+	}
+	if mutation.BaseVersion != nil && task.Version != *mutation.BaseVersion {
+		return conflict(mutation, task, task.Version), nil
+	}
+	rows, err := tx.Query(ctx, "DELETE FROM task_history WHERE task_id = $1 RETURNING id", mutation.Id)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	taskHistoryIds, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM task WHERE id = $1", mutation.Id)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	for _, taskHistoryId := range taskHistoryIds {
+		*changes = append(*changes, change{events.TaskHistoryDeleted, taskHistoryId, task.ListId})
+	}
+	*changes = append(*changes, change{events.TaskDeleted, mutation.Id, task.ListId})
+	return applied(mutation, task.Version), nil
+}
+
+func updateSyncTaskHistory(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, changes *[]change) (SyncMutationResult, error) {
+	if mutation.TaskHistory == nil || mutation.BaseVersion == nil {
+		return rejected(mutation, "taskHistory and baseVersion are required"), nil
+	}
+	if rating := mutation.TaskHistory.ExecRating; rating != nil && (*rating < 1 || *rating > 3) {
+		return rejected(mutation, "rating must be between 1 and 3"), nil
+	}
+	taskHistory, err := lockTaskHistory(ctx, tx, mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if taskHistory == nil {
+		return missingTaskHistoryResult(ctx, tx, userId, mutation, SyncConflict)
+	}
+	if taskHistory.Version != *mutation.BaseVersion {
+		return conflict(mutation, taskHistory, taskHistory.Version), nil
+	}
+	var version int64
+	err = tx.QueryRow(
+		ctx,
+		"UPDATE task_history SET exec_comment = $1, exec_rating = $2 WHERE id = $3 RETURNING version",
+		mutation.TaskHistory.ExecComment,
+		mutation.TaskHistory.ExecRating,
+		mutation.Id,
+	).Scan(&version)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	*changes = append(*changes, change{events.TaskHistoryUpdated, mutation.Id, taskHistory.ListId})
+	return applied(mutation, version), nil
+}
+
+// deleteSyncTaskHistory deletes the history entry together with its task, like DeleteTaskAndHistory
+func deleteSyncTaskHistory(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, changes *[]change) (SyncMutationResult, error) {
+	taskHistory, err := lockTaskHistory(ctx, tx, mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if taskHistory == nil {
+		return missingTaskHistoryResult(ctx, tx, userId, mutation, SyncDuplicate)
+	}
+	if mutation.BaseVersion != nil && taskHistory.Version != *mutation.BaseVersion {
+		return conflict(mutation, taskHistory, taskHistory.Version), nil
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM task_history WHERE id = $1", mutation.Id)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM task WHERE id = $1", taskHistory.TaskId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	*changes = append(*changes, change{events.TaskHistoryDeleted, mutation.Id, taskHistory.ListId}, change{events.TaskDeleted, taskHistory.TaskId, taskHistory.ListId})
+	return applied(mutation, taskHistory.Version), nil
+}
+
+// missingTaskResult is the result of a mutation of a task that doesn't exist, status is used when
+// the task was deleted on the server (a repeated delete is a duplicate, an edit is a conflict)
+func missingTaskResult(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, status string) (SyncMutationResult, error) {
+	wasDeleted, err := isDeleted(ctx, tx, "task", mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if wasDeleted {
+		return deleted(mutation, status), nil
+	}
+	readable, err := canReadTask(ctx, tx, mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if readable {
+		return rejected(mutation, ErrForbidden.Error()), nil
+	}
+	return rejected(mutation, "task doesn't exist"), nil
+}
+
+func missingTaskHistoryResult(ctx context.Context, tx pgx.Tx, userId uuid.UUID, mutation SyncMutation, status string) (SyncMutationResult, error) {
+	wasDeleted, err := isDeleted(ctx, tx, "task_history", mutation.Id, userId)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if wasDeleted {
+		return deleted(mutation, status), nil
+	}
+	var readable bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM task_history th JOIN task t ON th.task_id = t.id WHERE th.id = $1 AND "+taskReadable("$2")+")", mutation.Id, userId).Scan(&readable)
+	if err != nil {
+		return SyncMutationResult{}, err
+	}
+	if readable {
+		return rejected(mutation, ErrForbidden.Error()), nil
+	}
+	return rejected(mutation, "task history doesn't exist"), nil
+}
@@ -0,0 +1,252 @@
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/metrics"
+	"github.com/google/uuid"
+)
+
+// Types of the events published when a task or history entry of a user changes
+const (
+	TaskCreated        = "task.created"
+	TaskUpdated        = "task.updated"
+	TaskDeleted        = "task.deleted"
+	TaskHistoryCreated = "task_history.created"
+	TaskHistoryUpdated = "task_history.updated"
+	TaskHistoryDeleted = "task_history.deleted"
+
+	// TaskAssigned is published to the user a task was assigned to by someone else, the id is the id of the task
+	TaskAssigned = "task.assigned"
+	// CommentMentioned is published to the users mentioned in a comment, the id is the id of the comment
+	CommentMentioned = "comment.mentioned"
+	// NotificationCreated is published when a notification is added to the inbox of the user, the id is
+	// the id of the notification
+	NotificationCreated = "notification.created"
+
+	// published when an account export finished, the id is the id of the export
+	ExportReady  = "export.ready"
+	ExportFailed = "export.failed"
+
+	// published to the members of a shared list, the id is the id of the list. ListUpdated is sent when
+	// the list was renamed or its members changed, ListRemoved to members that lost access to it
+	// (they were removed, left or the list was deleted)
+	ListUpdated = "list.updated"
+	ListRemoved = "list.removed"
+	// ListInvitation is published to an invited user, the id is the id of the invitation
+	ListInvitation = "list.invitation"
+
+	// SessionInvalidated is published when a session token is invalidated (logout), streams opened
+	// with the token are closed. It isn't sent to clients
+	SessionInvalidated = "session.invalidated"
+
+	// Resync tells a subscriber that events were missed (e.g. evicted from the replay buffer),
+	// the client has to fetch the changes with a sync instead
+	Resync = "resync"
+)
+
+// Message is an event to publish to a user
+type Message struct {
+	UserId uuid.UUID
+	Type   string
+	Id     uuid.UUID
+}
+
+// Publisher receives the changes of tasks and history entries, the messages of one change are
+// published together
+type Publisher interface {
+	Publish(messages ...Message)
+}
+
+// Event is a change of a task or history entry, only the id is sent, clients fetch the change with a sync
+type Event struct {
+	Id       string
+	UserId   uuid.UUID
+	Type     string
+	EntityId uuid.UUID
+	sequence uint64
+}
+
+const subscriptionBuffer = 64
+
+var subscribers atomic.Int64
+
+func init() {
+	metrics.NewGaugeFunc("taskjournal_event_subscribers", "Number of connected event streams.", func() float64 {
+		return float64(subscribers.Load())
+	})
+}
+
+// Broker fans out events to the subscriptions of their user within this process and keeps the latest
+// events in a bounded buffer, so a client that reconnects with the id of the last event it received
+// gets the events it missed
+type Broker struct {
+	mu sync.Mutex
+	// epoch makes event ids unique across restarts, ids of a previous process are unknown and get a resync
+	epoch         string
+	sequence      uint64
+	buffer        []Event
+	next          int
+	subscriptions map[uuid.UUID]map[*Subscription]struct{}
+	closed        bool
+}
+
+func NewBroker(bufferSize int) *Broker {
+	return &Broker{
+		epoch:         strconv.FormatInt(time.Now().UnixNano(), 36),
+		buffer:        make([]Event, 0, bufferSize),
+		subscriptions: map[uuid.UUID]map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription receives the events of one user, Events is closed when the subscription is dropped
+// because it fell behind or the broker was closed
+type Subscription struct {
+	Events <-chan Event
+	events chan Event
+	broker *Broker
+	userId uuid.UUID
+}
+
+func (b *Broker) Publish(messages ...Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	for _, message := range messages {
+		b.publish(message.UserId, message.Type, message.Id)
+	}
+}
+
+func (b *Broker) publish(userId uuid.UUID, eventType string, id uuid.UUID) {
+	b.sequence++
+	event := Event{Id: b.formatId(b.sequence), UserId: userId, Type: eventType, EntityId: id, sequence: b.sequence}
+	if len(b.buffer) < cap(b.buffer) {
+		b.buffer = append(b.buffer, event)
+	} else if cap(b.buffer) > 0 {
+		b.buffer[b.next] = event
+		b.next = (b.next + 1) % cap(b.buffer)
+	}
+
+	for subscription := range b.subscriptions[userId] {
+		select {
+		case subscription.events <- event:
+		default:
+			// a slow client is dropped instead of blocking the publisher, it reconnects with
+			// Last-Event-ID and gets the missed events from the buffer
+			b.remove(subscription)
+		}
+	}
+}
+
+// Subscribe starts receiving the events of the user. When lastEventId is set, the buffered events
+// published after it are returned, complete is false when some of them are no longer buffered
+// (or the id is unknown) and the client has to resync
+func (b *Broker) Subscribe(userId uuid.UUID, lastEventId string) (subscription *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make(chan Event, subscriptionBuffer)
+	subscription = &Subscription{Events: events, events: events, broker: b, userId: userId}
+	if b.closed {
+		close(events)
+		return subscription, nil, true
+	}
+	if b.subscriptions[userId] == nil {
+		b.subscriptions[userId] = map[*Subscription]struct{}{}
+	}
+	b.subscriptions[userId][subscription] = struct{}{}
+	subscribers.Add(1)
+
+	if lastEventId == "" {
+		return subscription, nil, true
+	}
+	sequence, ok := b.parseId(lastEventId)
+	if !ok || sequence > b.sequence {
+		return subscription, nil, false
+	}
+	oldest := b.sequence - uint64(len(b.buffer)) + 1
+	if sequence+1 < oldest {
+		return subscription, nil, false
+	}
+	for i := range b.buffer {
+		event := b.buffer[(b.next+i)%len(b.buffer)]
+		if event.sequence > sequence && event.UserId == userId {
+			missed = append(missed, event)
+		}
+	}
+	return subscription, missed, true
+}
+
+// Resync tells every subscriber to resync, used when events may have been lost (e.g. while the
+// connection to the notification bus was down). The buffer is discarded and the epoch changed,
+// so a client reconnecting with an older Last-Event-ID resyncs as well
+func (b *Broker) Resync() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	b.sequence = 0
+	b.buffer = b.buffer[:0]
+	b.next = 0
+	for _, userSubscriptions := range b.subscriptions {
+		for subscription := range userSubscriptions {
+			select {
+			case subscription.events <- Event{UserId: subscription.userId, Type: Resync}:
+			default:
+				b.remove(subscription)
+			}
+		}
+	}
+}
+
+// Close stops receiving events
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+// Close ends every subscription, so open event streams finish before the server shuts down
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, userSubscriptions := range b.subscriptions {
+		for subscription := range userSubscriptions {
+			b.remove(subscription)
+		}
+	}
+}
+
+// remove must be called with the lock held
+func (b *Broker) remove(subscription *Subscription) {
+	userSubscriptions := b.subscriptions[subscription.userId]
+	if _, ok := userSubscriptions[subscription]; !ok {
+		return
+	}
+	delete(userSubscriptions, subscription)
+	if len(userSubscriptions) == 0 {
+		delete(b.subscriptions, subscription.userId)
+	}
+	close(subscription.events)
+	subscribers.Add(-1)
+}
+
+func (b *Broker) formatId(sequence uint64) string {
+	return fmt.Sprintf("%s-%d", b.epoch, sequence)
+}
+
+func (b *Broker) parseId(id string) (uint64, bool) {
+	epoch, sequence, found := strings.Cut(id, "-")
+	if !found || epoch != b.epoch {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(sequence, 10, 64)
+	return value, err == nil
+}
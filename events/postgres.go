@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/metrics"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const notifyChannel = "taskjournal_events"
+
+// maxPayloadSize keeps payloads below the 8000 byte limit of NOTIFY
+const maxPayloadSize = 7900
+
+// publishQueueSize is how many published changes wait for their NOTIFY before further ones are dropped
+const publishQueueSize = 1024
+
+const (
+	publishTimeout   = 5 * time.Second
+	minRetryInterval = time.Second
+	maxRetryInterval = 30 * time.Second
+)
+
+var busReconnects = metrics.NewCounterVec("taskjournal_event_bus_reconnects_total", "Number of times the event bus reconnected to the database.")
+var busDropped = metrics.NewCounterVec("taskjournal_event_bus_dropped_total", "Number of events dropped because the publish queue of the event bus was full.")
+
+// notification is an event in the payload of a NOTIFY, kept short because payloads are limited to
+// 8000 bytes. A payload is a JSON array of them
+type notification struct {
+	UserId uuid.UUID `json:"u"`
+	Type   string    `json:"t"`
+	Id     uuid.UUID `json:"i"`
+}
+
+// PostgresBus distributes events between instances with LISTEN/NOTIFY. Publish queues the events for a
+// NOTIFY sent by Run and the notifications of every instance, including its own, are fed to the local broker by Run
+type PostgresBus struct {
+	pool    *pgxpool.Pool
+	broker  *Broker
+	pending chan []Message
+}
+
+func NewPostgresBus(pool *pgxpool.Pool, broker *Broker) *PostgresBus {
+	return &PostgresBus{pool: pool, broker: broker, pending: make(chan []Message, publishQueueSize)}
+}
+
+// Publish queues the messages without waiting for the database, so a slow pool doesn't hold up the
+// request that made the change. When the queue is full the messages are dropped
+func (bus *PostgresBus) Publish(messages ...Message) {
+	if len(messages) == 0 {
+		return
+	}
+	select {
+	case bus.pending <- messages:
+	default:
+		slog.Error("event bus publish queue is full, dropping events", "count", len(messages))
+		busDropped.Add(float64(len(messages)))
+	}
+}
+
+// sendPending sends the queued messages until ctx is canceled, then sends what is left in the queue
+func (bus *PostgresBus) sendPending(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			bus.notify(bus.takePending(nil))
+			return
+		case messages := <-bus.pending:
+			bus.notify(bus.takePending(messages))
+		}
+	}
+}
+
+// takePending appends the messages that are already queued, so they are sent together
+func (bus *PostgresBus) takePending(messages []Message) []Message {
+	for {
+		select {
+		case queued := <-bus.pending:
+			messages = append(messages, queued...)
+		default:
+			return messages
+		}
+	}
+}
+
+// notify sends the messages with as few NOTIFYs as fit them, all in one round trip, instead of one
+// per message
+func (bus *PostgresBus) notify(messages []Message) {
+	payloads, err := encodeNotifications(messages)
+	if err != nil {
+		slog.Error("error while encoding events", "error", err)
+		return
+	}
+	if len(payloads) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	_, err = bus.pool.Exec(ctx, "SELECT pg_notify($1, payload) FROM unnest($2::text[]) payload", notifyChannel, payloads)
+	if err != nil {
+		slog.Error("error while publishing events", "count", len(messages), "error", err)
+	}
+}
+
+// encodeNotifications packs the messages into JSON arrays of at most maxPayloadSize bytes
+func encodeNotifications(messages []Message) ([]string, error) {
+	var payloads []string
+	var payload []byte
+	for _, message := range messages {
+		encoded, err := json.Marshal(notification{UserId: message.UserId, Type: message.Type, Id: message.Id})
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) > 0 && len(payload)+len(encoded)+2 > maxPayloadSize {
+			payloads = append(payloads, string(append(payload, ']')))
+			payload = nil
+		}
+		if len(payload) == 0 {
+			payload = append(payload, '[')
+		} else {
+			payload = append(payload, ',')
+		}
+		payload = append(payload, encoded...)
+	}
+	if len(payload) > 0 {
+		payloads = append(payloads, string(append(payload, ']')))
+	}
+	return payloads, nil
+}
+
+// decodeNotifications also accepts the single event payloads of older instances, so events aren't lost
+// while instances are upgraded one at a time
+func decodeNotifications(payload string) ([]notification, error) {
+	if !strings.HasPrefix(payload, "[") {
+		var event notification
+		err := json.Unmarshal([]byte(payload), &event)
+		return []notification{event}, err
+	}
+	var received []notification
+	err := json.Unmarshal([]byte(payload), &received)
+	return received, err
+}
+
+// Run sends the published events and listens for notifications until ctx is canceled. A lost connection
+// is reestablished with exponential backoff, notifications sent in the meantime are lost, so subscribers
+// are told to resync
+func (bus *PostgresBus) Run(ctx context.Context) error {
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		bus.sendPending(ctx)
+	}()
+	defer func() { <-sent }()
+
+	retryInterval := minRetryInterval
+	listening := false
+	for {
+		err := bus.listen(ctx, func() {
+			if listening {
+				bus.broker.Resync()
+			}
+			listening = true
+			retryInterval = minRetryInterval
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Warn("event bus disconnected, reconnecting", "error", err, "retry_in", retryInterval)
+		busReconnects.Inc()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryInterval):
+		}
+		retryInterval = min(retryInterval*2, maxRetryInterval)
+	}
+}
+
+// listen uses its own connection, a pooled one would be taken out of the pool for good
+func (bus *PostgresBus) listen(ctx context.Context, onListening func()) error {
+	conn, err := pgx.ConnectConfig(ctx, bus.pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+notifyChannel)
+	if err != nil {
+		return err
+	}
+	slog.Info("event bus listening", "channel", notifyChannel)
+	onListening()
+
+	for {
+		received, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		notifications, err := decodeNotifications(received.Payload)
+		if err != nil {
+			slog.Warn("ignoring malformed event", "payload", received.Payload, "error", err)
+			continue
+		}
+		messages := make([]Message, 0, len(notifications))
+		for _, event := range notifications {
+			messages = append(messages, Message{UserId: event.UserId, Type: event.Type, Id: event.Id})
+		}
+		bus.broker.Publish(messages...)
+	}
+}
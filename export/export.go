@@ -0,0 +1,112 @@
+// Package export generates account exports, ZIP files with everything a user has stored
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/google/uuid"
+)
+
+// Exporter generates the exports requested by users, it is run periodically by the scheduler.
+// Exports are claimed through the database, so every instance can run it
+type Exporter struct {
+	DBService *db.DatabaseService
+	// Directory the ZIP files are written to, shared by all instances like the icon upload directory
+	Directory           string
+	IconUploadDirectory string
+	// Lifetime is how long an export can be downloaded
+	Lifetime time.Duration
+}
+
+// Path returns the path of the ZIP file of an export
+func (e *Exporter) Path(exportId uuid.UUID) string {
+	return filepath.Join(e.Directory, exportId.String()+".zip")
+}
+
+// RunPending generates the waiting exports one after another until there are none left
+func (e *Exporter) RunPending(ctx context.Context) error {
+	for ctx.Err() == nil {
+		export, err := e.DBService.ClaimExport(ctx)
+		if err != nil {
+			return err
+		}
+		if export == nil {
+			return nil
+		}
+		e.run(ctx, *export)
+	}
+	return nil
+}
+
+func (e *Exporter) run(ctx context.Context, export db.ExportDB) {
+	start := time.Now()
+	size, err := e.generate(ctx, export)
+	if err != nil {
+		if ctx.Err() != nil {
+			// shutting down, the export stays running and is claimed again once it is stale
+			return
+		}
+		slog.ErrorContext(ctx, "account export failed", "export_id", export.Id, "user_id", export.UserId, "error", err)
+		if err := e.DBService.FailExport(ctx, export, "export could not be generated", time.Now().Add(e.Lifetime)); err != nil {
+			slog.ErrorContext(ctx, "error while failing export", "export_id", export.Id, "error", err)
+		}
+		return
+	}
+	if err := e.DBService.CompleteExport(ctx, export, size, time.Now().Add(e.Lifetime)); err != nil {
+		slog.ErrorContext(ctx, "error while completing export", "export_id", export.Id, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "account export generated", "export_id", export.Id, "user_id", export.UserId, "bytes", size, "duration_ms", time.Since(start).Milliseconds())
+}
+
+// generate writes the ZIP to a temporary file that is renamed once complete,
+// so a download never sees a partial file
+func (e *Exporter) generate(ctx context.Context, export db.ExportDB) (int64, error) {
+	data, err := e.DBService.GetExportData(ctx, export.UserId)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(e.Directory, os.ModePerm); err != nil {
+		return 0, err
+	}
+	file, err := os.CreateTemp(e.Directory, "export-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	iconPath := filepath.Join(e.IconUploadDirectory, fmt.Sprintf("icon-%s.png", export.UserId))
+	if err := WriteZip(file, data, iconPath, time.Now()); err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(file.Name(), e.Path(export.Id))
+}
+
+// DeleteExpired deletes the expired exports and their files
+func (e *Exporter) DeleteExpired(ctx context.Context) (int, error) {
+	exportIds, err := e.DBService.DeleteExpiredExports(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, exportId := range exportIds {
+		if err := os.Remove(e.Path(exportId)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.WarnContext(ctx, "error while deleting export file", "export_id", exportId, "error", err)
+		}
+	}
+	return len(exportIds), nil
+}
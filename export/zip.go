@@ -0,0 +1,238 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/google/uuid"
+)
+
+// WriteZip writes the export: the profile, tasks and history as JSON and CSV, the comments and lists as JSON, the journal
+// as Markdown and the profile icon when the user uploaded one. tasks.csv uses the columns of the CSV import
+func WriteZip(w io.Writer, data *db.ExportData, iconPath string, exportedAt time.Time) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"profile.json", func(w io.Writer) error { return writeJSONFile(w, data.Profile) }},
+		{"tasks.json", func(w io.Writer) error { return writeJSONFile(w, nonNil(data.Tasks)) }},
+		{"tasks_history.json", func(w io.Writer) error { return writeJSONFile(w, nonNil(data.TasksHistory)) }},
+		{"comments.json", func(w io.Writer) error { return writeJSONFile(w, nonNil(data.Comments)) }},
+		{"notifications.json", func(w io.Writer) error { return writeJSONFile(w, nonNil(data.Notifications)) }},
+		{"lists.json", func(w io.Writer) error { return writeJSONFile(w, nonNil(data.Lists)) }},
+		{"tasks.csv", func(w io.Writer) error { return writeTasksCSV(w, data.Tasks, data.TasksHistory) }},
+		{"tasks_history.csv", func(w io.Writer) error { return writeTasksHistoryCSV(w, data.TasksHistory) }},
+		{"journal.md", func(w io.Writer) error { return writeJournal(w, data, exportedAt) }},
+	}
+	for _, file := range files {
+		fileWriter, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: exportedAt})
+		if err != nil {
+			return err
+		}
+		if err := file.write(fileWriter); err != nil {
+			return fmt.Errorf("error while writing %s: %w", file.name, err)
+		}
+	}
+
+	icon, err := os.Open(iconPath)
+	if err == nil {
+		defer icon.Close()
+		// the icon is already compressed
+		fileWriter, err := archive.CreateHeader(&zip.FileHeader{Name: "profile_icon.png", Method: zip.Store, Modified: exportedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fileWriter, icon); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return archive.Close()
+}
+
+// nonNil makes empty lists [] instead of null
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+func writeJSONFile(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatOptionalInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+func formatOptionalString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// writeTasksCSV writes the rating and comment of the last completion of completed tasks, like the import reads them
+func writeTasksCSV(w io.Writer, tasks []db.TaskDB, tasksHistory []db.ExportTaskHistory) error {
+	lastCompletion := map[uuid.UUID]db.ExportTaskHistory{}
+	for _, taskHistory := range tasksHistory {
+		lastCompletion[taskHistory.TaskId] = taskHistory
+	}
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "taskName", "taskIcon", "taskDesc", "deadline", "starred", "completed", "execRating", "execComment", "createdAt", "updatedAt"})
+	for _, task := range tasks {
+		completed := task.Exec_status != "ACTIVE"
+		var execRating *int
+		var execComment *string
+		if taskHistory, ok := lastCompletion[task.Id]; ok && completed {
+			execRating, execComment = taskHistory.ExecRating, taskHistory.ExecComment
+		}
+		writer.Write([]string{
+			task.Id.String(),
+			task.TaskName,
+			task.TaskIcon,
+			task.TaskDesc,
+			formatOptionalTime(task.Deadline),
+			strconv.FormatBool(task.Starred),
+			strconv.FormatBool(completed),
+			formatOptionalInt(execRating),
+			formatOptionalString(execComment),
+			task.Created_at.UTC().Format(time.RFC3339),
+			task.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeTasksHistoryCSV(w io.Writer, tasksHistory []db.ExportTaskHistory) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "taskId", "taskName", "taskIcon", "execRating", "execComment", "completedAt"})
+	for _, taskHistory := range tasksHistory {
+		writer.Write([]string{
+			taskHistory.Id.String(),
+			taskHistory.TaskId.String(),
+			taskHistory.TaskName,
+			taskHistory.TaskIcon,
+			formatOptionalInt(taskHistory.ExecRating),
+			formatOptionalString(taskHistory.ExecComment),
+			taskHistory.CompletedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeJournal writes the completed tasks grouped by the month they were completed in,
+// followed by the tasks that are still open
+func writeJournal(w io.Writer, data *db.ExportData, exportedAt time.Time) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Task journal of %s\n\nExported on %s.\n", markdownLine(data.Profile.Username), exportedAt.UTC().Format("January 2, 2006"))
+
+	tasks := map[uuid.UUID]db.TaskDB{}
+	for _, task := range data.Tasks {
+		tasks[task.Id] = task
+	}
+	month := ""
+	for _, taskHistory := range data.TasksHistory {
+		completedAt := taskHistory.CompletedAt.UTC()
+		if entryMonth := completedAt.Format("January 2006"); entryMonth != month {
+			month = entryMonth
+			fmt.Fprintf(&b, "\n## %s\n", month)
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", markdownLine(taskHistory.TaskName))
+		fmt.Fprintf(&b, "- Completed: %s\n", completedAt.Format("January 2, 2006"))
+		if rating := taskHistory.ExecRating; rating != nil && *rating >= 1 && *rating <= 3 {
+			fmt.Fprintf(&b, "- Rating: %s (%d/3)\n", strings.Repeat("★", *rating)+strings.Repeat("☆", 3-*rating), *rating)
+		}
+		fmt.Fprintf(&b, "- Icon: %s\n", markdownLine(taskHistory.TaskIcon))
+		task := tasks[taskHistory.TaskId]
+		if task.Deadline != nil {
+			fmt.Fprintf(&b, "- Deadline: %s\n", task.Deadline.UTC().Format("January 2, 2006 15:04 MST"))
+		}
+		if task.TaskDesc != "" {
+			fmt.Fprintf(&b, "\n%s\n", markdownText(task.TaskDesc))
+		}
+		if taskHistory.ExecComment != nil && *taskHistory.ExecComment != "" {
+			fmt.Fprintf(&b, "\n> %s\n", strings.ReplaceAll(markdownText(*taskHistory.ExecComment), "\n", "\n> "))
+		}
+	}
+
+	open := false
+	for _, task := range data.Tasks {
+		if task.Exec_status != "ACTIVE" {
+			continue
+		}
+		if !open {
+			b.WriteString("\n## Open tasks\n\n")
+			open = true
+		}
+		star := ""
+		if task.Starred {
+			star = " ★"
+		}
+		deadline := ""
+		if task.Deadline != nil {
+			deadline = ", due " + task.Deadline.UTC().Format("January 2, 2006")
+		}
+		fmt.Fprintf(&b, "- [ ] %s%s (%s%s)\n", markdownLine(task.TaskName), star, markdownLine(task.TaskIcon), deadline)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownLine puts text on a single line of the journal (e.g. a heading), line breaks are collapsed
+// into spaces and a leading Markdown marker is escaped
+func markdownLine(text string) string {
+	return escapeLineStart(strings.Join(strings.Fields(text), " "))
+}
+
+// markdownText escapes every line of text, so a line that starts like a heading, quote, list or code block
+// is shown as typed instead of breaking the structure of the journal
+func markdownText(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = escapeLineStart(strings.TrimLeft(line, " \t"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// escapeLineStart escapes the character that would make Markdown read the line as something else
+// than text: #, >, -, +, *, =, |, ~, `, < and the . or ) of an ordered list item
+func escapeLineStart(line string) string {
+	if line == "" {
+		return line
+	}
+	if strings.ContainsRune("#>-+*=|~`<", rune(line[0])) {
+		return "\\" + line
+	}
+	digits := len(line) - len(strings.TrimLeft(line, "0123456789"))
+	if digits > 0 && digits < len(line) && (line[digits] == '.' || line[digits] == ')') {
+		return line[:digits] + "\\" + line[digits:]
+	}
+	return line
+}
@@ -0,0 +1,33 @@
+package export
+
+import "testing"
+
+func TestMarkdownEscaping(t *testing.T) {
+	lines := map[string]string{
+		"Call mom":                "Call mom",
+		"# Not a heading":         "\\# Not a heading",
+		"Two\nlines\r\n  of text": "Two lines of text",
+		"- dash":                  "\\- dash",
+		"1. first":                "1\\. first",
+		"2024) year":              "2024\\) year",
+		"100 push-ups":            "100 push-ups",
+		"  > quoted":              "\\> quoted",
+		"":                        "",
+	}
+	for text, expected := range lines {
+		if escaped := markdownLine(text); escaped != expected {
+			t.Errorf("markdownLine(%q): expected %q, got %q", text, expected, escaped)
+		}
+	}
+
+	texts := map[string]string{
+		"Buy milk\nand eggs":         "Buy milk\nand eggs",
+		"Steps:\r\n1. wash\n    ---": "Steps:\n1\\. wash\n\\---",
+		"## Notes\n\n* item\n```":    "\\## Notes\n\n\\* item\n\\```",
+	}
+	for text, expected := range texts {
+		if escaped := markdownText(text); escaped != expected {
+			t.Errorf("markdownText(%q): expected %q, got %q", text, expected, escaped)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package ical writes iCalendar (RFC 5545) data
+package ical
+
+import (
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineLength is the length in octets after which content lines are folded
+const maxLineLength = 75
+
+const dateTimeFormat = "20060102T150405Z"
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Writer writes content lines, the first write error is kept and returned by Err
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Begin(component string) {
+	w.Property("BEGIN", component)
+}
+
+func (w *Writer) End(component string) {
+	w.Property("END", component)
+}
+
+// Text writes a property with a TEXT value, which is escaped
+func (w *Writer) Text(name string, value string) {
+	w.Property(name, textEscaper.Replace(value))
+}
+
+// Time writes a property with a DATE-TIME value in UTC
+func (w *Writer) Time(name string, t time.Time) {
+	w.Property(name, t.UTC().Format(dateTimeFormat))
+}
+
+// Property writes the value as is, folding the line when it is too long
+func (w *Writer) Property(name string, value string) {
+	if w.err != nil {
+		return
+	}
+	line := name + ":" + value
+	var b strings.Builder
+	limit := maxLineLength
+	for len(line) > limit {
+		// never split a multi-byte character
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of a continuation line counts towards its length
+		limit = maxLineLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+	_, w.err = io.WriteString(w.w, b.String())
+}
+
+func (w *Writer) Err() error {
+	return w.err
+}
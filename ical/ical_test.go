@@ -0,0 +1,112 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func writeProperty(write func(w *Writer)) string {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	write(w)
+	return buf.String()
+}
+
+func TestTextEscaping(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"plain", "Team meeting", "SUMMARY:Team meeting\r\n"},
+		// RFC 5545 3.3.11
+		{
+			"newlines",
+			"Project XYZ Final Review\nConference Room - 3B\nCome Prepared.",
+			`SUMMARY:Project XYZ Final Review\nConference Room - 3B\nCome Prepared.` + "\r\n",
+		},
+		{"crlf", "first\r\nsecond\rthird", `SUMMARY:first\nsecond\nthird` + "\r\n"},
+		{"separators", "a,b;c", `SUMMARY:a\,b\;c` + "\r\n"},
+		{"backslash", `C:\tasks`, `SUMMARY:C:\\tasks` + "\r\n"},
+		{"colon is not escaped", "time: 10:00", "SUMMARY:time: 10:00\r\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := writeProperty(func(w *Writer) { w.Text("SUMMARY", test.value) })
+			if output != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, output)
+			}
+		})
+	}
+}
+
+func TestTime(t *testing.T) {
+	zone := time.FixedZone("CET", 3600)
+	output := writeProperty(func(w *Writer) { w.Time("DTSTART", time.Date(1997, time.July, 14, 18, 0, 0, 0, zone)) })
+	if expected := "DTSTART:19970714T170000Z\r\n"; output != expected {
+		t.Errorf("expected %q, got %q", expected, output)
+	}
+}
+
+func TestFolding(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"short", "a", "SUMMARY:a\r\n"},
+		{"exactly 75 octets", strings.Repeat("a", 67), "SUMMARY:" + strings.Repeat("a", 67) + "\r\n"},
+		{"76 octets", strings.Repeat("a", 68), "SUMMARY:" + strings.Repeat("a", 67) + "\r\n a\r\n"},
+		{
+			"continuation lines hold 74 octets",
+			strings.Repeat("a", 67+74+1),
+			"SUMMARY:" + strings.Repeat("a", 67) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n",
+		},
+		{
+			"multi-byte character on the boundary",
+			strings.Repeat("a", 66) + "é",
+			"SUMMARY:" + strings.Repeat("a", 66) + "\r\n é\r\n",
+		},
+		{
+			"multi-byte character before the boundary",
+			strings.Repeat("a", 65) + "é",
+			"SUMMARY:" + strings.Repeat("a", 65) + "é\r\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output := writeProperty(func(w *Writer) { w.Property("SUMMARY", test.value) })
+			if output != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, output)
+			}
+		})
+	}
+}
+
+func TestFoldingKeepsCharacters(t *testing.T) {
+	// 1 to 4 octet characters, so the boundary falls inside each of them at some offset
+	value := strings.Repeat("a é 日本 🎉 ", 40)
+	for offset := 0; offset < 4; offset++ {
+		output := writeProperty(func(w *Writer) { w.Text("DESCRIPTION", strings.Repeat("x", offset)+value) })
+		lines := strings.Split(strings.TrimSuffix(output, "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > maxLineLength {
+				t.Errorf("offset %d: line %d has %d octets", offset, i, len(line))
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("offset %d: line %d splits a character: %q", offset, i, line)
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("offset %d: continuation line %d doesn't start with a space", offset, i)
+			}
+		}
+		// RFC 5545 3.1: unfolding removes the CRLF and the whitespace after it
+		unfolded := strings.ReplaceAll(strings.TrimSuffix(output, "\r\n"), "\r\n ", "")
+		if expected := "DESCRIPTION:" + strings.Repeat("x", offset) + value; unfolded != expected {
+			t.Errorf("offset %d: unfolded to %q", offset, unfolded)
+		}
+	}
+}
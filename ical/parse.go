@@ -0,0 +1,175 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// Component is a parsed component (e.g. VCALENDAR, VTODO) with its properties and subcomponents
+type Component struct {
+	Name       string
+	Properties []Property
+	Components []*Component
+}
+
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Get returns the first property with the given name
+func (c *Component) Get(name string) (Property, bool) {
+	for _, property := range c.Properties {
+		if property.Name == name {
+			return property, true
+		}
+	}
+	return Property{}, false
+}
+
+// Parse reads the components of an iCalendar stream, unknown components and properties are kept as is
+func Parse(r io.Reader) ([]*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	var top []*Component
+	var stack []*Component
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		property, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		switch property.Name {
+		case "BEGIN":
+			component := &Component{Name: strings.ToUpper(property.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, component)
+			} else {
+				top = append(top, component)
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(property.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", i+1, property.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, ErrNotCalendar
+			}
+			current := stack[len(stack)-1]
+			current.Properties = append(current.Properties, property)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%s is not closed", stack[len(stack)-1].Name)
+	}
+	if len(top) == 0 || top[0].Name != "VCALENDAR" {
+		return nil, ErrNotCalendar
+	}
+	return top, nil
+}
+
+// unfold joins folded lines, a line starting with a space or tab continues the previous one
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line (NAME;PARAM=value:VALUE) into its parts,
+// a colon or semicolon inside a quoted parameter value doesn't end the parameter
+func parseLine(line string) (Property, error) {
+	property := Property{Params: map[string]string{}}
+	quoted := false
+	start := 0
+	paramName := ""
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '=' && property.Name != "" && paramName == "":
+			paramName = strings.ToUpper(line[start:i])
+			start = i + 1
+		case c == ';' || c == ':':
+			part := line[start:i]
+			if property.Name == "" {
+				property.Name = strings.ToUpper(part)
+			} else if paramName != "" {
+				property.Params[paramName] = strings.Trim(part, `"`)
+				paramName = ""
+			}
+			start = i + 1
+			if c == ':' {
+				property.Value = line[start:]
+				return property, nil
+			}
+		}
+	}
+	return Property{}, errors.New("missing ':' in content line")
+}
+
+var textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// Text returns the unescaped TEXT value
+func (p Property) Text() string {
+	return textUnescaper.Replace(p.Value)
+}
+
+// List returns the values of a TEXT list (e.g. CATEGORIES), split on unescaped commas
+func (p Property) List() []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(p.Value); i++ {
+		if p.Value[i] == '\\' {
+			i++
+			continue
+		}
+		if p.Value[i] == ',' {
+			values = append(values, textUnescaper.Replace(p.Value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, textUnescaper.Replace(p.Value[start:]))
+}
+
+// Time parses a DATE or DATE-TIME value. Times with a TZID are converted from that zone
+// (when it is known), floating times and dates are read as UTC
+func (p Property) Time() (time.Time, error) {
+	if p.Params["VALUE"] == "DATE" || len(p.Value) == len("20060102") {
+		return time.Parse("20060102", p.Value)
+	}
+	if strings.HasSuffix(p.Value, "Z") {
+		return time.Parse(dateTimeFormat, p.Value)
+	}
+	location := time.UTC
+	if tzid := p.Params["TZID"]; tzid != "" {
+		if zone, err := time.LoadLocation(tzid); err == nil {
+			location = zone
+		}
+	}
+	return time.ParseInLocation("20060102T150405", p.Value, location)
+}
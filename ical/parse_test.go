@@ -0,0 +1,152 @@
+package ical
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	input := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VTODO",
+		"UID:1",
+		// RFC 5545 3.1
+		"DESCRIPTION:This is a lo",
+		" ng description",
+		"  that exists on a long line.",
+		"SUMMARY:tab\tcontinued",
+		"\t line",
+		`ATTENDEE;CN="Doe, John: Jr.";ROLE=REQ-PARTICIPANT:mailto:john@example.com`,
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"END:VALARM",
+		"END:VTODO",
+		"",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	calendars, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calendars) != 1 || len(calendars[0].Components) != 1 {
+		t.Fatalf("expected one calendar with one component, got %+v", calendars)
+	}
+	todo := calendars[0].Components[0]
+	if todo.Name != "VTODO" || len(todo.Components) != 1 || todo.Components[0].Name != "VALARM" {
+		t.Fatalf("unexpected components %+v", todo)
+	}
+
+	description, _ := todo.Get("DESCRIPTION")
+	if expected := "This is a long description that exists on a long line."; description.Value != expected {
+		t.Errorf("expected %q, got %q", expected, description.Value)
+	}
+	summary, _ := todo.Get("SUMMARY")
+	if expected := "tab\tcontinued line"; summary.Value != expected {
+		t.Errorf("expected %q, got %q", expected, summary.Value)
+	}
+	attendee, _ := todo.Get("ATTENDEE")
+	expected := Property{
+		Name:   "ATTENDEE",
+		Params: map[string]string{"CN": "Doe, John: Jr.", "ROLE": "REQ-PARTICIPANT"},
+		Value:  "mailto:john@example.com",
+	}
+	if !reflect.DeepEqual(attendee, expected) {
+		t.Errorf("expected %+v, got %+v", expected, attendee)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"empty", "", ErrNotCalendar.Error()},
+		{"other component", "BEGIN:VCARD\nEND:VCARD\n", ErrNotCalendar.Error()},
+		{"property outside a component", "VERSION:2.0\n", ErrNotCalendar.Error()},
+		{"unexpected end", "BEGIN:VCALENDAR\nEND:VTODO\n", "line 2: unexpected END:VTODO"},
+		{"not closed", "BEGIN:VCALENDAR\nBEGIN:VTODO\nEND:VCALENDAR\n", "line 3: unexpected END:VCALENDAR"},
+		{"not closed at the end", "BEGIN:VCALENDAR\nBEGIN:VTODO\n", "VTODO is not closed"},
+		{"missing colon", "BEGIN:VCALENDAR\nSUMMARY\nEND:VCALENDAR\n", "line 2: missing ':' in content line"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.input))
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+			if test.err == ErrNotCalendar.Error() && !errors.Is(err, ErrNotCalendar) {
+				t.Errorf("expected ErrNotCalendar, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPropertyText(t *testing.T) {
+	tests := []struct {
+		value string
+		text  string
+		list  []string
+	}{
+		{"plain", "plain", []string{"plain"}},
+		{`Project XYZ Final Review\nConference Room - 3B\nCome Prepared.`, "Project XYZ Final Review\nConference Room - 3B\nCome Prepared.", nil},
+		{`upper\Nnewline`, "upper\nnewline", nil},
+		{`a\,b\;c\\d`, `a,b;c\d`, []string{`a,b;c\d`}},
+		{`work,home\,garden,`, "work,home,garden,", []string{"work", "home,garden", ""}},
+		{`ends with\\,next`, `ends with\,next`, []string{`ends with\`, "next"}},
+	}
+	for _, test := range tests {
+		property := Property{Name: "CATEGORIES", Value: test.value}
+		if text := property.Text(); text != test.text {
+			t.Errorf("Text of %q: expected %q, got %q", test.value, test.text, text)
+		}
+		if test.list == nil {
+			continue
+		}
+		if list := property.List(); !reflect.DeepEqual(list, test.list) {
+			t.Errorf("List of %q: expected %q, got %q", test.value, test.list, list)
+		}
+	}
+}
+
+func TestPropertyTime(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		params   map[string]string
+		value    string
+		expected time.Time
+	}{
+		{"utc", nil, "19980119T070000Z", time.Date(1998, time.January, 19, 7, 0, 0, 0, time.UTC)},
+		{"floating", nil, "19980118T230000", time.Date(1998, time.January, 18, 23, 0, 0, 0, time.UTC)},
+		{"tzid", map[string]string{"TZID": "America/New_York"}, "19980119T020000", time.Date(1998, time.January, 19, 2, 0, 0, 0, newYork)},
+		{"unknown tzid", map[string]string{"TZID": "Mars/Olympus_Mons"}, "19980119T020000", time.Date(1998, time.January, 19, 2, 0, 0, 0, time.UTC)},
+		{"date", map[string]string{"VALUE": "DATE"}, "19970714", time.Date(1997, time.July, 14, 0, 0, 0, 0, time.UTC)},
+		{"date without value type", nil, "19970714", time.Date(1997, time.July, 14, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			property := Property{Name: "DUE", Params: test.params, Value: test.value}
+			parsed, err := property.Time()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !parsed.Equal(test.expected) {
+				t.Errorf("expected %s, got %s", test.expected, parsed)
+			}
+		})
+	}
+
+	if _, err := (Property{Name: "DUE", Value: "tomorrow"}).Time(); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
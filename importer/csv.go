@@ -0,0 +1,127 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+var csvFields = []string{"taskName", "taskDesc", "deadline", "taskIcon", "starred", "completed", "execRating", "execComment"}
+
+// parseCSV reads a CSV file with a header row, the row of a task is its line in the file
+func parseCSV(r io.Reader, options Options) ([]db.ImportTask, []db.ImportRowResult, error) {
+	reader := csv.NewReader(r)
+	if options.Delimiter != 0 {
+		reader.Comma = options.Delimiter
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while reading csv header: %w", err)
+	}
+	columns, err := csvColumns(header, options.Columns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tasks []db.ImportTask
+	var invalidRows []db.ImportRowResult
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			invalidRows = append(invalidRows, invalid(parseErr.Line, "%s", parseErr.Err))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		row, _ := reader.FieldPos(0)
+		task, err := csvTask(record, columns, options)
+		if err != nil {
+			invalidRows = append(invalidRows, invalid(row, "%s", err))
+			continue
+		}
+		task.Row = row
+		tasks = append(tasks, task)
+	}
+	return tasks, invalidRows, nil
+}
+
+// csvColumns returns the index of the column of every mapped field, a mapping to a missing column is an error
+func csvColumns(header []string, mapping map[string]string) (map[string]int, error) {
+	for field := range mapping {
+		if !slices.Contains(csvFields, field) {
+			return nil, fmt.Errorf("unknown field %q in column mapping", field)
+		}
+	}
+	columns := map[string]int{}
+	for _, field := range csvFields {
+		name, mapped := mapping[field]
+		if !mapped {
+			name = field
+		}
+		index := slices.IndexFunc(header, func(column string) bool {
+			return strings.EqualFold(strings.TrimSpace(column), name)
+		})
+		if index >= 0 {
+			columns[field] = index
+		} else if mapped {
+			return nil, fmt.Errorf("column %q mapped to %s is missing", name, field)
+		}
+	}
+	if _, ok := columns["taskName"]; !ok {
+		return nil, errors.New("no column for taskName, map it with the columns option")
+	}
+	return columns, nil
+}
+
+func csvTask(record []string, columns map[string]int, options Options) (db.ImportTask, error) {
+	value := func(field string) string {
+		index, ok := columns[field]
+		if !ok || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+	task := db.ImportTask{
+		TaskName: value("taskName"),
+		TaskDesc: value("taskDesc"),
+		TaskIcon: value("taskIcon"),
+	}
+	if task.TaskIcon == "" {
+		task.TaskIcon = options.Icon
+	}
+	var err error
+	if deadline := value("deadline"); deadline != "" {
+		task.Deadline, err = parseDate(deadline)
+		if err != nil {
+			return task, err
+		}
+	}
+	task.Starred, err = parseBool(value("starred"))
+	if err != nil {
+		return task, fmt.Errorf("starred: %w", err)
+	}
+	task.Completed, err = parseBool(value("completed"))
+	if err != nil {
+		return task, fmt.Errorf("completed: %w", err)
+	}
+	task.ExecRating, err = parseRating(value("execRating"))
+	if err != nil {
+		return task, err
+	}
+	if comment := value("execComment"); comment != "" {
+		task.ExecComment = &comment
+	}
+	return task, validate(&task)
+}
@@ -0,0 +1,89 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+func TestParseCSV(t *testing.T) {
+	runParseTests(t, FormatCSV, []parseTest{
+		{
+			name: "all fields",
+			input: "taskName,taskDesc,deadline,taskIcon,starred,completed,execRating,execComment\n" +
+				"Write report,Quarterly,2024-03-01T09:30:00+01:00,work,yes,x,3,went well\n" +
+				"\"Buy milk, eggs\",,2024-03-02,shopping,,,,\n",
+			tasks: []db.ImportTask{
+				{
+					Row:         2,
+					TaskName:    "Write report",
+					TaskDesc:    "Quarterly",
+					TaskIcon:    "work",
+					Deadline:    timePtr(2024, 3, 1, 8, 30),
+					Starred:     true,
+					Completed:   true,
+					ExecRating:  intPtr(3),
+					ExecComment: stringPtr("went well"),
+				},
+				{Row: 3, TaskName: "Buy milk, eggs", TaskIcon: "shopping", Deadline: timePtr(2024, 3, 2, 0, 0)},
+			},
+		},
+		{
+			name:    "mapped columns and delimiter",
+			input:   "Title; Due; Done\nCall mom;2024-03-01 18:00;no\n",
+			options: Options{Icon: "home", Delimiter: ';', Columns: map[string]string{"taskName": "title", "deadline": "due", "completed": "done"}},
+			tasks:   []db.ImportTask{{Row: 2, TaskName: "Call mom", TaskIcon: "home", Deadline: timePtr(2024, 3, 1, 18, 0)}},
+		},
+		{
+			name:    "duplicates are kept for the import to report",
+			input:   "taskName\nWater plants\nWater plants\n",
+			options: Options{Icon: "home"},
+			tasks:   []db.ImportTask{{Row: 2, TaskName: "Water plants", TaskIcon: "home"}, {Row: 3, TaskName: "Water plants", TaskIcon: "home"}},
+		},
+		{
+			name: "invalid rows",
+			input: "taskName,deadline,taskIcon,starred,completed,execRating\n" +
+				" ,,work,,,\n" +
+				"No icon,,,,,\n" +
+				"Bad date,tomorrow,work,,,\n" +
+				"Bad starred,,work,maybe,,\n" +
+				"Bad rating,,work,,yes,5\n" +
+				"Rating without completion,,work,,,2\n" +
+				"Bad \"quote,,work,,,\n" +
+				"Valid,,work,,,\n",
+			tasks: []db.ImportTask{{Row: 9, TaskName: "Valid", TaskIcon: "work"}},
+			invalid: []db.ImportRowResult{
+				invalid(2, "task name is empty"),
+				invalid(3, "task icon is empty"),
+				invalid(4, `invalid date "tomorrow", expected e.g. 2006-01-02 or 2006-01-02T15:04:05Z`),
+				invalid(5, `starred: invalid value "maybe", expected true or false`),
+				invalid(6, "rating must be between 1 and 3"),
+				invalid(7, "only completed tasks can have a rating or comment"),
+				invalid(8, `bare " in non-quoted-field`),
+			},
+		},
+	})
+}
+
+func TestParseCSVHeaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		columns map[string]string
+		err     string
+	}{
+		{"empty file", "", nil, "error while reading csv header: EOF"},
+		{"no task name column", "title\nCall mom\n", nil, "no column for taskName, map it with the columns option"},
+		{"unknown field", "title\n", map[string]string{"priority": "title"}, `unknown field "priority" in column mapping`},
+		{"missing mapped column", "taskName\n", map[string]string{"deadline": "due"}, `column "due" mapped to deadline is missing`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Parse(FormatCSV, strings.NewReader(test.input), Options{Columns: test.columns})
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
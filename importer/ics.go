@@ -0,0 +1,74 @@
+package importer
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/ical"
+)
+
+// parseICS reads the to-dos (VTODO) of a calendar, the row of a task is its position among them.
+// PRIORITY 1 to 4 (high) stars a task and the first category is its icon
+func parseICS(r io.Reader, options Options) ([]db.ImportTask, []db.ImportRowResult, error) {
+	calendars, err := ical.Parse(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tasks []db.ImportTask
+	var invalidRows []db.ImportRowResult
+	row := 0
+	for _, calendar := range calendars {
+		for _, todo := range calendar.Components {
+			if todo.Name != "VTODO" {
+				continue
+			}
+			row++
+			task, err := todoTask(todo, options)
+			if err != nil {
+				invalidRows = append(invalidRows, invalid(row, "%s", err))
+				continue
+			}
+			task.Row = row
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks, invalidRows, nil
+}
+
+func todoTask(todo *ical.Component, options Options) (db.ImportTask, error) {
+	task := db.ImportTask{TaskIcon: options.Icon}
+	if summary, ok := todo.Get("SUMMARY"); ok {
+		task.TaskName = summary.Text()
+	}
+	if description, ok := todo.Get("DESCRIPTION"); ok {
+		task.TaskDesc = description.Text()
+	}
+	if categories, ok := todo.Get("CATEGORIES"); ok {
+		if icon := strings.TrimSpace(categories.List()[0]); icon != "" {
+			task.TaskIcon = icon
+		}
+	}
+	if due, ok := todo.Get("DUE"); ok {
+		deadline, err := due.Time()
+		if err != nil {
+			return task, err
+		}
+		task.Deadline = &deadline
+	}
+	if priority, ok := todo.Get("PRIORITY"); ok {
+		value, err := strconv.Atoi(priority.Value)
+		if err == nil && value >= 1 && value <= 4 {
+			task.Starred = true
+		}
+	}
+	status, _ := todo.Get("STATUS")
+	if strings.EqualFold(status.Value, "CANCELLED") {
+		return task, errCancelled
+	}
+	_, hasCompleted := todo.Get("COMPLETED")
+	percent, _ := todo.Get("PERCENT-COMPLETE")
+	task.Completed = strings.EqualFold(status.Value, "COMPLETED") || hasCompleted || percent.Value == "100"
+	return task, validate(&task)
+}
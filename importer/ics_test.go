@@ -0,0 +1,105 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+func calendar(lines ...string) string {
+	return strings.Join(append(append([]string{"BEGIN:VCALENDAR", "VERSION:2.0"}, lines...), "END:VCALENDAR", ""), "\r\n")
+}
+
+func TestParseICS(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlinDeadline := time.Date(2024, time.March, 1, 9, 30, 0, 0, berlin).UTC()
+
+	runParseTests(t, FormatICS, []parseTest{
+		{
+			name: "properties",
+			input: calendar(
+				"BEGIN:VTODO",
+				`SUMMARY:Write report\, draft`,
+				"DESCRIPTION:Quarterly numbers\\nand a long descrip",
+				" tion folded over lines",
+				`CATEGORIES:work,office`,
+				"DUE;TZID=Europe/Berlin:20240301T093000",
+				"PRIORITY:1",
+				"END:VTODO",
+				"BEGIN:VEVENT",
+				"SUMMARY:Events are skipped",
+				"END:VEVENT",
+				"BEGIN:VTODO",
+				"SUMMARY:All day",
+				"DUE;VALUE=DATE:20240302",
+				"PRIORITY:5",
+				"END:VTODO",
+			),
+			options: Options{Icon: "home"},
+			tasks: []db.ImportTask{
+				{
+					Row:      1,
+					TaskName: "Write report, draft",
+					TaskDesc: "Quarterly numbers\nand a long description folded over lines",
+					TaskIcon: "work",
+					Deadline: &berlinDeadline,
+					Starred:  true,
+				},
+				{Row: 2, TaskName: "All day", TaskIcon: "home", Deadline: timePtr(2024, 3, 2, 0, 0)},
+			},
+		},
+		{
+			name: "completion",
+			input: calendar(
+				"BEGIN:VTODO", "SUMMARY:By status", "STATUS:COMPLETED", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:By date", "COMPLETED:20240301T100000Z", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:By percent", "PERCENT-COMPLETE:100", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:In progress", "STATUS:IN-PROCESS", "PERCENT-COMPLETE:50", "DUE:20240301T100000Z", "END:VTODO",
+			),
+			options: Options{Icon: "home"},
+			tasks: []db.ImportTask{
+				{Row: 1, TaskName: "By status", TaskIcon: "home", Completed: true},
+				{Row: 2, TaskName: "By date", TaskIcon: "home", Completed: true},
+				{Row: 3, TaskName: "By percent", TaskIcon: "home", Completed: true},
+				{Row: 4, TaskName: "In progress", TaskIcon: "home", Deadline: timePtr(2024, 3, 1, 10, 0)},
+			},
+		},
+		{
+			name: "duplicates are kept for the import to report",
+			input: calendar(
+				"BEGIN:VTODO", "SUMMARY:Water plants", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:Water plants", "END:VTODO",
+			),
+			options: Options{Icon: "home"},
+			tasks:   []db.ImportTask{{Row: 1, TaskName: "Water plants", TaskIcon: "home"}, {Row: 2, TaskName: "Water plants", TaskIcon: "home"}},
+		},
+		{
+			name: "invalid to-dos",
+			input: calendar(
+				"BEGIN:VTODO", "SUMMARY:Cancelled", "STATUS:CANCELLED", "END:VTODO",
+				"BEGIN:VTODO", "DESCRIPTION:No summary", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:Bad due", "DUE:tomorrow", "END:VTODO",
+				"BEGIN:VTODO", "SUMMARY:Valid", "END:VTODO",
+			),
+			options: Options{Icon: "home"},
+			tasks:   []db.ImportTask{{Row: 4, TaskName: "Valid", TaskIcon: "home"}},
+			invalid: []db.ImportRowResult{
+				invalid(1, "cancelled tasks aren't imported"),
+				invalid(2, "task name is empty"),
+				invalid(3, `parsing time "tomorrow" as "20060102": cannot parse "tomorrow" as "2006"`),
+			},
+		},
+	})
+}
+
+func TestParseICSNotCalendar(t *testing.T) {
+	if _, _, err := Parse(FormatICS, strings.NewReader("taskName\nCall mom\n"), Options{}); err == nil {
+		t.Error("expected an error for a file that isn't a calendar")
+	}
+}
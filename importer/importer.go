@@ -0,0 +1,122 @@
+// Package importer reads tasks from the files of other task applications
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+const (
+	FormatICS     = "ics"
+	FormatTodoTxt = "todotxt"
+	FormatCSV     = "csv"
+)
+
+var ErrUnsupportedFormat = errors.New("format must be ics, todotxt or csv")
+
+var errCancelled = errors.New("cancelled tasks aren't imported")
+
+type Options struct {
+	// Icon is used for tasks whose file doesn't say which icon (category) they have
+	Icon string
+	// Columns maps the fields of a task (taskName, taskDesc, deadline, taskIcon, starred, completed,
+	// execRating, execComment) to the CSV columns they are read from, fields that aren't mapped
+	// are read from the column with the same name
+	Columns map[string]string
+	// Delimiter separates CSV columns, a comma when zero
+	Delimiter rune
+}
+
+// Parse reads the tasks of a file, rows that can't be imported are returned as invalid results
+// and an error is only returned when the file as a whole can't be read
+func Parse(format string, r io.Reader, options Options) ([]db.ImportTask, []db.ImportRowResult, error) {
+	switch format {
+	case FormatICS:
+		return parseICS(r, options)
+	case FormatTodoTxt:
+		return parseTodoTxt(r, options)
+	case FormatCSV:
+		return parseCSV(r, options)
+	}
+	return nil, nil, ErrUnsupportedFormat
+}
+
+// FormatFromFilename guesses the format from the file extension
+func FormatFromFilename(filename string) string {
+	lower := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lower, ".ics"):
+		return FormatICS
+	case strings.HasSuffix(lower, ".txt"):
+		return FormatTodoTxt
+	case strings.HasSuffix(lower, ".csv"):
+		return FormatCSV
+	}
+	return ""
+}
+
+func invalid(row int, format string, args ...any) db.ImportRowResult {
+	return db.ImportRowResult{Row: row, Status: db.ImportInvalid, Error: fmt.Sprintf(format, args...)}
+}
+
+// validate checks the rules the api enforces for tasks and history entries
+func validate(task *db.ImportTask) error {
+	task.TaskName = strings.TrimSpace(task.TaskName)
+	if task.TaskName == "" {
+		return errors.New("task name is empty")
+	}
+	if task.TaskIcon == "" {
+		return errors.New("task icon is empty")
+	}
+	if !task.Completed && (task.ExecRating != nil || task.ExecComment != nil) {
+		return errors.New("only completed tasks can have a rating or comment")
+	}
+	if task.ExecRating != nil && (*task.ExecRating < 1 || *task.ExecRating > 3) {
+		return errors.New("rating must be between 1 and 3")
+	}
+	if task.Deadline != nil {
+		deadline := task.Deadline.UTC().Truncate(time.Second)
+		task.Deadline = &deadline
+	}
+	return nil
+}
+
+var dateFormats = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// parseDate accepts RFC 3339 and ISO 8601 dates with or without time, times without offset are UTC
+func parseDate(value string) (*time.Time, error) {
+	for _, format := range dateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %q, expected e.g. 2006-01-02 or 2006-01-02T15:04:05Z", value)
+}
+
+// parseBool accepts the usual ways spreadsheets mark a checkbox or a high priority
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "false", "no", "n", "low", "none":
+		return false, nil
+	case "1", "true", "yes", "y", "x", "*", "high", "a", "starred", "done", "completed":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid value %q, expected true or false", value)
+}
+
+func parseRating(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	rating, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rating %q", value)
+	}
+	return &rating, nil
+}
@@ -0,0 +1,65 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+func intPtr(value int) *int {
+	return &value
+}
+
+func stringPtr(value string) *string {
+	return &value
+}
+
+func timePtr(year int, month time.Month, day, hour, minute int) *time.Time {
+	t := time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	return &t
+}
+
+type parseTest struct {
+	name    string
+	input   string
+	options Options
+	tasks   []db.ImportTask
+	invalid []db.ImportRowResult
+}
+
+func runParseTests(t *testing.T, format string, tests []parseTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tasks, invalidRows, err := Parse(format, strings.NewReader(test.input), test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tasks, test.tasks) {
+				t.Errorf("expected tasks\n%+v\ngot\n%+v", test.tasks, tasks)
+			}
+			if !reflect.DeepEqual(invalidRows, test.invalid) {
+				t.Errorf("expected invalid rows\n%+v\ngot\n%+v", test.invalid, invalidRows)
+			}
+		})
+	}
+}
+
+func TestFormatFromFilename(t *testing.T) {
+	tests := map[string]string{
+		"calendar.ICS": FormatICS,
+		"todo.txt":     FormatTodoTxt,
+		"tasks.csv":    FormatCSV,
+		"tasks.xlsx":   "",
+	}
+	for filename, expected := range tests {
+		if format := FormatFromFilename(filename); format != expected {
+			t.Errorf("%s: expected %q, got %q", filename, expected, format)
+		}
+	}
+	if _, _, err := Parse("xlsx", strings.NewReader(""), Options{}); err != ErrUnsupportedFormat {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
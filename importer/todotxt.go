@@ -0,0 +1,77 @@
+package importer
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+var (
+	todoPriority = regexp.MustCompile(`^\(([A-Z])\) `)
+	todoDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(?: |$)`)
+)
+
+// parseTodoTxt reads a todo.txt file (https://github.com/todotxt/todo.txt), one task per line.
+// Priority A stars a task (pri:A on completed tasks), due:YYYY-MM-DD is the deadline and
+// projects and contexts stay in the task name
+func parseTodoTxt(r io.Reader, options Options) ([]db.ImportTask, []db.ImportRowResult, error) {
+	scanner := bufio.NewScanner(r)
+	var tasks []db.ImportTask
+	var invalidRows []db.ImportRowResult
+	row := 0
+	for scanner.Scan() {
+		row++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		task, err := todoTxtTask(line, options)
+		if err != nil {
+			invalidRows = append(invalidRows, invalid(row, "%s", err))
+			continue
+		}
+		task.Row = row
+		tasks = append(tasks, task)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return tasks, invalidRows, nil
+}
+
+func todoTxtTask(line string, options Options) (db.ImportTask, error) {
+	task := db.ImportTask{TaskIcon: options.Icon}
+	if rest, ok := strings.CutPrefix(line, "x "); ok {
+		task.Completed = true
+		line = rest
+		// completion date, followed by the creation date
+		line = todoDate.ReplaceAllString(line, "")
+	}
+	if match := todoPriority.FindStringSubmatch(line); match != nil {
+		task.Starred = match[1] == "A"
+		line = line[len(match[0]):]
+	}
+	line = todoDate.ReplaceAllString(line, "")
+
+	var words []string
+	for _, word := range strings.Fields(line) {
+		key, value, found := strings.Cut(word, ":")
+		switch {
+		case found && key == "due":
+			deadline, err := parseDate(value)
+			if err != nil {
+				return task, err
+			}
+			task.Deadline = deadline
+		case found && key == "pri":
+			task.Starred = value == "A"
+		default:
+			words = append(words, word)
+		}
+	}
+	task.TaskName = strings.Join(words, " ")
+	return task, validate(&task)
+}
@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"testing"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+func TestParseTodoTxt(t *testing.T) {
+	runParseTests(t, FormatTodoTxt, []parseTest{
+		{
+			name:    "priority, dates, projects and contexts",
+			input:   "(A) 2024-01-02 Call mom +family @phone due:2024-03-01\n(B) Clean garage\n",
+			options: Options{Icon: "home"},
+			tasks: []db.ImportTask{
+				{Row: 1, TaskName: "Call mom +family @phone", TaskIcon: "home", Deadline: timePtr(2024, 3, 1, 0, 0), Starred: true},
+				{Row: 2, TaskName: "Clean garage", TaskIcon: "home"},
+			},
+		},
+		{
+			name:    "completed",
+			input:   "x 2024-01-05 2024-01-02 Pay bills pri:A\nx Renew passport\n",
+			options: Options{Icon: "home"},
+			tasks: []db.ImportTask{
+				{Row: 1, TaskName: "Pay bills", TaskIcon: "home", Starred: true, Completed: true},
+				{Row: 2, TaskName: "Renew passport", TaskIcon: "home", Completed: true},
+			},
+		},
+		{
+			name:    "blank lines keep the line numbers",
+			input:   "\nFirst\r\n   \nSecond\n",
+			options: Options{Icon: "home"},
+			tasks:   []db.ImportTask{{Row: 2, TaskName: "First", TaskIcon: "home"}, {Row: 4, TaskName: "Second", TaskIcon: "home"}},
+		},
+		{
+			name:    "duplicates are kept for the import to report",
+			input:   "Water plants\nWater plants\n",
+			options: Options{Icon: "home"},
+			tasks:   []db.ImportTask{{Row: 1, TaskName: "Water plants", TaskIcon: "home"}, {Row: 2, TaskName: "Water plants", TaskIcon: "home"}},
+		},
+		{
+			name:    "invalid lines",
+			input:   "Submit form due:tomorrow\nx 2024-01-05\n(A) +project due:2024-13-01\nValid\n",
+			options: Options{Icon: "home"},
+			tasks:   []db.ImportTask{{Row: 4, TaskName: "Valid", TaskIcon: "home"}},
+			invalid: []db.ImportRowResult{
+				invalid(1, `invalid date "tomorrow", expected e.g. 2006-01-02 or 2006-01-02T15:04:05Z`),
+				invalid(2, "task name is empty"),
+				invalid(3, `invalid date "2024-13-01", expected e.g. 2006-01-02 or 2006-01-02T15:04:05Z`),
+			},
+		},
+		{
+			name:    "icon is required",
+			input:   "No icon\n",
+			invalid: []db.ImportRowResult{invalid(1, "task icon is empty")},
+		},
+	})
+}
@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Scheduler runs background jobs until it is stopped.
+// Stopping cancels the context passed to every job and waits for running jobs to return,
+// so it must happen before the resources the jobs use (e.g. the database pool) are closed
+type Scheduler struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Every runs job once per interval, the first run happens after the first interval has passed
+func (s *Scheduler) Every(name string, interval time.Duration, job func(ctx context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := job(s.ctx); err != nil {
+					slog.Error("background job failed", "job", name, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Go runs a long-running job (e.g. a listener) until the scheduler is stopped
+func (s *Scheduler) Go(name string, job func(ctx context.Context) error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := job(s.ctx); err != nil {
+			slog.Error("background job failed", "job", name, "error", err)
+		}
+	}()
+}
+
+// Stop cancels all jobs and waits until they return or ctx is done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
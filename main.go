@@ -2,26 +2,249 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/JovanZdravkovic/TaskJournalBackend/account"
 	"github.com/JovanZdravkovic/TaskJournalBackend/api"
+	"github.com/JovanZdravkovic/TaskJournalBackend/config"
 	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"github.com/JovanZdravkovic/TaskJournalBackend/events"
+	"github.com/JovanZdravkovic/TaskJournalBackend/export"
+	"github.com/JovanZdravkovic/TaskJournalBackend/jobs"
+	"github.com/JovanZdravkovic/TaskJournalBackend/logging"
+	"github.com/JovanZdravkovic/TaskJournalBackend/notify"
+	"github.com/JovanZdravkovic/TaskJournalBackend/push"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 func main() {
-	dbConnectionString := os.Getenv("DATABASE_URL")
-	dbPool, err := pgxpool.New(context.Background(), dbConnectionString)
+	if err := run(); err != nil {
+		slog.Error("server stopped with error", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg, err := config.Load()
 	if err != nil {
-		panic("Could not establish a database connection pool")
-	} else {
-		log.Println("Successfully connected to database")
+		return fmt.Errorf("invalid configuration: %w", err)
 	}
+	logLevel, _ := logging.ParseLevel(cfg.LogLevel)
+	slog.SetDefault(logging.NewLogger(os.Stdout, logLevel))
+	slog.Info("effective configuration", "config", cfg)
+
+	dbPool, err := pgxpool.New(context.Background(), cfg.DatabaseURL)
+	if err != nil {
+		return errors.New("could not establish a database connection pool")
+	}
+	slog.Info("successfully connected to database")
 	defer dbPool.Close()
-	dbService := db.NewDatabaseService(dbPool)
 
-	router := api.NewRouter(":8080")
-	router.ConfigureRoutes(dbService)
-	router.ListenAndServe()
+	if cfg.MigrateOnStartup {
+		if err := db.Migrate(context.Background(), dbPool); err != nil {
+			return fmt.Errorf("error while migrating database: %w", err)
+		}
+	}
+	db.RegisterPoolMetrics(dbPool)
+	broker := events.NewBroker(cfg.EventsReplayBuffer)
+	// with the postgres bus every instance receives the events published by the others
+	var publisher events.Publisher = broker
+	var bus *events.PostgresBus
+	if cfg.EventsBus == "postgres" {
+		bus = events.NewPostgresBus(dbPool, broker)
+		publisher = bus
+	}
+	dbService := db.NewDatabaseService(dbPool, db.Options{
+		TokenLifetime:     cfg.TokenLifetime,
+		BcryptCost:        cfg.BcryptCost,
+		BcryptConcurrency: cfg.BcryptConcurrency,
+		QueryTimeout:      cfg.QueryTimeout,
+
+		TombstoneRetention:     cfg.SyncTombstoneRetention,
+		IdempotencyKeyLifetime: cfg.IdempotencyKeyLifetime,
+		Publisher:              publisher,
+
+		AccountDeletionGracePeriod: cfg.AccountDeletionGracePeriod,
+
+		EmailNotifications: cfg.EmailEnabled(),
+		PushNotifications:  cfg.PushEnabled(),
+		PushAllowHTTP:      cfg.PushAllowHTTP,
+	})
+
+	var vapidKey *push.VAPIDKey
+	if cfg.PushEnabled() {
+		vapidKey, err = loadVAPIDKey(dbService, cfg.VAPIDPrivateKey)
+		if err != nil {
+			return fmt.Errorf("error while loading vapid key: %w", err)
+		}
+	}
+
+	exporter := &export.Exporter{
+		DBService:           dbService,
+		Directory:           cfg.ExportDirectory,
+		IconUploadDirectory: cfg.IconUploadDirectory,
+		Lifetime:            cfg.ExportLifetime,
+	}
+
+	deleter := &account.Deleter{
+		DBService:           dbService,
+		IconUploadDirectory: cfg.IconUploadDirectory,
+		Exporter:            exporter,
+	}
+
+	scheduler := jobs.NewScheduler()
+	scheduler.Every("session cleanup", cfg.SessionCleanupInterval, func(ctx context.Context) error {
+		deleted, err := dbService.DeleteExpiredTokens(ctx)
+		if err == nil {
+			slog.Debug("expired session tokens deleted", "count", deleted)
+		}
+		return err
+	})
+	scheduler.Every("sync tombstone cleanup", cfg.SyncTombstoneCleanupInterval, func(ctx context.Context) error {
+		deleted, err := dbService.DeleteExpiredTombstones(ctx)
+		if err == nil {
+			slog.Debug("expired sync tombstones deleted", "count", deleted)
+		}
+		return err
+	})
+	scheduler.Every("idempotency key cleanup", cfg.IdempotencyCleanupInterval, func(ctx context.Context) error {
+		deleted, err := dbService.DeleteExpiredIdempotencyKeys(ctx)
+		if err == nil {
+			slog.Debug("expired idempotency keys deleted", "count", deleted)
+		}
+		return err
+	})
+	scheduler.Every("account export", cfg.ExportPollInterval, exporter.RunPending)
+	scheduler.Every("account export cleanup", cfg.SessionCleanupInterval, func(ctx context.Context) error {
+		deleted, err := exporter.DeleteExpired(ctx)
+		if err == nil {
+			slog.Debug("expired account exports deleted", "count", deleted)
+		}
+		return err
+	})
+	scheduler.Every("account deletion", cfg.SessionCleanupInterval, func(ctx context.Context) error {
+		deleted, err := deleter.DeleteDue(ctx)
+		if deleted > 0 {
+			slog.Info("accounts deleted", "count", deleted)
+		}
+		return err
+	})
+	if cfg.EmailEnabled() {
+		mailer := &notify.Mailer{
+			DBService: dbService,
+			Address:   cfg.SMTPAddress,
+			Username:  cfg.SMTPUsername,
+			Password:  cfg.SMTPPassword,
+			From:      cfg.SMTPFrom,
+		}
+		scheduler.Every("notification email", cfg.EmailPollInterval, mailer.SendPending)
+	}
+	if vapidKey != nil {
+		sender := &push.Sender{
+			DBService: dbService,
+			Client: &push.Client{
+				Key:        vapidKey,
+				Subject:    cfg.VAPIDSubject,
+				HTTPClient: push.NewHTTPClient(30*time.Second, cfg.PushAllowHTTP),
+				TTL:        cfg.PushTTL,
+			},
+		}
+		scheduler.Every("push", cfg.PushPollInterval, sender.SendPending)
+	}
+	if cfg.ReminderLeadTime > 0 {
+		scheduler.Every("due reminders", cfg.ReminderInterval, func(ctx context.Context) error {
+			reminded, err := dbService.SendDueReminders(ctx, cfg.ReminderLeadTime)
+			if reminded > 0 {
+				slog.Debug("due tasks reminded", "count", reminded)
+			}
+			return err
+		})
+	}
+	if bus != nil {
+		scheduler.Go("event bus", bus.Run)
+	}
+
+	router := api.NewRouter(cfg.ListenAddress, api.Timeouts{
+		Read:       cfg.ReadTimeout,
+		ReadHeader: cfg.ReadHeaderTimeout,
+		Write:      cfg.WriteTimeout,
+		Idle:       cfg.IdleTimeout,
+	})
+	router.ConfigureRoutes(dbService, broker, exporter, vapidKey, cfg)
+
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serverErr := make(chan error, 2)
+	go func() {
+		slog.Info("listening", "address", cfg.ListenAddress)
+		serverErr <- router.ListenAndServe()
+	}()
+
+	var metricsServer *http.Server
+	if cfg.MetricsAddress != "" {
+		metricsServer = api.NewMetricsServer(cfg)
+		go func() {
+			slog.Info("metrics listening", "address", cfg.MetricsAddress)
+			err := metricsServer.ListenAndServe()
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			serverErr <- err
+		}()
+	} else if !cfg.MetricsEnabled() {
+		slog.Warn("metrics are disabled, set METRICS_ADDRESS or METRICS_TOKEN to expose /metrics")
+	}
+
+	var runErr error
+	select {
+	case runErr = <-serverErr:
+	case <-signalCtx.Done():
+		slog.Info("shutdown signal received, draining connections")
+		router.Drain()
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
+	stopSignals()
+
+	// Connections are drained first, then background jobs are stopped, and only then
+	// the deferred dbPool.Close() runs, so no request or job is left with a closed pool
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	// event streams never finish on their own, closing the broker ends them so shutdown doesn't wait for them
+	broker.Close()
+	if err := router.Shutdown(shutdownCtx); err != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("error while draining connections: %w", err))
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			runErr = errors.Join(runErr, fmt.Errorf("error while stopping metrics server: %w", err))
+		}
+	}
+	if err := scheduler.Stop(shutdownCtx); err != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("error while stopping background jobs: %w", err))
+	}
+	return runErr
+}
+
+// loadVAPIDKey parses the configured VAPID key, without one the key shared through the database is used
+// (generated by the first instance)
+func loadVAPIDKey(dbService *db.DatabaseService, privateKey string) (*push.VAPIDKey, error) {
+	if privateKey == "" {
+		generated, err := push.GenerateVAPIDKey()
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err = dbService.VAPIDPrivateKey(context.Background(), generated)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return push.ParseVAPIDKey(privateKey)
 }
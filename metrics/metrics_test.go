@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCounterOutput(t *testing.T) {
+	counter := NewCounterVec("test_requests_total", "Requests\nhandled", "method", "status")
+	counter.Inc("POST", "201")
+	counter.Inc("GET", "200")
+	counter.Add(2.5, "GET", "200")
+
+	var buf bytes.Buffer
+	counter.write(&buf)
+	expected := `# HELP test_requests_total Requests handled
+# TYPE test_requests_total counter
+test_requests_total{method="GET",status="200"} 3.5
+test_requests_total{method="POST",status="201"} 1
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected counter output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	counter := NewCounterVec("test_events_total", "Events")
+
+	var buf bytes.Buffer
+	counter.write(&buf)
+	expected := "# HELP test_events_total Events\n# TYPE test_events_total counter\ntest_events_total 0\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected counter output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestHistogramOutput(t *testing.T) {
+	histogram := NewHistogramVec("test_duration_seconds", "Duration", []float64{0.1, 1}, "route")
+	histogram.Observe(0.05, "/tasks")
+	histogram.Observe(0.5, "/tasks")
+	histogram.Observe(3, "/tasks")
+
+	var buf bytes.Buffer
+	histogram.write(&buf)
+	expected := `# HELP test_duration_seconds Duration
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/tasks",le="0.1"} 1
+test_duration_seconds_bucket{route="/tasks",le="1"} 2
+test_duration_seconds_bucket{route="/tasks",le="+Inf"} 3
+test_duration_seconds_sum{route="/tasks"} 3.55
+test_duration_seconds_count{route="/tasks"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected histogram output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`plain`, `{name="plain"}`},
+		{`back\slash`, `{name="back\\slash"}`},
+		{`"quoted"`, `{name="\"quoted\""}`},
+		{"new\nline", `{name="new\nline"}`},
+		{"\\\"\n", `{name="\\\"\n"}`},
+	}
+	for _, test := range tests {
+		if labels := renderLabels([]string{"name"}, []string{test.value}); labels != test.expected {
+			t.Errorf("renderLabels(%q) = %s, expected %s", test.value, labels, test.expected)
+		}
+	}
+}
+
+func TestRegistryOutput(t *testing.T) {
+	registry := NewRegistry()
+	registry.register(&GaugeFunc{metricName: "test_b", help: "B", metricType: "gauge", fn: func() float64 { return 2 }})
+	registry.register(&GaugeFunc{metricName: "test_a", help: "A", metricType: "counter", fn: func() float64 { return 1 }})
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+	expected := `# HELP test_a A
+# TYPE test_a counter
+test_a 1
+# HELP test_b B
+# TYPE test_b gauge
+test_b 2
+`
+	if recorder.Body.String() != expected {
+		t.Errorf("unexpected registry output:\n%s\nexpected:\n%s", recorder.Body.String(), expected)
+	}
+}
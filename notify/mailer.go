@@ -0,0 +1,147 @@
+// Package notify sends the notifications users chose to receive by email
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	// sendTimeout bounds the whole conversation with the SMTP server, so a stalled server doesn't
+	// hold up the other notifications or the shutdown
+	sendTimeout = 30 * time.Second
+)
+
+// Mailer sends the waiting email notifications, it is run periodically by the scheduler.
+// Notifications are claimed through the database, so every instance can run it
+type Mailer struct {
+	DBService *db.DatabaseService
+	// Address of the SMTP server as host:port
+	Address string
+	// Username and Password authenticate to the SMTP server, no authentication when Username is empty
+	Username string
+	Password string
+	From     string
+}
+
+// SendPending sends the waiting email notifications one after another until there are none left
+func (m *Mailer) SendPending(ctx context.Context) error {
+	for ctx.Err() == nil {
+		notification, err := m.DBService.ClaimEmailNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if notification == nil {
+			return nil
+		}
+		m.send(ctx, *notification)
+	}
+	return nil
+}
+
+func (m *Mailer) send(ctx context.Context, notification db.EmailNotification) {
+	err := m.sendMail(ctx, notification.Email, m.message(notification))
+	if err != nil {
+		slog.WarnContext(ctx, "error while sending email notification", "notification_id", notification.Id, "attempt", notification.Attempts, "error", err)
+		if notification.Attempts < db.MaxEmailAttempts {
+			// the notification is claimed again once its claim is stale
+			return
+		}
+		slog.ErrorContext(ctx, "email notification dropped", "notification_id", notification.Id)
+	}
+	if err := m.DBService.CompleteEmailNotification(ctx, notification.Id); err != nil {
+		slog.ErrorContext(ctx, "error while completing email notification", "notification_id", notification.Id, "error", err)
+	}
+}
+
+// sendMail works like smtp.SendMail, but the connection is dialed with a timeout, has a deadline and
+// is closed when ctx is canceled
+func (m *Mailer) sendMail(ctx context.Context, to string, message []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", m.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(m.Address)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Hello("localhost"); err != nil {
+		return err
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth := m.auth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp server %s doesn't support AUTH", m.Address)
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (m *Mailer) auth() smtp.Auth {
+	if m.Username == "" {
+		return nil
+	}
+	host, _, _ := net.SplitHostPort(m.Address)
+	return smtp.PlainAuth("", m.Username, m.Password, host)
+}
+
+// message is the email of the notification, the message of the notification is both its subject and body
+func (m *Mailer) message(notification db.EmailNotification) []byte {
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", m.From)
+	fmt.Fprintf(&message, "To: %s\r\n", notification.Email)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", notification.Message))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(notification.Message + "\r\n")
+	return []byte(message.String())
+}
@@ -0,0 +1,112 @@
+// Package push sends notifications to browsers through the Web Push protocol (RFC 8030), with
+// payloads encrypted for the browser (RFC 8291) and signed with a VAPID key (RFC 8292)
+package push
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+// ErrSubscriptionGone is returned when the push service no longer knows the subscription (404 or 410),
+// the browser unsubscribed or the subscription expired
+var ErrSubscriptionGone = errors.New("push subscription is gone")
+
+// StatusError is a response of the push service that rejected the message
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("push service responded with %d: %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether sending the message again can succeed, the push service was overloaded or failed
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// NetworkError is returned when the push service couldn't be reached, sending the message again can succeed
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// Subscription is where a push message is sent, the endpoint of the push service and the keys of the browser
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+type Client struct {
+	Key *VAPIDKey
+	// Subject is a mailto: or https: URL push services can contact the operator at
+	Subject string
+	// HTTPClient should be created by NewHTTPClient, which refuses private addresses
+	HTTPClient *http.Client
+	// TTL is how long the push service keeps a message for a browser that is offline
+	TTL time.Duration
+}
+
+// Send encrypts the payload for the subscription and posts it to its push service
+func (c *Client) Send(ctx context.Context, subscription Subscription, payload []byte) error {
+	p256dh, err := db.DecodePushKey(subscription.P256dh)
+	if err != nil {
+		return err
+	}
+	auth, err := db.DecodePushKey(subscription.Auth)
+	if err != nil {
+		return err
+	}
+	body, err := Encrypt(payload, p256dh, auth)
+	if err != nil {
+		return err
+	}
+	authorization, err := c.Key.authorization(subscription.Endpoint, c.Subject, time.Now().Add(12*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Content-Encoding", "aes128gcm")
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("TTL", strconv.Itoa(int(c.TTL.Seconds())))
+	request.Header.Set("Urgency", "normal")
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		if errors.Is(err, ErrPrivateAddress) {
+			return err
+		}
+		return &NetworkError{Err: err}
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return nil
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+	return &StatusError{StatusCode: response.StatusCode, Body: string(message)}
+}
@@ -0,0 +1,99 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// recordSize is the record size in the header of a push message (RFC 8188), the message is a single record
+const recordSize = 4096
+
+// maxBodySize is the largest body push services have to accept (RFC 8291 section 4)
+const maxBodySize = 4096
+
+// headerSize is the salt, the record size, the key id length and the public key of the server as key id
+const headerSize = 16 + 4 + 1 + 65
+
+// MaxPayloadSize is the largest payload whose body fits maxBodySize with the header, the delimiter and the tag
+const MaxPayloadSize = maxBodySize - headerSize - 16 - 1
+
+var ErrPayloadTooLarge = errors.New("push payload is too large")
+
+// Encrypt encrypts the payload for the browser with the p256dh public key and auth secret of its
+// subscription, as the body of an aes128gcm push message (RFC 8291)
+func Encrypt(payload []byte, p256dh []byte, auth []byte) ([]byte, error) {
+	serverKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return encrypt(payload, p256dh, auth, serverKey, salt)
+}
+
+// encrypt takes the ephemeral key and the salt so the result can be checked against RFC 8291 appendix A
+func encrypt(payload []byte, p256dh []byte, auth []byte, serverKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	browserKey, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := serverKey.ECDH(browserKey)
+	if err != nil {
+		return nil, err
+	}
+	serverPublic := serverKey.PublicKey().Bytes()
+
+	// the input keying material combines the shared secret with the auth secret and both public keys
+	keyInfo := append([]byte("WebPush: info\x00"), p256dh...)
+	keyInfo = append(keyInfo, serverPublic...)
+	ikm, err := expand(hkdf.Extract(sha256.New, sharedSecret, auth), keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentKey, err := expand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := expand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// the header is the salt, the record size and the public key of the server as key id
+	body := make([]byte, 0, 16+4+1+len(serverPublic)+len(payload)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, recordSize)
+	body = append(body, byte(len(serverPublic)))
+	body = append(body, serverPublic...)
+	// 0x02 marks the last (and only) record
+	plaintext := append(append(make([]byte, 0, len(payload)+1), payload...), 0x02)
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
+
+func expand(prk []byte, info []byte, length int) ([]byte, error) {
+	key := make([]byte, length)
+	_, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), key)
+	return key, err
+}
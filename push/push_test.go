@@ -0,0 +1,324 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+	"golang.org/x/crypto/hkdf"
+)
+
+func decodeBase64(t *testing.T, value string) []byte {
+	t.Helper()
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("invalid base64url %q: %v", value, err)
+	}
+	return decoded
+}
+
+// TestEncryptVector checks the encryption against the example of RFC 8291 appendix A
+func TestEncryptVector(t *testing.T) {
+	serverKey, err := ecdh.P256().NewPrivateKey(decodeBase64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := encrypt(
+		[]byte("When I grow up, I want to be a watermelon"),
+		decodeBase64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"),
+		decodeBase64(t, "BTBZMqHH6r4Tts7J_aSIgg"),
+		serverKey,
+		decodeBase64(t, "DGv6ra1nlYgDCS1FRnbzlw"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if encoded := base64.RawURLEncoding.EncodeToString(body); encoded != expected {
+		t.Errorf("encrypted body is %s, expected %s", encoded, expected)
+	}
+}
+
+// standIn is a local push service that checks the VAPID signature and decrypts the messages it receives
+type standIn struct {
+	t          *testing.T
+	browserKey *ecdh.PrivateKey
+	auth       []byte
+	vapidKey   *ecdsa.PublicKey
+	status     int
+	received   [][]byte
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+		s.t.Errorf("unexpected headers %v", r.Header)
+	}
+	s.checkAuthorization(r.Header.Get("Authorization"), "http://"+r.Host)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.received = append(s.received, s.decrypt(body))
+	w.WriteHeader(s.status)
+}
+
+func (s *standIn) checkAuthorization(authorization string, audience string) {
+	var token, key string
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, "vapid "), ", ") {
+		if value, ok := strings.CutPrefix(part, "t="); ok {
+			token = value
+		}
+		if value, ok := strings.CutPrefix(part, "k="); ok {
+			key = value
+		}
+	}
+	parsedKey, err := ecdh.P256().NewPublicKey(decodeBase64(s.t, key))
+	if err != nil || !bytes.Equal(parsedKey.Bytes(), s.vapidPublicKey()) {
+		s.t.Fatalf("authorization has key %q", key)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		s.t.Fatalf("invalid jwt %q", token)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature := decodeBase64(s.t, parts[2])
+	r, sig := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(s.vapidKey, digest[:], r, sig) {
+		s.t.Errorf("invalid vapid signature")
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(decodeBase64(s.t, parts[1]), &claims); err != nil {
+		s.t.Fatal(err)
+	}
+	if claims.Aud != audience || claims.Sub != "mailto:admin@example.com" || claims.Exp <= time.Now().Unix() {
+		s.t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func (s *standIn) vapidPublicKey() []byte {
+	key, err := s.vapidKey.ECDH()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return key.Bytes()
+}
+
+// decrypt reverses RFC 8291 with the private key of the browser
+func (s *standIn) decrypt(body []byte) []byte {
+	salt, keyIdLength := body[:16], int(body[20])
+	if binary.BigEndian.Uint32(body[16:20]) != recordSize {
+		s.t.Errorf("unexpected record size")
+	}
+	serverPublic := body[21 : 21+keyIdLength]
+	serverKey, err := ecdh.P256().NewPublicKey(serverPublic)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	sharedSecret, err := s.browserKey.ECDH(serverKey)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	keyInfo := append([]byte("WebPush: info\x00"), s.browserKey.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, serverPublic...)
+	ikm := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, sharedSecret, s.auth, keyInfo), ikm)
+	contentKey := make([]byte, 16)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), contentKey)
+	nonce := make([]byte, 12)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce)
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, nonce, body[21+keyIdLength:], nil)
+	if err != nil {
+		s.t.Fatalf("message can't be decrypted: %v", err)
+	}
+	if plaintext[len(plaintext)-1] != 0x02 {
+		s.t.Errorf("message isn't a last record")
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+func newStandIn(t *testing.T, key *VAPIDKey, status int) (*standIn, Subscription) {
+	browserKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	vapidKey, err := ecdh.P256().NewPublicKey(decodeBase64(t, key.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(vapidKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &standIn{t: t, browserKey: browserKey, auth: auth, vapidKey: parsed.(*ecdsa.PublicKey), status: status}
+	return service, Subscription{
+		P256dh: base64.RawURLEncoding.EncodeToString(browserKey.PublicKey().Bytes()),
+		Auth:   base64.RawURLEncoding.EncodeToString(auth),
+	}
+}
+
+func TestClientSend(t *testing.T) {
+	privateKey, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseVAPIDKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{Key: key, Subject: "mailto:admin@example.com", HTTPClient: http.DefaultClient, TTL: time.Hour}
+
+	cases := []struct {
+		status int
+		check  func(err error) bool
+	}{
+		{http.StatusCreated, func(err error) bool { return err == nil }},
+		{http.StatusGone, func(err error) bool { return errors.Is(err, ErrSubscriptionGone) }},
+		{http.StatusNotFound, func(err error) bool { return errors.Is(err, ErrSubscriptionGone) }},
+		{http.StatusTooManyRequests, func(err error) bool {
+			var statusError *StatusError
+			return errors.As(err, &statusError) && statusError.Retryable()
+		}},
+		{http.StatusBadRequest, func(err error) bool {
+			var statusError *StatusError
+			return errors.As(err, &statusError) && !statusError.Retryable()
+		}},
+	}
+	for _, c := range cases {
+		service, subscription := newStandIn(t, key, c.status)
+		server := httptest.NewServer(service)
+		subscription.Endpoint = server.URL + "/push/subscription"
+		payload := []byte(`{"message":"ana mentioned you in a comment on Groceries"}`)
+
+		err := client.Send(context.Background(), subscription, payload)
+		server.Close()
+		if !c.check(err) {
+			t.Errorf("status %d: unexpected error %v", c.status, err)
+		}
+		if len(service.received) != 1 || !bytes.Equal(service.received[0], payload) {
+			t.Errorf("status %d: stand-in received %q", c.status, service.received)
+		}
+	}
+}
+
+func TestEncryptRejectsLargePayload(t *testing.T) {
+	browserKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Encrypt(make([]byte, MaxPayloadSize+1), browserKey.PublicKey().Bytes(), make([]byte, 16))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("expected ErrPayloadTooLarge, got %v", err)
+	}
+}
+
+func TestEncryptMaxPayloadFitsBody(t *testing.T) {
+	browserKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := Encrypt(make([]byte, MaxPayloadSize), browserKey.PublicKey().Bytes(), make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 4096 {
+		t.Errorf("expected a body of 4096 bytes, got %d", len(body))
+	}
+	// queued messages are fitted to the size in db, which can't import this package
+	if db.MaxPushPayloadSize != MaxPayloadSize {
+		t.Errorf("expected db.MaxPushPayloadSize to be %d, got %d", MaxPayloadSize, db.MaxPushPayloadSize)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::1":   true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"0.0.0.0":              false,
+		"100.64.0.1":           false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.216.34": true,
+		"224.0.0.1":            false,
+	}
+	for address, public := range cases {
+		if publicAddress(netip.MustParseAddr(address)) != public {
+			t.Errorf("%s: expected public to be %v", address, public)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddress(t *testing.T) {
+	privateKey, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseVAPIDKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, subscription := newStandIn(t, key, http.StatusCreated)
+	server := httptest.NewServer(service)
+	defer server.Close()
+	subscription.Endpoint = server.URL + "/push/subscription"
+	payload := []byte(`{"message":"reminder"}`)
+
+	client := &Client{Key: key, Subject: "mailto:admin@example.com", HTTPClient: NewHTTPClient(time.Second, false), TTL: time.Hour}
+	err = client.Send(context.Background(), subscription, payload)
+	var networkError *NetworkError
+	if !errors.Is(err, ErrPrivateAddress) || errors.As(err, &networkError) {
+		t.Errorf("expected ErrPrivateAddress, got %v", err)
+	}
+	if len(service.received) != 0 {
+		t.Errorf("stand-in received %q", service.received)
+	}
+
+	client.HTTPClient = NewHTTPClient(time.Second, true)
+	if err := client.Send(context.Background(), subscription, payload); err != nil {
+		t.Errorf("expected the stand-in to be reachable with allowPrivate, got %v", err)
+	}
+}
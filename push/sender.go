@@ -0,0 +1,74 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/JovanZdravkovic/TaskJournalBackend/db"
+)
+
+// Sender sends the waiting push messages, it is run periodically by the scheduler.
+// Messages are claimed through the database, so every instance can run it
+type Sender struct {
+	DBService *db.DatabaseService
+	Client    *Client
+}
+
+// SendPending sends the waiting push messages one after another until there are none left
+func (s *Sender) SendPending(ctx context.Context) error {
+	for ctx.Err() == nil {
+		delivery, err := s.DBService.ClaimPushDelivery(ctx)
+		if err != nil {
+			return err
+		}
+		if delivery == nil {
+			return nil
+		}
+		s.send(ctx, *delivery)
+	}
+	return nil
+}
+
+func (s *Sender) send(ctx context.Context, delivery db.PushDelivery) {
+	// a message the push service would already have dropped isn't sent late
+	if time.Since(delivery.CreatedAt) > s.Client.TTL {
+		s.complete(ctx, delivery)
+		return
+	}
+	subscription := Subscription{Endpoint: delivery.Endpoint, P256dh: delivery.P256dh, Auth: delivery.Auth}
+	err := s.Client.Send(ctx, subscription, []byte(delivery.Payload))
+	if err == nil {
+		s.complete(ctx, delivery)
+		return
+	}
+	if ctx.Err() != nil {
+		// shutting down, the message is claimed again once its claim is stale
+		return
+	}
+	if errors.Is(err, ErrSubscriptionGone) {
+		slog.InfoContext(ctx, "push subscription removed", "subscription_id", delivery.SubscriptionId)
+		if err := s.DBService.RemovePushSubscription(ctx, delivery.SubscriptionId); err != nil {
+			slog.ErrorContext(ctx, "error while removing push subscription", "subscription_id", delivery.SubscriptionId, "error", err)
+		}
+		return
+	}
+	slog.WarnContext(ctx, "error while sending push message", "delivery_id", delivery.Id, "attempt", delivery.Attempts, "error", err)
+	// only failures of the network or the push service are retried, a payload, keys or an endpoint
+	// that can't be sent to now won't work later
+	var statusError *StatusError
+	var networkError *NetworkError
+	retryable := errors.As(err, &networkError) || errors.As(err, &statusError) && statusError.Retryable()
+	if retryable && delivery.Attempts < db.MaxPushAttempts {
+		return
+	}
+	slog.ErrorContext(ctx, "push message dropped", "delivery_id", delivery.Id)
+	s.complete(ctx, delivery)
+}
+
+func (s *Sender) complete(ctx context.Context, delivery db.PushDelivery) {
+	if err := s.DBService.CompletePushDelivery(ctx, delivery.Id); err != nil {
+		slog.ErrorContext(ctx, "error while completing push delivery", "delivery_id", delivery.Id, "error", err)
+	}
+}
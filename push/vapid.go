@@ -0,0 +1,89 @@
+package push
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// VAPIDKey identifies the server to push services (RFC 8292), browsers only accept messages signed
+// with the key they subscribed with
+type VAPIDKey struct {
+	private *ecdsa.PrivateKey
+	// PublicKey is the uncompressed public key as unpadded base64url, the applicationServerKey of subscriptions
+	PublicKey string
+}
+
+// GenerateVAPIDKey returns a new private key encoded like ParseVAPIDKey expects it
+func GenerateVAPIDKey() (string, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+}
+
+// ParseVAPIDKey parses a P-256 private key encoded as unpadded base64url, the format web-push libraries use
+func ParseVAPIDKey(privateKey string) (*VAPIDKey, error) {
+	scalar, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vapid private key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(scalar)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vapid private key: %w", err)
+	}
+	// crypto/ecdh can't sign, the key is converted to an ecdsa key through PKCS #8
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return &VAPIDKey{
+		private:   parsed.(*ecdsa.PrivateKey),
+		PublicKey: base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+// authorization is the Authorization header of a push message sent to endpoint, a JWT signed with ES256
+// for the origin of the push service
+func (k *VAPIDKey) authorization(endpoint string, subject string, expiresAt time.Time) (string, error) {
+	endpointUrl, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"aud": endpointUrl.Scheme + "://" + endpointUrl.Host,
+		"exp": expiresAt.Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, k.private, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS signatures are r and s as two 32 byte big endian integers
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return "vapid t=" + token + ", k=" + k.PublicKey, nil
+}